@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -13,7 +14,9 @@ import (
 	"time"
 
 	"github.com/caarlos0/env/v6"
-	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 
 	"logogger/internal/crypt"
 	"logogger/internal/poller"
@@ -31,12 +34,32 @@ var (
 type config struct {
 	RawPollInterval   string        `json:"poll_interval"`
 	RawReportInterval string        `json:"report_interval"`
+	RawQueueMaxAge    string        `json:"queue_max_age"`
+	RawRetryInitial   string        `json:"retry_initial_interval"`
+	RawRetryMax       string        `json:"retry_max_interval"`
+	RawBreakerCool    string        `json:"breaker_cooldown"`
 	ConfigFilePath    string        `enc:"CONFIG"`
 	CryptoKey         string        `env:"CRYPTO_KEY" json:"crypto_key"`
 	ReportHost        string        `env:"ADDRESS" json:"report_host"`
 	Key               string        `env:"KEY" json:"key"`
+	Token             string        `env:"TOKEN" json:"token"`
+	Transport         string        `env:"TRANSPORT" json:"transport"`
+	QueueDir          string        `env:"QUEUE_DIR" json:"queue_dir"`
+	TLSCert           string        `env:"TLS_CERT" json:"tls_cert"`
+	TLSKey            string        `env:"TLS_KEY" json:"tls_key"`
+	TLSCA             string        `env:"TLS_CA" json:"tls_ca"`
+	RealIPHeader      string        `env:"REAL_IP_HEADER" json:"real_ip_header"`
 	PollInterval      time.Duration `env:"POLL_INTERVAL"`
 	ReportInterval    time.Duration `env:"REPORT_INTERVAL"`
+	QueueMaxAge       time.Duration `env:"QUEUE_MAX_AGE"`
+	QueueMaxSize      int64         `env:"QUEUE_MAX_SIZE" json:"queue_max_size"`
+	RetryInitial      time.Duration `env:"RETRY_INITIAL_INTERVAL"`
+	RetryMax          time.Duration `env:"RETRY_MAX_INTERVAL"`
+	BreakerCooldown   time.Duration `env:"BREAKER_COOLDOWN"`
+	RetryJitter       float64       `env:"RETRY_JITTER" json:"retry_jitter"`
+	RetryMaxAttempts  int           `env:"RETRY_MAX_ATTEMPTS" json:"retry_max_attempts"`
+	BreakerThreshold  int           `env:"BREAKER_THRESHOLD" json:"breaker_threshold"`
+	LegacySignature   bool          `env:"LEGACY_SIGNATURE" json:"legacy_signature"`
 }
 
 var cfg config
@@ -44,9 +67,38 @@ var cfg config
 func init() {
 	flag.DurationVar(&cfg.PollInterval, "p", 2*time.Second, "Interval of metrics polling")
 	flag.DurationVar(&cfg.ReportInterval, "r", 10*time.Second, "Interval of metrics reporting")
-	flag.StringVar(&cfg.CryptoKey, "crypto-key", "", "Path to file with public encryption key")
+	flag.StringVar(&cfg.CryptoKey, "crypto-key", "", "Path to file with public encryption key (RSA, X25519 or P-256)")
 	flag.StringVar(&cfg.ReportHost, "a", "localhost:8080", "Address of the server to report metrics to")
 	flag.StringVar(&cfg.Key, "k", "", "Secret key to sign metrics (should be shared between server and agent)")
+	flag.BoolVar(&cfg.LegacySignature, "legacy-signature", false, "Sign every metrics in batches separately without replay protection (for servers without batch signature support)")
+	flag.StringVar(&cfg.Token, "token", "", "API token to authenticate on the server (not sent if empty)")
+	flag.StringVar(&cfg.Transport, "transport", "http", "Transport to report metrics with (http or grpc)")
+	flag.StringVar(&cfg.TLSCert, "tls-cert", "", "Path to client TLS certificate (presented to the server if set)")
+	flag.StringVar(&cfg.TLSKey, "tls-key", "", "Path to client TLS private key")
+	flag.StringVar(&cfg.TLSCA, "tls-ca", "", "Path to CA bundle to verify the server certificate (system pool is used if empty)")
+	flag.StringVar(&cfg.RealIPHeader, "real-ip-header", "X-Real-IP", "Header to report agent address to the server (disabled if empty)")
+	flag.StringVar(&cfg.QueueDir, "queue-dir", "", "Directory to spool metrics, which could not be sent (disabled if empty)")
+	flag.Int64Var(&cfg.QueueMaxSize, "queue-max-size", 64<<20, "Maximum size of spooled metrics in bytes (unlimited if zero)")
+	flag.DurationVar(&cfg.QueueMaxAge, "queue-max-age", 24*time.Hour, "Maximum age of spooled metrics (unlimited if zero)")
+	retry := reporter.DefaultRetryPolicy()
+	flag.DurationVar(&cfg.RetryInitial, "retry-initial-interval", retry.InitialInterval, "Delay before the first retry of failed request")
+	flag.DurationVar(&cfg.RetryMax, "retry-max-interval", retry.MaxInterval, "Maximum delay between retries of failed request")
+	flag.Float64Var(&cfg.RetryJitter, "retry-jitter", retry.Jitter, "Fraction of retry delay, which is randomized")
+	flag.IntVar(&cfg.RetryMaxAttempts, "retry-max-attempts", retry.MaxAttempts, "Maximum number of attempts to send request")
+	flag.IntVar(&cfg.BreakerThreshold, "breaker-threshold", 5, "Number of consecutive failures to stop sending requests (disabled if zero)")
+	flag.DurationVar(&cfg.BreakerCooldown, "breaker-cooldown", 30*time.Second, "Time to wait before sending requests after the breaker is open")
+}
+
+// parseRawDuration parses optional duration from the config file
+func parseRawDuration(raw string, target *time.Duration) {
+	if raw == "" {
+		return
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Fatal("Could not parse config file : ", err)
+	}
+	*target = value
 }
 
 func main() {
@@ -75,6 +127,10 @@ func main() {
 		if err != nil {
 			log.Fatal("Could not parse config file : ", err)
 		}
+		parseRawDuration(cfg.RawQueueMaxAge, &cfg.QueueMaxAge)
+		parseRawDuration(cfg.RawRetryInitial, &cfg.RetryInitial)
+		parseRawDuration(cfg.RawRetryMax, &cfg.RetryMax)
+		parseRawDuration(cfg.RawBreakerCool, &cfg.BreakerCooldown)
 	}
 
 	// preserve order
@@ -91,11 +147,23 @@ func main() {
 		os.Exit(1)
 	}
 
+	var tlsConfig *tls.Config
+	if cfg.TLSCert != "" || cfg.TLSKey != "" || cfg.TLSCA != "" {
+		tlsConfig, err = crypt.ClientTLSConfig(cfg.TLSCert, cfg.TLSKey, cfg.TLSCA)
+		if err != nil {
+			log.Fatal("Could not setup TLS : ", err)
+		}
+	}
+
 	var reportHost = cfg.ReportHost
 
 	r := regexp.MustCompile(`https?://`)
 	if !r.MatchString(cfg.ReportHost) {
-		reportHost = fmt.Sprintf("http://%s", reportHost)
+		scheme := "http"
+		if tlsConfig != nil {
+			scheme = "https"
+		}
+		reportHost = fmt.Sprintf("%s://%s", scheme, reportHost)
 	}
 
 	pollTicker := time.NewTicker(cfg.PollInterval)
@@ -123,12 +191,65 @@ func main() {
 		}
 	}), time.Minute)()
 
-	reporter := reporter.NewReporter(encryptor)
+	var transport reporter.Transport
+	switch cfg.Transport {
+	case "http", "":
+		httpTransport := reporter.NewHTTPTransport(reportHost, encryptor).WithRealIPHeader(cfg.RealIPHeader).WithToken(cfg.Token).WithKey(cfg.Key)
+		if tlsConfig != nil {
+			httpTransport = httpTransport.WithTLSConfig(tlsConfig)
+		}
+		transport = httpTransport
+	case "grpc":
+		// gRPC address is expected without scheme
+		creds := insecure.NewCredentials()
+		if tlsConfig != nil {
+			creds = credentials.NewTLS(tlsConfig)
+		}
+		opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+		if cfg.Token != "" {
+			opts = append(opts, grpc.WithPerRPCCredentials(reporter.TokenCredentials(cfg.Token)))
+		}
+		conn, err_ := grpc.Dial(r.ReplaceAllString(cfg.ReportHost, ""), opts...)
+		if err_ != nil {
+			log.Fatal("Could not connect to gRPC server : ", err_)
+		}
+		defer conn.Close()
+		transport = reporter.NewGRPCTransport(conn).WithKey(cfg.Key)
+	default:
+		log.Fatalf("Unknown transport %s", cfg.Transport)
+	}
+
+	policy := reporter.DefaultRetryPolicy()
+	policy.InitialInterval = cfg.RetryInitial
+	policy.MaxInterval = cfg.RetryMax
+	policy.Jitter = cfg.RetryJitter
+	policy.MaxAttempts = cfg.RetryMaxAttempts
+	if err = policy.Validate(); err != nil {
+		log.Fatal("Invalid retry settings : ", err)
+	}
+	var breaker *reporter.CircuitBreaker
+	if cfg.BreakerThreshold > 0 {
+		breaker = reporter.NewCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown)
+	}
+	transport = reporter.NewRetryingTransport(transport, policy, breaker)
+
+	rep := reporter.NewReporter(transport).WithSignature(cfg.Key, cfg.LegacySignature)
+	if cfg.QueueDir != "" {
+		queue, err_ := reporter.NewDiskQueue(cfg.QueueDir, cfg.QueueMaxSize, cfg.QueueMaxAge)
+		if err_ != nil {
+			log.Fatal("Could not open queue : ", err_)
+		}
+		rep = rep.WithQueue(queue)
+	}
+
+	go utils.RetryForever(utils.WrapGoroutinePanic(func() error {
+		return rep.ReportMetadata(ctx, p.Metadata())
+	}), cfg.ReportInterval)()
 
 	go utils.RetryForever(utils.WrapGoroutinePanic(func() error {
 		for {
 			<-reportTicker.C
-			err := report(reporter, metrics, reportHost, cfg.Key)
+			err := rep.ReportMetricsBatches(context.Background(), metrics)
 			if err == nil {
 				err = p.Reset(ctx)
 				if err != nil {
@@ -147,30 +268,5 @@ func main() {
 	log.Println("Exiting agent gracefully...")
 	pollTicker.Stop()
 	reportTicker.Stop()
-	reporter.Shutdown()
-}
-
-func report(poller *reporter.Reporter, l []schema.Metrics, host string, key string) error {
-	if key != "" {
-		eg := errgroup.Group{}
-		var signed []schema.Metrics
-		for _, m := range l {
-			m := m
-			eg.Go(utils.WrapGoroutinePanic(func() error {
-				err := m.Sign(key)
-				if err != nil {
-					return err
-				}
-				signed = append(signed, m)
-				return nil
-			}))
-		}
-		if err := eg.Wait(); err != nil {
-			return err
-		}
-		l = signed
-	}
-
-	err := poller.ReportMetricsBatches(context.Background(), l, host)
-	return err
+	rep.Shutdown()
 }
@@ -1,25 +1,30 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/caarlos0/env/v6"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	"logogger/internal/crypt"
 	"logogger/internal/dumper"
 	"logogger/internal/schema"
 	"logogger/internal/server"
+	"logogger/internal/statsd"
 	"logogger/internal/storage"
 	"logogger/internal/utils"
 )
@@ -31,28 +36,80 @@ var (
 )
 
 type config struct {
-	RawStoreInterval string        `json:"store_interval"`
-	Address          string        `env:"ADDRESS" json:"address"`
-	ConfigFilePath   string        `enc:"CONFIG"`
-	CryptoKey        string        `env:"CRYPTO_KEY" json:"crypto_key"`
-	StoreFile        string        `env:"STORE_FILE" json:"store_file"`
-	Key              string        `env:"KEY" json:"key"`
-	DatabaseDSN      string        `env:"DATABASE_DSN" json:"database_dsn"`
-	StoreInterval    time.Duration `env:"STORE_INTERVAL"`
-	Restore          bool          `env:"RESTORE" json:"restore"`
+	RawStoreInterval    string        `json:"store_interval"`
+	RawHistoryRetention string        `json:"history_retention"`
+	RawStatsdFlush      string        `json:"statsd_flush_interval"`
+	RawReplayWindow     string        `json:"replay_window"`
+	RawWALSync          string        `json:"wal_sync_interval"`
+	Address             string        `env:"ADDRESS" json:"address"`
+	ConfigFilePath      string        `enc:"CONFIG"`
+	CryptoKey           string        `env:"CRYPTO_KEY" json:"crypto_key"`
+	StoreFile           string        `env:"STORE_FILE" json:"store_file"`
+	WALDir              string        `env:"WAL_DIR" json:"wal_dir"`
+	StoreCompression    string        `env:"STORE_COMPRESSION" json:"store_compression"`
+	S3Endpoint          string        `env:"S3_ENDPOINT" json:"s3_endpoint"`
+	S3Bucket            string        `env:"S3_BUCKET" json:"s3_bucket"`
+	S3Prefix            string        `env:"S3_PREFIX" json:"s3_prefix"`
+	S3Region            string        `env:"S3_REGION" json:"s3_region"`
+	S3AccessKey         string        `env:"S3_ACCESS_KEY" json:"s3_access_key"`
+	S3SecretKey         string        `env:"S3_SECRET_KEY" json:"s3_secret_key"`
+	StoreKeep           int           `env:"STORE_KEEP" json:"store_keep"`
+	Key                 string        `env:"KEY" json:"key"`
+	AdminToken          string        `env:"ADMIN_TOKEN" json:"admin_token"`
+	DatabaseDSN         string        `env:"DATABASE_DSN" json:"database_dsn"`
+	StatsdAddress       string        `env:"STATSD_ADDRESS" json:"statsd_address"`
+	GRPCAddress         string        `env:"GRPC_ADDRESS" json:"grpc_address"`
+	TLSCert             string        `env:"TLS_CERT" json:"tls_cert"`
+	TLSKey              string        `env:"TLS_KEY" json:"tls_key"`
+	TLSClientCA         string        `env:"TLS_CLIENT_CA" json:"tls_client_ca"`
+	ClientLabel         string        `env:"CLIENT_LABEL" json:"client_label"`
+	AllowedClients      string        `env:"ALLOWED_CLIENTS" json:"allowed_clients"`
+	TrustedSubnet       string        `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
+	RealIPHeader        string        `env:"REAL_IP_HEADER" json:"real_ip_header"`
+	StoreInterval       time.Duration `env:"STORE_INTERVAL"`
+	HistoryRetention    time.Duration `env:"HISTORY_RETENTION"`
+	StatsdFlush         time.Duration `env:"STATSD_FLUSH_INTERVAL"`
+	ReplayWindow        time.Duration `env:"REPLAY_WINDOW"`
+	WALSync             time.Duration `env:"WAL_SYNC_INTERVAL"`
+	HistoryDepth        int           `env:"HISTORY_DEPTH" json:"history_depth"`
+	Restore             bool          `env:"RESTORE" json:"restore"`
 }
 
 var cfg config
 
 func init() {
 	flag.StringVar(&cfg.Address, "a", "localhost:8080", "Address of the server (to listen to)")
-	flag.StringVar(&cfg.CryptoKey, "crypto-key", "", "Path to file with private encryption key")
+	flag.StringVar(&cfg.CryptoKey, "crypto-key", "", "Path to file or directory with private encryption keys (reloaded on SIGHUP)")
 	flag.DurationVar(&cfg.StoreInterval, "i", 300*time.Second, "Interval for storage state to be dumped on disk")
 	flag.StringVar(&cfg.StoreFile, "f", "/tmp/devops-metrics-db.json", "Path to the file for dumping storage state")
+	flag.StringVar(&cfg.StoreCompression, "store-compression", "", "Compression of dumps: gzip, zstd or none (detected automatically on restore)")
+	flag.StringVar(&cfg.S3Endpoint, "s3-endpoint", "", "Endpoint of S3-compatible storage to upload dumps to (dump file is used if empty)")
+	flag.StringVar(&cfg.S3Bucket, "s3-bucket", "", "Bucket to upload dumps to")
+	flag.StringVar(&cfg.S3Prefix, "s3-prefix", "", "Prefix of uploaded dumps in the bucket")
+	flag.StringVar(&cfg.S3Region, "s3-region", "us-east-1", "Region of S3-compatible storage")
+	flag.StringVar(&cfg.S3AccessKey, "s3-access-key", "", "Access key of S3-compatible storage")
+	flag.StringVar(&cfg.S3SecretKey, "s3-secret-key", "", "Secret key of S3-compatible storage")
+	flag.IntVar(&cfg.StoreKeep, "store-keep", 2, "Number of previous dumps to keep, restore falls back to them if the latest one is corrupted")
 	flag.BoolVar(&cfg.Restore, "r", true, "Restore store state from dump file on server initialization")
+	flag.StringVar(&cfg.WALDir, "wal-dir", "", "Directory for write-ahead log of in-memory storage (disabled if empty)")
+	flag.DurationVar(&cfg.WALSync, "wal-sync-interval", 0, "Interval to sync write-ahead log to disk (synced after every update if zero)")
 	flag.StringVar(&cfg.Key, "k", "", "Secret key to sign metrics (should be shared between server and agent)")
+	flag.DurationVar(&cfg.ReplayWindow, "replay-window", 0, "Acceptance window for signing time of signed metrics, older or replayed metrics are rejected (disabled if zero)")
+	flag.StringVar(&cfg.AdminToken, "admin-token", "", "Secret of admin API token, API tokens are required if set")
 	flag.StringVar(&cfg.DatabaseDSN, "d", "", "Database connection string")
 	flag.StringVar(&cfg.ConfigFilePath, "c", "", "Path to JSON configuration")
+	flag.IntVar(&cfg.HistoryDepth, "history-depth", 0, "Number of previous values to retain per metrics (history is disabled if zero)")
+	flag.DurationVar(&cfg.HistoryRetention, "history-retention", 0, "Maximum age of retained previous values (unlimited if zero)")
+	flag.StringVar(&cfg.GRPCAddress, "grpc-address", "", "Address of gRPC server (to listen to, disabled if empty)")
+	flag.StringVar(&cfg.TLSCert, "tls-cert", "", "Path to TLS certificate (TLS is disabled if empty)")
+	flag.StringVar(&cfg.TLSKey, "tls-key", "", "Path to TLS private key")
+	flag.StringVar(&cfg.TLSClientCA, "tls-client-ca", "", "Path to CA bundle to verify client certificates (not required if empty)")
+	flag.StringVar(&cfg.ClientLabel, "client-label", "", "Label to attach client certificate CN to submitted metrics (disabled if empty)")
+	flag.StringVar(&cfg.AllowedClients, "allowed-clients", "", "Comma separated list of allowed client certificate CNs (all clients are allowed if empty)")
+	flag.StringVar(&cfg.TrustedSubnet, "t", "", "Comma separated list of CIDR ranges, which are allowed to update metrics (all are allowed if empty)")
+	flag.StringVar(&cfg.RealIPHeader, "real-ip-header", "X-Real-IP", "Header with client address to check against trusted subnets")
+	flag.StringVar(&cfg.StatsdAddress, "statsd-address", "", "UDP address to listen to StatsD metrics (disabled if empty)")
+	flag.DurationVar(&cfg.StatsdFlush, "statsd-flush-interval", 10*time.Second, "Interval for aggregated StatsD metrics to be written to storage")
 }
 
 func main() {
@@ -77,6 +134,30 @@ func main() {
 		if err != nil {
 			log.Fatal("Could not parse config file : ", err)
 		}
+		if cfg.RawHistoryRetention != "" {
+			cfg.HistoryRetention, err = time.ParseDuration(cfg.RawHistoryRetention)
+			if err != nil {
+				log.Fatal("Could not parse config file : ", err)
+			}
+		}
+		if cfg.RawStatsdFlush != "" {
+			cfg.StatsdFlush, err = time.ParseDuration(cfg.RawStatsdFlush)
+			if err != nil {
+				log.Fatal("Could not parse config file : ", err)
+			}
+		}
+		if cfg.RawWALSync != "" {
+			cfg.WALSync, err = time.ParseDuration(cfg.RawWALSync)
+			if err != nil {
+				log.Fatal("Could not parse config file : ", err)
+			}
+		}
+		if cfg.RawReplayWindow != "" {
+			cfg.ReplayWindow, err = time.ParseDuration(cfg.RawReplayWindow)
+			if err != nil {
+				log.Fatal("Could not parse config file : ", err)
+			}
+		}
 	}
 
 	// do it again to preserve order
@@ -88,15 +169,38 @@ func main() {
 	if cfg.StoreInterval < 0 {
 		log.Fatal("Invalid value for store interval")
 	}
+	if cfg.HistoryDepth < 0 || cfg.HistoryRetention < 0 {
+		log.Fatal("Invalid value for history settings")
+	}
+	if cfg.StoreKeep < 0 {
+		log.Fatal("Invalid value for number of kept dumps")
+	}
+	if cfg.WALSync < 0 {
+		log.Fatal("Invalid value for write-ahead log sync interval")
+	}
+	if cfg.ReplayWindow < 0 {
+		log.Fatal("Invalid value for replay window")
+	}
+	if cfg.StatsdAddress != "" && cfg.StatsdFlush <= 0 {
+		log.Fatal("Invalid value for statsd flush interval")
+	}
+	if cfg.TLSCert == "" && (cfg.TLSClientCA != "" || cfg.ClientLabel != "" || cfg.AllowedClients != "") {
+		log.Fatal("Client certificates could not be used without TLS")
+	}
+	if cfg.TLSClientCA == "" && (cfg.ClientLabel != "" || cfg.AllowedClients != "") {
+		// client certificates are not verified without CA, so every client would be rejected
+		log.Fatal("Client identity could not be used without client CA")
+	}
 	log.Printf("DSN: %v", cfg.DatabaseDSN)
 
 	decryptor, err := crypt.NewDecryptor(cfg.CryptoKey)
 	if err != nil {
-		log.Println("Could not setup encryption")
+		log.Printf("Could not setup encryption: %s", err.Error())
 		os.Exit(1)
 	}
 
 	var store storage.MetricsStorage
+	var mem *storage.MemStorage
 	if cfg.DatabaseDSN != "" {
 		log.Println("Initializing postgres database")
 		store, err = storage.NewPostgresStorage(cfg.DatabaseDSN)
@@ -104,7 +208,12 @@ func main() {
 			log.Fatalf("error during storage initialization: %s", err.Error())
 		}
 	} else {
-		store = storage.NewMemStorage()
+		mem = storage.NewMemStorage()
+		store = mem
+	}
+	if cfg.HistoryDepth > 0 {
+		log.Println("Enabling metrics history")
+		store = storage.NewHistoryStorage(store, cfg.HistoryRetention, cfg.HistoryDepth)
 	}
 	defer func() {
 		err = store.Close()
@@ -113,71 +222,172 @@ func main() {
 		}
 	}()
 
+	log.Println("Initializing dumper...")
+	compression, err := dumper.ParseCompression(cfg.StoreCompression)
+	if err != nil {
+		log.Fatal("Invalid value for dump compression : ", err)
+	}
+	var d interface {
+		dumper.Dumper
+		dumper.Restorer
+	}
+	if cfg.S3Endpoint != "" {
+		s3, err_ := dumper.NewS3Dumper(dumper.S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Bucket:    cfg.S3Bucket,
+			Prefix:    cfg.S3Prefix,
+			Region:    cfg.S3Region,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+		})
+		if err_ != nil {
+			log.Fatal("Could not setup S3 dumper : ", err_)
+		}
+		d = s3.WithKeep(cfg.StoreKeep).WithCompression(compression)
+	} else {
+		d = dumper.NewSyncDumper(cfg.StoreFile).WithKeep(cfg.StoreKeep).WithCompression(compression)
+	}
+
+	defer func() {
+		err_ := d.Close()
+		if err_ != nil {
+			log.Fatal("Error Closing dumper : ", err_)
+		}
+	}()
+
 	// restore storage if needed
 	if cfg.Restore && cfg.DatabaseDSN == "" {
-		log.Println("Restoring storage from file...")
-		func() {
-			f, err_ := os.OpenFile(cfg.StoreFile, os.O_RDONLY|os.O_CREATE, 0644)
-			if err_ != nil {
-				log.Fatal("Could not open file : ", err_)
-			}
+		log.Println("Restoring storage from dump...")
+		err_ := d.Restore(func(l []schema.Metrics) error {
+			return store.BulkPut(context.Background(), l)
+		})
+		if err_ != nil {
+			log.Fatal("Could not restore data : ", err_)
+		}
+	}
 
-			buf := bytes.NewBuffer(nil)
-			n, err_ := io.Copy(buf, f)
-			if n == 0 {
-				// file is empty, valid scenario
-				// nothing to restore
-				return
-			}
+	// log holds updates made after the snapshot, so it is replayed on top of it
+	if mem != nil && cfg.WALDir != "" {
+		wal, err_ := storage.OpenWAL(cfg.WALDir, cfg.WALSync)
+		if err_ != nil {
+			log.Fatal("Could not open write-ahead log : ", err_)
+		}
+		// dumper could fall back to the previous snapshot, so the log should reach back to it
+		wal = wal.WithKeep(cfg.StoreKeep)
+		if cfg.Restore {
+			log.Println("Replaying write-ahead log...")
+			err_ = wal.Replay(func(l []schema.Metrics) error {
+				return mem.BulkPut(context.Background(), l)
+			})
 			if err_ != nil {
-				log.Fatal("Could not read data : ", err_)
+				log.Fatal("Could not replay write-ahead log : ", err_)
 			}
-
-			var l []schema.Metrics
-			err_ = json.Unmarshal(buf.Bytes(), &l)
+		} else {
+			err_ = wal.Discard()
 			if err_ != nil {
-				log.Fatal("Could not restore data : ", err_)
+				log.Fatal("Could not discard write-ahead log : ", err_)
 			}
+		}
+		mem.WithWAL(wal)
+	}
 
-			err_ = store.BulkPut(context.Background(), l)
-			if err_ != nil {
-				log.Fatal("Could not save restored data : ", err_)
-			}
+	var statsdListener *statsd.Listener
+	if cfg.StatsdAddress != "" {
+		log.Println("Listening to StatsD metrics...")
+		statsdListener, err = statsd.Listen(cfg.StatsdAddress, store, cfg.StatsdFlush)
+		if err != nil {
+			log.Fatal("Could not start StatsD listener : ", err)
+		}
+	}
 
-			err_ = f.Close()
-			if err_ != nil {
-				log.Fatal("Could not close dump file : ", err_)
+	if ring, ok := decryptor.(*crypt.KeyRing); ok {
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+		go func() {
+			for range sighup {
+				log.Println("Reloading encryption keys...")
+				if err_ := ring.Reload(); err_ != nil {
+					log.Printf("Could not reload encryption keys, previous ones are kept: %s", err_.Error())
+				}
 			}
 		}()
 	}
 
-	log.Println("Initializing dumper...")
-	d := dumper.NewSyncDumper(cfg.StoreFile)
+	log.Println("Initializing application...")
+	app := server.NewApp(store).WithDumper(d).WithDumpInterval(cfg.StoreInterval).WithKey(cfg.Key).WithDecryptor(decryptor).WithAdminToken(cfg.AdminToken).WithReplayWindow(cfg.ReplayWindow)
 
-	defer func() {
-		err_ := d.Close()
+	subnets, err := server.ParseSubnets(cfg.TrustedSubnet)
+	if err != nil {
+		log.Fatal("Could not parse trusted subnets : ", err)
+	}
+	app = app.WithTrustedSubnets(cfg.RealIPHeader, subnets)
+
+	var tlsConfig *tls.Config
+	if cfg.TLSCert != "" {
+		tlsConfig, err = crypt.ServerTLSConfig(cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA)
+		if err != nil {
+			log.Fatal("Could not setup TLS : ", err)
+		}
+		var allowed []string
+		if cfg.AllowedClients != "" {
+			allowed = strings.Split(cfg.AllowedClients, ",")
+		}
+		app = app.WithClientIdentity(cfg.ClientLabel, allowed)
+	}
+
+	var grpcServer *grpc.Server
+	if cfg.GRPCAddress != "" {
+		listener, err_ := net.Listen("tcp", cfg.GRPCAddress)
 		if err_ != nil {
-			log.Fatal("Error Closing dumper : ", err_)
+			log.Fatal("Could not listen to gRPC address : ", err_)
 		}
-	}()
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		grpcServer = server.NewGRPCServer(app, opts...)
+		go func() {
+			log.Println("Listening gRPC...")
+			if serveErr := grpcServer.Serve(listener); serveErr != nil {
+				log.Printf("gRPC server stopped: %v", serveErr)
+			}
+		}()
+	}
 
-	log.Println("Initializing application...")
-	app := server.NewApp(store).WithDumper(d).WithDumpInterval(cfg.StoreInterval).WithKey(cfg.Key).WithDecryptor(decryptor)
 	log.Println("Listening...")
-	server := http.Server{Addr: cfg.Address, Handler: app.Router}
+	server := http.Server{Addr: cfg.Address, Handler: app.Router, TLSConfig: tlsConfig}
 	idleConnsClosed := make(chan struct{})
 	sigint := make(chan os.Signal, 1)
 	signal.Notify(sigint, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	go func() {
 		<-sigint
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
 		if err := server.Shutdown(context.Background()); err != nil {
 			log.Printf("HTTP server Shutdown: %v", err)
 		}
+		// StatsD listener flushes aggregated metrics on close, they should get into the final dump
+		if statsdListener != nil {
+			if err := statsdListener.Close(); err != nil {
+				log.Printf("Could not close StatsD listener: %v", err)
+			}
+		}
+		// updates are not accepted anymore, so the final state could be dumped
+		if err := app.Close(); err != nil {
+			log.Printf("Could not dump final state: %v", err)
+		}
 		close(idleConnsClosed)
 	}()
 
-	err = server.ListenAndServe()
-	if err != nil {
+	if tlsConfig != nil {
+		// certificates are already loaded into config
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	// server is closed on shutdown, the final dump should not be interrupted
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal("Error Starting the HTTP Server : ", err)
 	}
 	<-idleConnsClosed
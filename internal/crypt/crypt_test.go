@@ -0,0 +1,220 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKeys generates key pair, private key is written into dir, public one into its own directory
+func writeKeys(t *testing.T, dir, name string) (publicPath string, key *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	publicPath = filepath.Join(t.TempDir(), name+".pub")
+	require.NoError(t, os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PUBLIC KEY",
+		Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey),
+	}), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}), 0600))
+	return publicPath, key
+}
+
+func TestEnvelope(t *testing.T) {
+	dir := t.TempDir()
+	publicPath, key := writeKeys(t, dir, "key")
+	encryptor, err := NewEncryptor(publicPath)
+	require.NoError(t, err)
+	decryptor, err := NewDecryptor(filepath.Join(dir, "key.pem"))
+	require.NoError(t, err)
+
+	// message is much larger than the key size
+	message := bytes.Repeat([]byte(`{"id":"Alloc","type":"gauge","value":1.5}`), 1000)
+	encrypted, err := encryptor.Encrypt(message)
+	require.NoError(t, err)
+	assert.True(t, isEnvelope(encrypted))
+	decrypted, err := decryptor.Decrypt(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, message, decrypted)
+
+	// messages from old agents are still accepted
+	legacy, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &key.PublicKey, []byte("short"), nil)
+	require.NoError(t, err)
+	decrypted, err = decryptor.Decrypt(legacy)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("short"), decrypted)
+
+	// requests without body
+	decrypted, err = decryptor.Decrypt([]byte{})
+	assert.NoError(t, err)
+	assert.Empty(t, decrypted)
+
+	// tampered ciphertext
+	encrypted[len(encrypted)-1] ^= 1
+	_, err = decryptor.Decrypt(encrypted)
+	assert.Error(t, err)
+
+	// truncated header
+	_, err = decryptor.Decrypt(encrypted[:10])
+	assert.Error(t, err)
+}
+
+func TestKeyRing(t *testing.T) {
+	dir := t.TempDir()
+	oldPublic, _ := writeKeys(t, dir, "old")
+	ring, err := NewKeyRing(dir)
+	require.NoError(t, err)
+	assert.Len(t, ring.IDs(), 1)
+
+	oldEncryptor, err := NewEncryptor(oldPublic)
+	require.NoError(t, err)
+	fromOld, err := oldEncryptor.Encrypt([]byte("old"))
+	require.NoError(t, err)
+
+	// new key is added, both keys are accepted after reload
+	newPublic, _ := writeKeys(t, dir, "new")
+	newEncryptor, err := NewEncryptor(newPublic)
+	require.NoError(t, err)
+	fromNew, err := newEncryptor.Encrypt([]byte("new"))
+	require.NoError(t, err)
+	_, err = ring.Decrypt(fromNew)
+	assert.ErrorContains(t, err, "unknown key ID")
+
+	require.NoError(t, ring.Reload())
+	assert.Len(t, ring.IDs(), 2)
+	decrypted, err := ring.Decrypt(fromOld)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("old"), decrypted)
+	decrypted, err = ring.Decrypt(fromNew)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new"), decrypted)
+
+	// old key is removed
+	require.NoError(t, os.Remove(filepath.Join(dir, "old.pem")))
+	require.NoError(t, ring.Reload())
+	_, err = ring.Decrypt(fromOld)
+	assert.Error(t, err)
+
+	// keys are kept if the directory becomes invalid
+	require.NoError(t, os.Remove(filepath.Join(dir, "new.pem")))
+	assert.Error(t, ring.Reload())
+	decrypted, err = ring.Decrypt(fromNew)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new"), decrypted)
+}
+
+func writePEM(t *testing.T, path, blockType string, data []byte) {
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0600))
+}
+
+func TestKeyFormats(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	x25519Key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	pkcs8 := func(key any) []byte {
+		der, err_ := x509.MarshalPKCS8PrivateKey(key)
+		require.NoError(t, err_)
+		return der
+	}
+	sec1, err := x509.MarshalECPrivateKey(p256Key)
+	require.NoError(t, err)
+
+	params := []struct {
+		name      string
+		blockType string
+		private   []byte
+		public    any
+	}{
+		{"rsa", "PRIVATE KEY", pkcs8(rsaKey), &rsaKey.PublicKey},
+		{"x25519", "PRIVATE KEY", pkcs8(x25519Key), x25519Key.PublicKey()},
+		{"p256", "EC PRIVATE KEY", sec1, &p256Key.PublicKey},
+	}
+
+	dir := t.TempDir()
+	encryptors := make(map[string]Encryptor)
+	for _, param := range params {
+		writePEM(t, filepath.Join(dir, param.name+".pem"), param.blockType, param.private)
+
+		public, err := x509.MarshalPKIXPublicKey(param.public)
+		require.NoError(t, err)
+		publicPath := filepath.Join(t.TempDir(), param.name+".pub")
+		writePEM(t, publicPath, "PUBLIC KEY", public)
+		encryptors[param.name], err = NewEncryptor(publicPath)
+		require.NoError(t, err)
+	}
+
+	ring, err := NewKeyRing(dir)
+	require.NoError(t, err)
+	assert.Len(t, ring.IDs(), len(params))
+	for name, encryptor := range encryptors {
+		encrypted, err := encryptor.Encrypt([]byte(name))
+		require.NoError(t, err)
+		decrypted, err := ring.Decrypt(encrypted)
+		require.NoError(t, err, name)
+		assert.Equal(t, []byte(name), decrypted)
+	}
+
+	// ephemeral key could not be replaced
+	encrypted, err := encryptors["x25519"].Encrypt([]byte("x25519"))
+	require.NoError(t, err)
+	e, err := parseEnvelope(encrypted)
+	require.NoError(t, err)
+	other, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	copy(e.material, other.PublicKey().Bytes())
+	_, err = ring.Decrypt(encrypted)
+	assert.Error(t, err)
+}
+
+func TestKeyErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	// file without PEM data should not cause panic
+	path := filepath.Join(dir, "garbage.pem")
+	require.NoError(t, os.WriteFile(path, []byte("not a key"), 0600))
+	_, err := NewDecryptor(path)
+	assert.ErrorIs(t, err, errNoPEM)
+	_, err = NewEncryptor(path)
+	assert.ErrorIs(t, err, errNoPEM)
+
+	// public key instead of private one
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	writePEM(t, path, "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&key.PublicKey))
+	_, err = NewDecryptor(path)
+	var keyErr *KeyError
+	assert.ErrorAs(t, err, &keyErr)
+	assert.Equal(t, "RSA PUBLIC KEY", keyErr.BlockType)
+
+	// corrupted key
+	writePEM(t, path, "PRIVATE KEY", []byte("corrupted"))
+	_, err = NewDecryptor(path)
+	assert.ErrorAs(t, err, &keyErr)
+
+	// unsupported curve
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	public, err := x509.MarshalPKIXPublicKey(&p384.PublicKey)
+	require.NoError(t, err)
+	writePEM(t, path, "PUBLIC KEY", public)
+	_, err = NewEncryptor(path)
+	assert.ErrorContains(t, err, "unsupported curve")
+}
@@ -1,12 +1,8 @@
 package crypt
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/sha256"
-	"crypto/x509"
-	"encoding/pem"
-	"hash"
+	"crypto"
+	"fmt"
 	"os"
 )
 
@@ -20,26 +16,19 @@ func (noopEncryptor) Encrypt(message []byte) ([]byte, error) {
 	return message, nil
 }
 
-type rsaEncryptor struct {
-	publicKey *rsa.PublicKey
-	hash      hash.Hash
+// envelopeEncryptor seals messages of any size into envelopes,
+// which could be opened only with the matching private key
+type envelopeEncryptor struct {
+	publicKey crypto.PublicKey
+	keyID     string
 }
 
-func (e rsaEncryptor) Encrypt(message []byte) ([]byte, error) {
-	encrypted, err := rsa.EncryptOAEP(e.hash, rand.Reader, e.publicKey, message, nil)
-	if err != nil {
-		return nil, err
-	}
-	return encrypted, nil
-}
-
-func publicKeyFromBytes(raw []byte) (*rsa.PublicKey, error) {
-	block, _ := pem.Decode(raw)
-	bytes := block.Bytes
-	// here I make an assumption that key will be in PKCS1 form, but it's not specified in the task
-	return x509.ParsePKCS1PublicKey(bytes)
+func (e envelopeEncryptor) Encrypt(message []byte) ([]byte, error) {
+	return sealEnvelope(e.publicKey, e.keyID, message)
 }
 
+// NewEncryptor creates encryptor with public key from path,
+// RSA and EC (X25519, P-256) keys are supported
 func NewEncryptor(path string) (Encryptor, error) {
 	if len(path) == 0 {
 		return noopEncryptor{}, nil
@@ -52,11 +41,11 @@ func NewEncryptor(path string) (Encryptor, error) {
 
 	publicKey, err := publicKeyFromBytes(bytes)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s: %w", path, err)
 	}
 
-	return rsaEncryptor{
+	return envelopeEncryptor{
 		publicKey,
-		sha256.New(),
+		keyID(publicKey),
 	}, nil
 }
@@ -0,0 +1,235 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Envelope layout (version 3):
+//
+//	magic (2 bytes) | version (1 byte) | algorithm (1 byte) | key ID length (1 byte) | key ID |
+//	key material length (2 bytes, big endian) | key material | nonce (12 bytes) | AES-GCM ciphertext
+//
+// Random AES-256 key is used for every message, for RSA it is wrapped with RSA-OAEP (SHA-256)
+// and the wrapped key is key material, for ECDH the key is derived from the shared secret
+// with the ephemeral key, which public part is key material.
+// Everything before the ciphertext is authenticated as additional data.
+//
+// Version 2 has no algorithm byte and is always RSA, it is still produced for RSA keys,
+// so servers without ECDH support could decrypt it. Version 1 has no key ID either,
+// so the receiver has to try all its keys.
+const (
+	envelopeVersion1  byte = 1
+	envelopeVersion2  byte = 2
+	envelopeVersion3  byte = 3
+	envelopeKeySize        = 32
+	envelopeNonceSize      = 12
+)
+
+const (
+	algorithmRSA    byte = 1
+	algorithmX25519 byte = 2
+	algorithmP256   byte = 3
+)
+
+var envelopeMagic = []byte("LG")
+
+var errNotEnvelope = errors.New("message is not an envelope")
+
+type envelopeError struct {
+	reason string
+}
+
+func (e envelopeError) Error() string {
+	return "malformed envelope: " + e.reason
+}
+
+type envelope struct {
+	algorithm byte
+	keyID     string
+	// material is either wrapped key or ephemeral public key
+	material   []byte
+	nonce      []byte
+	ciphertext []byte
+	// header is authenticated as additional data
+	header []byte
+}
+
+func isEnvelope(message []byte) bool {
+	return len(message) > len(envelopeMagic) && bytes.HasPrefix(message, envelopeMagic)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func curveAlgorithm(curve ecdh.Curve) byte {
+	if curve == ecdh.X25519() {
+		return algorithmX25519
+	}
+	return algorithmP256
+}
+
+// deriveKey binds the AES key to both public keys, so key material could not be replaced
+func deriveKey(secret []byte, ephemeral, recipient *ecdh.PublicKey) []byte {
+	h := sha256.New()
+	h.Write(secret)
+	h.Write(ephemeral.Bytes())
+	h.Write(recipient.Bytes())
+	return h.Sum(nil)
+}
+
+func sealEnvelope(publicKey crypto.PublicKey, id string, message []byte) ([]byte, error) {
+	if len(id) > 255 {
+		return nil, fmt.Errorf("key ID %q is too long", id)
+	}
+
+	header := make([]byte, 0, 128+len(id))
+	header = append(header, envelopeMagic...)
+
+	var key, material []byte
+	switch k := publicKey.(type) {
+	case *rsa.PublicKey:
+		key = make([]byte, envelopeKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, k, key, nil)
+		if err != nil {
+			return nil, err
+		}
+		material = wrapped
+		header = append(header, envelopeVersion2)
+	case *ecdh.PublicKey:
+		ephemeral, err := k.Curve().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		secret, err := ephemeral.ECDH(k)
+		if err != nil {
+			return nil, err
+		}
+		key = deriveKey(secret, ephemeral.PublicKey(), k)
+		material = ephemeral.PublicKey().Bytes()
+		header = append(header, envelopeVersion3, curveAlgorithm(k.Curve()))
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", publicKey)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header = append(header, byte(len(id)))
+	header = append(header, id...)
+	header = append(header, 0, 0)
+	binary.BigEndian.PutUint16(header[len(header)-2:], uint16(len(material)))
+	header = append(header, material...)
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header = append(header, nonce...)
+
+	return gcm.Seal(header, nonce, message, header), nil
+}
+
+func parseEnvelope(message []byte) (envelope, error) {
+	var e envelope
+	if !isEnvelope(message) {
+		return e, errNotEnvelope
+	}
+	rest := message[len(envelopeMagic):]
+
+	version := rest[0]
+	rest = rest[1:]
+	e.algorithm = algorithmRSA
+	if version == envelopeVersion3 {
+		if len(rest) < 1 {
+			return e, envelopeError{"truncated algorithm"}
+		}
+		e.algorithm = rest[0]
+		rest = rest[1:]
+	}
+	switch version {
+	case envelopeVersion1:
+	case envelopeVersion2, envelopeVersion3:
+		if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+			return e, envelopeError{"truncated key ID"}
+		}
+		e.keyID = string(rest[1 : 1+int(rest[0])])
+		rest = rest[1+int(rest[0]):]
+	default:
+		return e, envelopeError{fmt.Sprintf("unsupported version %d", version)}
+	}
+
+	if len(rest) < 2 {
+		return e, envelopeError{"truncated header"}
+	}
+	size := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if len(rest) < size {
+		return e, envelopeError{"truncated key"}
+	}
+	e.material = rest[:size]
+	rest = rest[size:]
+
+	if len(rest) < envelopeNonceSize {
+		return e, envelopeError{"truncated nonce"}
+	}
+	e.nonce = rest[:envelopeNonceSize]
+	e.ciphertext = rest[envelopeNonceSize:]
+	e.header = message[:len(message)-len(e.ciphertext)]
+	return e, nil
+}
+
+func (e envelope) open(privateKey crypto.PrivateKey) ([]byte, error) {
+	var key []byte
+	switch k := privateKey.(type) {
+	case *rsa.PrivateKey:
+		if e.algorithm != algorithmRSA {
+			return nil, envelopeError{"message is not encrypted with RSA key"}
+		}
+		unwrapped, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, k, e.material, nil)
+		if err != nil {
+			return nil, err
+		}
+		key = unwrapped
+	case *ecdh.PrivateKey:
+		if e.algorithm != curveAlgorithm(k.Curve()) {
+			return nil, envelopeError{fmt.Sprintf("message is not encrypted with %s key", k.Curve())}
+		}
+		ephemeral, err := k.Curve().NewPublicKey(e.material)
+		if err != nil {
+			return nil, err
+		}
+		secret, err := k.ECDH(ephemeral)
+		if err != nil {
+			return nil, err
+		}
+		key = deriveKey(secret, ephemeral, k.PublicKey())
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", privateKey)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, e.nonce, e.ciphertext, e.header)
+}
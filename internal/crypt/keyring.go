@@ -0,0 +1,148 @@
+package crypt
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// KeyRing holds several private keys, so keys could be rotated without
+// simultaneous restart of all agents: new key is added to the ring,
+// agents are switched to it one by one, then the old key is removed
+type KeyRing struct {
+	path string
+	// keys are indexed by ID, order is kept to try them predictably for messages without ID
+	keys  map[string]crypto.PrivateKey
+	order []string
+	mu    sync.RWMutex
+}
+
+// loadKeys reads private key from the file or all *.pem files from the directory
+func loadKeys(path string) (map[string]crypto.PrivateKey, []string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files, err = filepath.Glob(filepath.Join(path, "*.pem"))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	keys := make(map[string]crypto.PrivateKey, len(files))
+	order := make([]string, 0, len(files))
+	for _, file := range files {
+		raw, err_ := os.ReadFile(file)
+		if err_ != nil {
+			return nil, nil, err_
+		}
+		key, err_ := privateKeyFromBytes(raw)
+		if err_ != nil {
+			return nil, nil, fmt.Errorf("%s: %w", file, err_)
+		}
+		id := keyID(publicKeyOf(key))
+		if _, ok := keys[id]; !ok {
+			order = append(order, id)
+		}
+		keys[id] = key
+	}
+	if len(keys) == 0 {
+		return nil, nil, fmt.Errorf("no keys found in %s", path)
+	}
+	sort.Strings(order)
+	return keys, order, nil
+}
+
+// Reload reads keys again, current keys are kept if new ones could not be loaded
+func (r *KeyRing) Reload() error {
+	keys, order, err := loadKeys(r.path)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = keys
+	r.order = order
+	log.Printf("Loaded %d decryption keys: %v", len(order), order)
+	return nil
+}
+
+// IDs returns identifiers of loaded keys
+func (r *KeyRing) IDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string(nil), r.order...)
+}
+
+func (r *KeyRing) Decrypt(message []byte) ([]byte, error) {
+	if len(message) == 0 {
+		// requests without body are not encrypted
+		return message, nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	e, envelopeErr := parseEnvelope(message)
+	// key is tried by ID only, if the envelope names it
+	tryEnvelope := envelopeErr == nil && e.keyID == ""
+	if envelopeErr == nil && e.keyID != "" {
+		key, ok := r.keys[e.keyID]
+		if !ok {
+			envelopeErr = fmt.Errorf("unknown key ID %s", e.keyID)
+		} else if decrypted, err := e.open(key); err != nil {
+			envelopeErr = err
+		} else {
+			return decrypted, nil
+		}
+	}
+
+	err := errors.New("no suitable key to decrypt the message")
+	for _, id := range r.order {
+		if tryEnvelope {
+			decrypted, err_ := e.open(r.keys[id])
+			if err_ == nil {
+				return decrypted, nil
+			}
+			envelopeErr = err_
+		}
+
+		// compatibility with agents, which encrypt the whole message with RSA-OAEP,
+		// such message could accidentally look like envelope, so it is always tried as raw
+		key, ok := r.keys[id].(*rsa.PrivateKey)
+		if !ok {
+			continue
+		}
+		decrypted, err_ := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, message, nil)
+		if err_ == nil {
+			return decrypted, nil
+		}
+		err = err_
+	}
+	if envelopeErr != nil && !errors.Is(envelopeErr, errNotEnvelope) {
+		// message looks like envelope, so its error is more relevant
+		return nil, envelopeErr
+	}
+	return nil, err
+}
+
+// NewKeyRing loads private keys from the file or directory
+func NewKeyRing(path string) (*KeyRing, error) {
+	r := &KeyRing{path: path}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
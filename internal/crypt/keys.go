@@ -0,0 +1,166 @@
+package crypt
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// Supported keys are RSA (*rsa.PrivateKey, *rsa.PublicKey) and
+// ECDH on X25519 or P-256 curves (*ecdh.PrivateKey, *ecdh.PublicKey),
+// EC keys issued as ECDSA are converted to ECDH ones.
+
+var errNoPEM = errors.New("no PEM data found")
+
+// KeyError describes why the key could not be loaded
+type KeyError struct {
+	BlockType string
+	Err       error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("invalid %q key: %s", e.BlockType, e.Err.Error())
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+func decodePEM(raw []byte) (*pem.Block, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errNoPEM
+	}
+	return block, nil
+}
+
+func isSupportedCurve(curve ecdh.Curve) bool {
+	return curve == ecdh.X25519() || curve == ecdh.P256()
+}
+
+func normalizePrivateKey(key any) (crypto.PrivateKey, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		converted, err := k.ECDH()
+		if err != nil {
+			return nil, err
+		}
+		return normalizePrivateKey(converted)
+	case *ecdh.PrivateKey:
+		if !isSupportedCurve(k.Curve()) {
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve())
+		}
+		return k, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
+
+func normalizePublicKey(key any) (crypto.PublicKey, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return k, nil
+	case *ecdsa.PublicKey:
+		converted, err := k.ECDH()
+		if err != nil {
+			return nil, err
+		}
+		return normalizePublicKey(converted)
+	case *ecdh.PublicKey:
+		if !isSupportedCurve(k.Curve()) {
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve())
+		}
+		return k, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
+
+// privateKeyFromBytes detects the format by PEM block type: PKCS1, PKCS8 or SEC1 (EC)
+func privateKeyFromBytes(raw []byte) (crypto.PrivateKey, error) {
+	block, err := decodePEM(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		err = errors.New("unsupported PEM block type")
+	}
+	if err == nil {
+		key, err = normalizePrivateKey(key)
+	}
+	if err != nil {
+		return nil, &KeyError{BlockType: block.Type, Err: err}
+	}
+	return key, nil
+}
+
+// publicKeyFromBytes detects the format by PEM block type: PKCS1 or PKIX,
+// public key of the certificate is also accepted
+func publicKeyFromBytes(raw []byte) (crypto.PublicKey, error) {
+	block, err := decodePEM(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		err = errors.New("unsupported PEM block type")
+	}
+	if err == nil {
+		key, err = normalizePublicKey(key)
+	}
+	if err != nil {
+		return nil, &KeyError{BlockType: block.Type, Err: err}
+	}
+	return key, nil
+}
+
+func publicKeyOf(key crypto.PrivateKey) crypto.PublicKey {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdh.PrivateKey:
+		return k.PublicKey()
+	}
+	return nil
+}
+
+// keyID identifies the key pair by fingerprint of its public part,
+// so agent and server agree on it without additional configuration
+func keyID(key crypto.PublicKey) string {
+	var sum [sha256.Size]byte
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		sum = sha256.Sum256(x509.MarshalPKCS1PublicKey(k))
+	case *ecdh.PublicKey:
+		sum = sha256.Sum256(k.Bytes())
+	}
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,75 @@
+package crypt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(raw) {
+		return nil, fmt.Errorf("%s: %w", path, errNoPEM)
+	}
+	return pool, nil
+}
+
+// ServerTLSConfig creates config for the server with certificate and key from files,
+// if clientCAPath is set, clients have to present certificate signed by one of CAs from the bundle
+func ServerTLSConfig(certPath, keyPath, clientCAPath string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAPath != "" {
+		config.ClientCAs, err = loadCertPool(clientCAPath)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// ClientTLSConfig creates config for the client, all parameters are optional:
+// certificate and key are presented to the server, server certificate is verified
+// against CA bundle (or system pool, if it is not set)
+func ClientTLSConfig(certPath, keyPath, caPath string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if certPath != "" || keyPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caPath != "" {
+		pool, err := loadCertPool(caPath)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// PeerCommonName returns CN of the verified client certificate,
+// empty string is returned if the certificate was not verified
+func PeerCommonName(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return state.VerifiedChains[0][0].Subject.CommonName
+}
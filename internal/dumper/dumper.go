@@ -7,3 +7,9 @@ type Dumper interface {
 	Dump(values []schema.Metrics) error
 	Close() error
 }
+
+// Restorer is implemented by dumpers, which are able to read the dumped state back,
+// values are passed to apply by batches
+type Restorer interface {
+	Restore(apply func([]schema.Metrics) error) error
+}
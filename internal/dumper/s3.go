@@ -0,0 +1,357 @@
+package dumper
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"logogger/internal/schema"
+)
+
+// S3Config describes a bucket in S3-compatible storage, objects are addressed in path style,
+// so the endpoint could be any S3-compatible server (e.g. MinIO)
+type S3Config struct {
+	Endpoint  string
+	Bucket    string
+	Prefix    string
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// S3Dumper uploads snapshots as separate objects named by dump time,
+// the newest valid one is restored and only a few previous ones are kept
+type S3Dumper struct {
+	config      S3Config
+	endpoint    *url.URL
+	client      *http.Client
+	now         func() time.Time
+	compression Compression
+	// keep is the number of previous snapshots to retain
+	keep int
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+}
+
+// S3Error is returned if storage responds with unexpected status
+type S3Error struct {
+	Method string
+	Key    string
+	Code   int
+	Body   string
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("%s %s: storage returned %d code: %s", e.Method, e.Key, e.Code, e.Body)
+}
+
+const s3SnapshotPrefix = "snapshot-"
+
+func NewS3Dumper(config S3Config) (*S3Dumper, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("endpoint %s should have scheme and host", config.Endpoint)
+	}
+	if config.Bucket == "" {
+		return nil, errors.New("bucket is not set")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	return &S3Dumper{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{},
+		now:      time.Now,
+	}, nil
+}
+
+// WithKeep sets the number of previous snapshots to retain
+func (d *S3Dumper) WithKeep(keep int) *S3Dumper {
+	d.keep = keep
+	return d
+}
+
+// WithCompression sets compression of uploaded snapshots
+func (d *S3Dumper) WithCompression(compression Compression) *S3Dumper {
+	d.compression = compression
+	return d
+}
+
+func (d *S3Dumper) Dump(l []schema.Metrics) error {
+	d.wg.Add(1)
+	defer d.wg.Done()
+
+	// object size should be known before upload
+	var buf bytes.Buffer
+	if err := writeSnapshot(&buf, l, d.compression); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// fixed width keeps lexicographical order of keys chronological
+	key := fmt.Sprintf("%s%s%020d", d.config.Prefix, s3SnapshotPrefix, d.now().UnixNano())
+	resp, err := d.do(http.MethodPut, key, nil, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	// object is uploaded atomically, so previous ones are removed only after the upload
+	keys, err := d.snapshots()
+	if err != nil {
+		return err
+	}
+	for i := d.keep + 1; i < len(keys); i++ {
+		resp, err = d.do(http.MethodDelete, keys[i], nil, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// Restore passes values of the newest valid snapshot to apply, corrupted ones are skipped.
+// Snapshot is verified before anything is applied. Nothing is restored if there are no snapshots yet
+func (d *S3Dumper) Restore(apply func([]schema.Metrics) error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	keys, err := d.snapshots()
+	if err != nil {
+		return err
+	}
+
+	var corrupted error
+	for i, key := range keys {
+		if i > d.keep {
+			break
+		}
+		err = d.restoreObject(key, apply)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if errors.Is(err, ErrCorruptedSnapshot) {
+			log.Printf("Skipping snapshot %s: %s", key, err.Error())
+			corrupted = err
+			continue
+		}
+		if err == nil && i > 0 {
+			log.Printf("Restored previous snapshot %s", key)
+		}
+		return err
+	}
+	return corrupted
+}
+
+// restoreObject downloads the snapshot into temporary file, so it could be verified and applied
+// without keeping the whole snapshot in memory
+func (d *S3Dumper) restoreObject(key string, apply func([]schema.Metrics) error) error {
+	f, err := os.CreateTemp("", "logogger-snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	resp, err := d.do(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	return readFile(f.Name(), func(f *os.File) error {
+		if err_ := readSnapshot(f, func(schema.Metrics) error { return nil }); err_ != nil {
+			return err_
+		}
+		if _, err_ := f.Seek(0, io.SeekStart); err_ != nil {
+			return err_
+		}
+		return readBatches(f, apply)
+	})
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// snapshots returns keys of snapshots, the newest go first
+func (d *S3Dumper) snapshots() ([]string, error) {
+	prefix := d.config.Prefix + s3SnapshotPrefix
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := d.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	return keys, nil
+}
+
+// do performs signed request to the object (or to the bucket, if key is empty),
+// response body should be closed by the caller
+func (d *S3Dumper) do(method string, key string, query url.Values, body []byte) (*http.Response, error) {
+	u := *d.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + d.config.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(context.Background(), method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	s3Sign(req, hex.EncodeToString(sum[:]), d.config, d.now())
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, &S3Error{Method: method, Key: key, Code: resp.StatusCode, Body: string(message)}
+	}
+	return resp, nil
+}
+
+func (d *S3Dumper) Close() error {
+	d.wg.Wait()
+	return nil
+}
+
+// s3Escape encodes everything except unreserved characters, as signature requires
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !escapeSlash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Sign signs the request with AWS Signature Version 4, all the headers set are signed.
+// Path and query of the request URL should be already escaped by s3Escape and s3CanonicalQuery
+func s3Sign(req *http.Request, payloadHash string, config S3Config, now time.Time) {
+	now = now.UTC()
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + config.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+config.SecretKey), date)
+	key = hmacSHA256(key, config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s,SignedHeaders=%s,Signature=%s",
+		config.AccessKey, scope, signedHeaders, signature,
+	))
+}
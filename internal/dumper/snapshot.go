@@ -0,0 +1,261 @@
+package dumper
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"logogger/internal/schema"
+)
+
+// snapshotMagic starts the header line of the snapshot,
+// snapshots without header are plain JSON arrays written by previous versions.
+// Version 1 holds JSON array with its checksum in the header,
+// version 2 holds a metrics per line and ends with the trailer line
+const (
+	snapshotMagic   = "#logogger-snapshot"
+	snapshotV2      = snapshotMagic + " v2"
+	snapshotTrailer = "#end"
+)
+
+// restoreBatch is the number of values passed to storage at once during restore
+const restoreBatch = 1000
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// ErrCorruptedSnapshot is returned if snapshot was not written completely or was damaged
+var ErrCorruptedSnapshot = errors.New("snapshot is corrupted")
+
+func corrupted(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrCorruptedSnapshot, fmt.Sprintf(format, args...))
+}
+
+func corruptedBy(err error) error {
+	return fmt.Errorf("%w: %s", ErrCorruptedSnapshot, err.Error())
+}
+
+// Compression of written snapshots, it is detected automatically on restore
+type Compression string
+
+const (
+	CompressionNone Compression = ""
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+func ParseCompression(raw string) (Compression, error) {
+	switch Compression(strings.ToLower(raw)) {
+	case CompressionNone, "none":
+		return CompressionNone, nil
+	case CompressionGzip:
+		return CompressionGzip, nil
+	case CompressionZstd:
+		return CompressionZstd, nil
+	}
+	return CompressionNone, fmt.Errorf("unknown compression %s", raw)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// writeSnapshot streams values line by line, the trailer holds number of values
+// and checksum of the lines, so incomplete snapshots are detected on restore
+func writeSnapshot(w io.Writer, l []schema.Metrics, compression Compression) error {
+	var cw io.WriteCloser
+	switch compression {
+	case CompressionGzip:
+		cw = gzip.NewWriter(w)
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		cw = zw
+	default:
+		cw = nopWriteCloser{w}
+	}
+
+	buf := bufio.NewWriter(cw)
+	if _, err := fmt.Fprintln(buf, snapshotV2); err != nil {
+		return err
+	}
+	h := sha256.New()
+	encoder := json.NewEncoder(io.MultiWriter(buf, h))
+	for _, m := range l {
+		if err := encoder.Encode(m); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(buf, "%s count=%d sha256=%s\n", snapshotTrailer, len(l), hex.EncodeToString(h.Sum(nil))); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+// readSnapshot passes every value of the snapshot to the callback, values are passed
+// before the snapshot is verified completely, so it should be read twice to be applied safely
+func readSnapshot(r io.Reader, each func(schema.Metrics) error) error {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return corruptedBy(err)
+		}
+		defer gr.Close()
+		br = bufio.NewReader(gr)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return corruptedBy(err)
+		}
+		defer zr.Close()
+		br = bufio.NewReader(zr)
+	}
+
+	header, _ := br.Peek(len(snapshotMagic))
+	if string(header) != snapshotMagic {
+		return readArray(br, each)
+	}
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return corrupted("header is incomplete")
+	}
+	line = strings.TrimSuffix(line, "\n")
+	if line == snapshotV2 {
+		return readLines(br, each)
+	}
+	var checksum string
+	if _, err = fmt.Sscanf(line, snapshotMagic+" sha256=%s", &checksum); err != nil {
+		return corrupted("invalid header: %s", err.Error())
+	}
+	return readChecksummed(br, checksum, each)
+}
+
+func readLines(br *bufio.Reader, each func(schema.Metrics) error) error {
+	h := sha256.New()
+	count := 0
+	for {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return corrupted("trailer is missing")
+		}
+		if err != nil {
+			return corruptedBy(err)
+		}
+
+		if bytes.HasPrefix(line, []byte(snapshotTrailer)) {
+			return checkTrailer(string(line), count, h)
+		}
+		h.Write(line)
+		count++
+		var m schema.Metrics
+		if err = json.Unmarshal(line, &m); err != nil {
+			return corruptedBy(err)
+		}
+		if err = each(m); err != nil {
+			return err
+		}
+	}
+}
+
+func checkTrailer(line string, count int, h hash.Hash) error {
+	var expectedCount int
+	var checksum string
+	if _, err := fmt.Sscanf(line, snapshotTrailer+" count=%d sha256=%s\n", &expectedCount, &checksum); err != nil {
+		return corrupted("invalid trailer: %s", err.Error())
+	}
+	if expectedCount != count {
+		return corrupted("expected %d values, got %d", expectedCount, count)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != checksum {
+		return corrupted("checksum mismatch")
+	}
+	return nil
+}
+
+// readChecksummed reads JSON array, which follows the version 1 header
+func readChecksummed(r io.Reader, checksum string, each func(schema.Metrics) error) error {
+	h := sha256.New()
+	tee := io.TeeReader(r, h)
+	if err := readArray(tee, each); err != nil {
+		return err
+	}
+	// decoder could stop before the end of the payload
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return corruptedBy(err)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != checksum {
+		return corrupted("checksum mismatch")
+	}
+	return nil
+}
+
+// readArray decodes JSON array value by value
+func readArray(r io.Reader, each func(schema.Metrics) error) error {
+	decoder := json.NewDecoder(r)
+	token, err := decoder.Token()
+	if err != nil {
+		return corruptedBy(err)
+	}
+	if token == nil {
+		// empty storage was dumped as null
+		return nil
+	}
+	if token != json.Delim('[') {
+		return corrupted("unexpected token %v", token)
+	}
+	for decoder.More() {
+		var m schema.Metrics
+		if err = decoder.Decode(&m); err != nil {
+			return corruptedBy(err)
+		}
+		if err = each(m); err != nil {
+			return err
+		}
+	}
+	if _, err = decoder.Token(); err != nil {
+		return corruptedBy(err)
+	}
+	return nil
+}
+
+// readBatches passes values of the snapshot to apply by restoreBatch at once
+func readBatches(r io.Reader, apply func([]schema.Metrics) error) error {
+	batch := make([]schema.Metrics, 0, restoreBatch)
+	err := readSnapshot(r, func(m schema.Metrics) error {
+		batch = append(batch, m)
+		if len(batch) < restoreBatch {
+			return nil
+		}
+		err := apply(batch)
+		batch = make([]schema.Metrics, 0, restoreBatch)
+		return err
+	})
+	if err != nil || len(batch) == 0 {
+		return err
+	}
+	return apply(batch)
+}
@@ -1,32 +1,140 @@
 package dumper
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"os"
+	"path/filepath"
 	"sync"
 
 	"logogger/internal/schema"
 )
 
+// SyncDumper writes snapshots into the file. The file is replaced atomically,
+// previous snapshots are kept as filename.1 (the newest), filename.2 and so on
 type SyncDumper struct {
-	filename string
-	wg       sync.WaitGroup
-	mu       sync.Mutex
+	filename    string
+	compression Compression
+	// keep is the number of previous snapshots to retain
+	keep int
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+}
+
+// snapshotPath returns path to the current (zero generation) or previous snapshot
+func (d *SyncDumper) snapshotPath(generation int) string {
+	if generation == 0 {
+		return d.filename
+	}
+	return fmt.Sprintf("%s.%d", d.filename, generation)
 }
 
 func (d *SyncDumper) Dump(l []schema.Metrics) error {
 	d.wg.Add(1)
 	defer d.wg.Done()
 
-	b, err := json.Marshal(l)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// snapshot is written completely before it replaces the current one,
+	// so a crash leaves either the old or the new snapshot
+	tmp := d.filename + ".tmp"
+	if err := d.writeSynced(tmp, l); err != nil {
+		return err
+	}
+
+	for generation := d.keep; generation > 0; generation-- {
+		err := os.Rename(d.snapshotPath(generation-1), d.snapshotPath(generation))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, d.filename); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(d.filename))
+}
+
+func (d *SyncDumper) writeSynced(filename string, l []schema.Metrics) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
 	}
+	err = writeSnapshot(f, l, d.compression)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err_ := f.Close(); err == nil {
+		err = err_
+	}
+	return err
+}
 
+// syncDir makes renames in the directory durable
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if err_ := f.Close(); err == nil {
+		err = err_
+	}
+	return err
+}
+
+// readFile passes values of the snapshot to the callback, errors.Is(err, os.ErrNotExist)
+// is true, if there is no snapshot or it is empty
+func readFile(filename string, read func(f *os.File) error) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return os.ErrNotExist
+	}
+	return read(f)
+}
+
+// Restore passes values of the newest valid snapshot to apply, corrupted ones are skipped.
+// Snapshot is verified before anything is applied. Nothing is restored if there are no snapshots yet
+func (d *SyncDumper) Restore(apply func([]schema.Metrics) error) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	err = os.WriteFile(d.filename, b, 0644)
-	return err
+
+	var corrupted error
+	for generation := 0; generation <= d.keep; generation++ {
+		path := d.snapshotPath(generation)
+		err := readFile(path, func(f *os.File) error {
+			return readSnapshot(f, func(schema.Metrics) error { return nil })
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if errors.Is(err, ErrCorruptedSnapshot) {
+			log.Printf("Skipping snapshot %s: %s", path, err.Error())
+			corrupted = err
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if generation > 0 {
+			log.Printf("Restoring previous snapshot %s", path)
+		}
+		return readFile(path, func(f *os.File) error {
+			return readBatches(f, apply)
+		})
+	}
+	return corrupted
 }
 
 func (d *SyncDumper) Close() error {
@@ -41,3 +149,15 @@ func NewSyncDumper(filename string) *SyncDumper {
 
 	return d
 }
+
+// WithKeep sets the number of previous snapshots to retain
+func (d *SyncDumper) WithKeep(keep int) *SyncDumper {
+	d.keep = keep
+	return d
+}
+
+// WithCompression sets compression of written snapshots
+func (d *SyncDumper) WithCompression(compression Compression) *SyncDumper {
+	d.compression = compression
+	return d
+}
@@ -0,0 +1,137 @@
+package dumper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"logogger/internal/schema"
+)
+
+func restored(t *testing.T, d *SyncDumper) []schema.Metrics {
+	var l []schema.Metrics
+	require.NoError(t, d.Restore(func(batch []schema.Metrics) error {
+		l = append(l, batch...)
+		return nil
+	}))
+	return l
+}
+
+func TestSyncDumper_Rotation(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dump.json")
+	d := NewSyncDumper(filename).WithKeep(2)
+
+	assert.Empty(t, restored(t, d), "missing snapshot is not an error")
+
+	for i := int64(1); i <= 4; i++ {
+		require.NoError(t, d.Dump([]schema.Metrics{schema.NewCounter("PollCount", i)}))
+	}
+	for generation, expected := range []int64{4, 3, 2} {
+		f, err := os.Open(d.snapshotPath(generation))
+		require.NoError(t, err)
+		var l []schema.Metrics
+		require.NoError(t, readSnapshot(f, func(m schema.Metrics) error {
+			l = append(l, m)
+			return nil
+		}))
+		require.NoError(t, f.Close())
+		assert.Equal(t, []schema.Metrics{schema.NewCounter("PollCount", expected)}, l)
+	}
+	assert.NoFileExists(t, filename+".3")
+	assert.NoFileExists(t, filename+".tmp")
+	require.NoError(t, d.Close())
+}
+
+func TestSyncDumper_RestoreFallback(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dump.json")
+	d := NewSyncDumper(filename).WithKeep(2)
+	require.NoError(t, d.Dump([]schema.Metrics{schema.NewCounter("PollCount", 1)}))
+	require.NoError(t, d.Dump([]schema.Metrics{schema.NewCounter("PollCount", 2)}))
+
+	// half-written snapshot
+	b, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filename, b[:len(b)-5], 0644))
+
+	assert.Equal(t, []schema.Metrics{schema.NewCounter("PollCount", 1)}, restored(t, d))
+
+	// damaged value is detected by checksum
+	b, err = os.ReadFile(filename + ".1")
+	require.NoError(t, err)
+	damaged := bytes.Replace(b, []byte(`"delta":1`), []byte(`"delta":2`), 1)
+	require.NotEqual(t, b, damaged)
+	require.NoError(t, os.WriteFile(filename+".1", damaged, 0644))
+	err = d.Restore(func([]schema.Metrics) error {
+		assert.Fail(t, "corrupted snapshot should not be applied")
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrCorruptedSnapshot)
+}
+
+func TestSyncDumper_Compression(t *testing.T) {
+	l := make([]schema.Metrics, 2*restoreBatch+1)
+	for i := range l {
+		l[i] = schema.NewGauge(fmt.Sprintf("Gauge%d", i), float64(i))
+	}
+
+	for _, compression := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+		t.Run(string(compression), func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "dump.json")
+			require.NoError(t, NewSyncDumper(filename).WithCompression(compression).Dump(l))
+
+			// compression is detected, so the dumper is not configured
+			batches := 0
+			var actual []schema.Metrics
+			require.NoError(t, NewSyncDumper(filename).Restore(func(batch []schema.Metrics) error {
+				batches++
+				actual = append(actual, batch...)
+				return nil
+			}))
+			assert.Equal(t, l, actual)
+			assert.Equal(t, 3, batches)
+
+			// truncated compressed snapshot is detected as well
+			b, err := os.ReadFile(filename)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(filename, b[:len(b)-20], 0644))
+			err = NewSyncDumper(filename).Restore(func([]schema.Metrics) error { return nil })
+			assert.ErrorIs(t, err, ErrCorruptedSnapshot)
+		})
+	}
+}
+
+func TestSyncDumper_RestorePreviousFormats(t *testing.T) {
+	legacy := `[{"id":"PollCount","type":"counter","delta":5}]`
+	sum := sha256.Sum256([]byte(legacy))
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	_, err := gw.Write([]byte(legacy))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	params := []struct {
+		name     string
+		data     string
+		expected []schema.Metrics
+	}{
+		{"array", legacy, []schema.Metrics{schema.NewCounter("PollCount", 5)}},
+		{"empty array", "null", nil},
+		{"gzipped array", gzipped.String(), []schema.Metrics{schema.NewCounter("PollCount", 5)}},
+		{"checksummed array", snapshotMagic + " sha256=" + hex.EncodeToString(sum[:]) + "\n" + legacy, []schema.Metrics{schema.NewCounter("PollCount", 5)}},
+	}
+	for _, param := range params {
+		t.Run(param.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "dump.json")
+			require.NoError(t, os.WriteFile(filename, []byte(param.data), 0644))
+			assert.Equal(t, param.expected, restored(t, NewSyncDumper(filename)))
+		})
+	}
+}
@@ -53,6 +53,45 @@ var SysMetrics = [...]string{
 	"TotalAlloc",
 }
 
+const (
+	unitBytes       = "bytes"
+	unitCount       = "count"
+	unitNanoseconds = "nanoseconds"
+	unitRatio       = "ratio"
+	unitPercent     = "percent"
+)
+
+// sysMetricsMetadata describes runtime.MemStats fields, reported as SysMetrics
+var sysMetricsMetadata = map[string]schema.Metadata{
+	"Alloc":         {Unit: unitBytes, Help: "Bytes of allocated heap objects"},
+	"BuckHashSys":   {Unit: unitBytes, Help: "Bytes of memory in profiling bucket hash tables"},
+	"Frees":         {Unit: unitCount, Help: "Cumulative count of heap objects freed"},
+	"GCCPUFraction": {Unit: unitRatio, Help: "Fraction of available CPU time used by the GC since the program started"},
+	"GCSys":         {Unit: unitBytes, Help: "Bytes of memory in garbage collection metadata"},
+	"HeapAlloc":     {Unit: unitBytes, Help: "Bytes of allocated heap objects"},
+	"HeapIdle":      {Unit: unitBytes, Help: "Bytes in idle (unused) spans"},
+	"HeapInuse":     {Unit: unitBytes, Help: "Bytes in in-use spans"},
+	"HeapObjects":   {Unit: unitCount, Help: "Number of allocated heap objects"},
+	"HeapReleased":  {Unit: unitBytes, Help: "Bytes of physical memory returned to the OS"},
+	"HeapSys":       {Unit: unitBytes, Help: "Bytes of heap memory obtained from the OS"},
+	"LastGC":        {Unit: unitNanoseconds, Help: "Time the last garbage collection finished, as nanoseconds since the UNIX epoch"},
+	"Lookups":       {Unit: unitCount, Help: "Number of pointer lookups performed by the runtime"},
+	"MCacheInuse":   {Unit: unitBytes, Help: "Bytes of allocated mcache structures"},
+	"MCacheSys":     {Unit: unitBytes, Help: "Bytes of memory obtained from the OS for mcache structures"},
+	"MSpanInuse":    {Unit: unitBytes, Help: "Bytes of allocated mspan structures"},
+	"MSpanSys":      {Unit: unitBytes, Help: "Bytes of memory obtained from the OS for mspan structures"},
+	"Mallocs":       {Unit: unitCount, Help: "Cumulative count of heap objects allocated"},
+	"NextGC":        {Unit: unitBytes, Help: "Target heap size of the next GC cycle"},
+	"NumForcedGC":   {Unit: unitCount, Help: "Number of GC cycles that were forced by the application calling the GC function"},
+	"NumGC":         {Unit: unitCount, Help: "Number of completed GC cycles"},
+	"OtherSys":      {Unit: unitBytes, Help: "Bytes of memory in miscellaneous off-heap runtime allocations"},
+	"PauseTotalNs":  {Unit: unitNanoseconds, Help: "Cumulative nanoseconds in GC stop-the-world pauses since the program started"},
+	"StackInuse":    {Unit: unitBytes, Help: "Bytes in stack spans"},
+	"StackSys":      {Unit: unitBytes, Help: "Bytes of stack memory obtained from the OS"},
+	"Sys":           {Unit: unitBytes, Help: "Total bytes of memory obtained from the OS"},
+	"TotalAlloc":    {Unit: unitBytes, Help: "Cumulative bytes allocated for heap objects"},
+}
+
 type Poller struct {
 	store storage.MetricsStorage
 	start int64
@@ -134,6 +173,38 @@ func (p Poller) Poll(ctx context.Context) ([]schema.Metrics, error) {
 	return p.store.List(ctx)
 }
 
+// Metadata describes all the metrics reported by poller
+func (p Poller) Metadata() []schema.Metadata {
+	res := []schema.Metadata{
+		{ID: pollCount, MType: schema.MetricsTypeCounter, Unit: unitCount, Help: "Number of polls since the last successful report"},
+		{ID: randomValue, MType: schema.MetricsTypeGauge, Help: "Random value in [0, 1) interval"},
+		{ID: "TotalMemory", MType: schema.MetricsTypeGauge, Unit: unitBytes, Help: "Total amount of RAM on the system"},
+		{ID: "FreeMemory", MType: schema.MetricsTypeGauge, Unit: unitBytes, Help: "Amount of free RAM on the system"},
+	}
+
+	for _, stat := range SysMetrics {
+		meta := sysMetricsMetadata[stat]
+		meta.ID = stat
+		meta.MType = schema.MetricsTypeGauge
+		res = append(res, meta)
+	}
+
+	cpus, err := cpu.Counts(true)
+	if err != nil {
+		// metadata is optional, so it's fine to skip CPU metrics
+		return res
+	}
+	for i := 0; i < cpus; i++ {
+		res = append(res, schema.Metadata{
+			ID:    fmt.Sprintf("CPUutilization%d", i),
+			MType: schema.MetricsTypeGauge,
+			Unit:  unitPercent,
+			Help:  fmt.Sprintf("Utilization of logical CPU %d", i),
+		})
+	}
+	return res
+}
+
 func (p Poller) Reset(ctx context.Context) error {
 	return p.store.Put(ctx, schema.NewCounter(pollCount, p.start))
 }
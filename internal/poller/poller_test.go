@@ -39,3 +39,32 @@ func TestPoller(t *testing.T) {
 	assert.Equal(t, int64(2), c2)
 	assert.Equal(t, int64(1), c3)
 }
+
+func TestPoller_Metadata(t *testing.T) {
+	p, err := NewPoller(context.Background(), 0)
+	if err != nil {
+		assert.FailNow(t, "Error accessing storage.")
+	}
+
+	l, err := p.Poll(context.Background())
+	if err != nil {
+		assert.FailNow(t, "Error polling data.")
+	}
+
+	declared := map[string]schema.Metadata{}
+	for _, meta := range p.Metadata() {
+		assert.NoError(t, meta.Validate())
+		declared[meta.ID] = meta
+	}
+
+	// every polled metrics should be described with the right type
+	for _, m := range l {
+		meta, ok := declared[m.ID]
+		assert.True(t, ok, "metrics %s is not described", m.ID)
+		assert.Equal(t, m.MType, meta.MType)
+	}
+	for _, stat := range SysMetrics {
+		assert.NotEmpty(t, declared[stat].Unit)
+		assert.NotEmpty(t, declared[stat].Help)
+	}
+}
@@ -0,0 +1,103 @@
+// Package proto contains gRPC service definitions and conversions from and to schema types
+package proto
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative metrics.proto
+
+import "logogger/internal/schema"
+
+func fromBins(bins map[int]int64) map[int32]int64 {
+	if bins == nil {
+		return nil
+	}
+	res := make(map[int32]int64, len(bins))
+	for k, v := range bins {
+		res[int32(k)] = v
+	}
+	return res
+}
+
+func toBins(bins map[int32]int64) map[int]int64 {
+	if len(bins) == 0 {
+		return nil
+	}
+	res := make(map[int]int64, len(bins))
+	for k, v := range bins {
+		res[int(k)] = v
+	}
+	return res
+}
+
+// FromSchema converts metrics into protobuf message
+func FromSchema(m schema.Metrics) *Metrics {
+	res := &Metrics{
+		Id:       m.ID,
+		Type:     string(m.MType),
+		Delta:    m.Delta,
+		Value:    m.Value,
+		Hash:     m.Hash,
+		Labels:   m.Labels,
+		SignedAt: m.SignedAt,
+		Nonce:    m.Nonce,
+	}
+	if m.Histogram != nil {
+		res.Histogram = &Histogram{
+			Bounds: m.Histogram.Bounds,
+			Counts: m.Histogram.Counts,
+			Sum:    m.Histogram.Sum,
+			Count:  m.Histogram.Count,
+		}
+	}
+	if m.Summary != nil {
+		res.Summary = &Summary{
+			Positive:  fromBins(m.Summary.Positive),
+			Negative:  fromBins(m.Summary.Negative),
+			Quantiles: m.Summary.Quantiles,
+			Samples:   m.Summary.Samples,
+			Alpha:     m.Summary.Alpha,
+			Sum:       m.Summary.Sum,
+			Count:     m.Summary.Count,
+			Zero:      m.Summary.Zero,
+		}
+	}
+	return res
+}
+
+// ToSchema converts protobuf message into metrics,
+// empty collections are converted to nil, as they are not distinguished in protobuf
+func ToSchema(m *Metrics) schema.Metrics {
+	res := schema.Metrics{
+		ID:       m.GetId(),
+		MType:    schema.MetricsType(m.GetType()),
+		Delta:    m.Delta,
+		Value:    m.Value,
+		Hash:     m.GetHash(),
+		SignedAt: m.GetSignedAt(),
+		Nonce:    m.GetNonce(),
+	}
+	if len(m.GetLabels()) != 0 {
+		res.Labels = m.GetLabels()
+	}
+	if h := m.GetHistogram(); h != nil {
+		res.Histogram = &schema.Histogram{
+			Bounds: h.GetBounds(),
+			Counts: h.GetCounts(),
+			Sum:    h.GetSum(),
+			Count:  h.GetCount(),
+		}
+	}
+	if s := m.GetSummary(); s != nil {
+		res.Summary = &schema.Summary{
+			Positive: toBins(s.GetPositive()),
+			Negative: toBins(s.GetNegative()),
+			Samples:  s.GetSamples(),
+			Alpha:    s.GetAlpha(),
+			Sum:      s.GetSum(),
+			Count:    s.GetCount(),
+			Zero:     s.GetZero(),
+		}
+		if len(s.GetQuantiles()) != 0 {
+			res.Summary.Quantiles = s.GetQuantiles()
+		}
+	}
+	return res
+}
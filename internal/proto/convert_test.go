@@ -0,0 +1,45 @@
+package proto
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	"logogger/internal/schema"
+)
+
+func TestConvert(t *testing.T) {
+	histogram := schema.NewHistogram("latency", []float64{0.1, 1})
+	histogram.Histogram.Observe(0.5)
+	summary := schema.NewSummary("size", schema.DefaultSummaryAccuracy)
+	summary.Summary.Observe(-3)
+	summary.Summary.Observe(0)
+	summary.Summary.Observe(100)
+
+	stamped := schema.NewCounter("PollCount", 1)
+	assert.NoError(t, stamped.Stamp(time.Now()))
+
+	for _, m := range []schema.Metrics{
+		schema.NewCounter("PollCount", 42),
+		stamped,
+		schema.NewGauge("HeapAlloc", 13.37).WithLabels(map[string]string{"host": "agent-1"}),
+		histogram,
+		summary,
+	} {
+		assert.NoError(t, m.Sign("secret"))
+
+		// go through the wire, so empty collections are lost
+		data, err := proto.Marshal(FromSchema(m))
+		assert.NoError(t, err)
+		var decoded Metrics
+		assert.NoError(t, proto.Unmarshal(data, &decoded))
+
+		actual := ToSchema(&decoded)
+		assert.Equal(t, m, actual)
+		signed, err := actual.IsSignedWithKey("secret")
+		assert.NoError(t, err)
+		assert.True(t, signed)
+	}
+}
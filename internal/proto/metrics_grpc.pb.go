@@ -0,0 +1,255 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v3.21.12
+// source: metrics.proto
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	MetricsService_Update_FullMethodName      = "/logogger.MetricsService/Update"
+	MetricsService_UpdateBatch_FullMethodName = "/logogger.MetricsService/UpdateBatch"
+	MetricsService_Get_FullMethodName         = "/logogger.MetricsService/Get"
+	MetricsService_List_FullMethodName        = "/logogger.MetricsService/List"
+)
+
+// MetricsServiceClient is the client API for MetricsService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MetricsServiceClient interface {
+	Update(ctx context.Context, in *Metrics, opts ...grpc.CallOption) (*Metrics, error)
+	UpdateBatch(ctx context.Context, opts ...grpc.CallOption) (MetricsService_UpdateBatchClient, error)
+	Get(ctx context.Context, in *Metrics, opts ...grpc.CallOption) (*Metrics, error)
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+}
+
+type metricsServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMetricsServiceClient(cc grpc.ClientConnInterface) MetricsServiceClient {
+	return &metricsServiceClient{cc}
+}
+
+func (c *metricsServiceClient) Update(ctx context.Context, in *Metrics, opts ...grpc.CallOption) (*Metrics, error) {
+	out := new(Metrics)
+	err := c.cc.Invoke(ctx, MetricsService_Update_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsServiceClient) UpdateBatch(ctx context.Context, opts ...grpc.CallOption) (MetricsService_UpdateBatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MetricsService_ServiceDesc.Streams[0], MetricsService_UpdateBatch_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &metricsServiceUpdateBatchClient{stream}
+	return x, nil
+}
+
+type MetricsService_UpdateBatchClient interface {
+	Send(*Metrics) error
+	CloseAndRecv() (*UpdateBatchResponse, error)
+	grpc.ClientStream
+}
+
+type metricsServiceUpdateBatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *metricsServiceUpdateBatchClient) Send(m *Metrics) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *metricsServiceUpdateBatchClient) CloseAndRecv() (*UpdateBatchResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(UpdateBatchResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *metricsServiceClient) Get(ctx context.Context, in *Metrics, opts ...grpc.CallOption) (*Metrics, error) {
+	out := new(Metrics)
+	err := c.cc.Invoke(ctx, MetricsService_Get_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsServiceClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, MetricsService_List_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MetricsServiceServer is the server API for MetricsService service.
+// All implementations must embed UnimplementedMetricsServiceServer
+// for forward compatibility
+type MetricsServiceServer interface {
+	Update(context.Context, *Metrics) (*Metrics, error)
+	UpdateBatch(MetricsService_UpdateBatchServer) error
+	Get(context.Context, *Metrics) (*Metrics, error)
+	List(context.Context, *ListRequest) (*ListResponse, error)
+	mustEmbedUnimplementedMetricsServiceServer()
+}
+
+// UnimplementedMetricsServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedMetricsServiceServer struct {
+}
+
+func (UnimplementedMetricsServiceServer) Update(context.Context, *Metrics) (*Metrics, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Update not implemented")
+}
+func (UnimplementedMetricsServiceServer) UpdateBatch(MetricsService_UpdateBatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method UpdateBatch not implemented")
+}
+func (UnimplementedMetricsServiceServer) Get(context.Context, *Metrics) (*Metrics, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedMetricsServiceServer) List(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedMetricsServiceServer) mustEmbedUnimplementedMetricsServiceServer() {}
+
+// UnsafeMetricsServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MetricsServiceServer will
+// result in compilation errors.
+type UnsafeMetricsServiceServer interface {
+	mustEmbedUnimplementedMetricsServiceServer()
+}
+
+func RegisterMetricsServiceServer(s grpc.ServiceRegistrar, srv MetricsServiceServer) {
+	s.RegisterService(&MetricsService_ServiceDesc, srv)
+}
+
+func _MetricsService_Update_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Metrics)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServiceServer).Update(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricsService_Update_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServiceServer).Update(ctx, req.(*Metrics))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricsService_UpdateBatch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MetricsServiceServer).UpdateBatch(&metricsServiceUpdateBatchServer{stream})
+}
+
+type MetricsService_UpdateBatchServer interface {
+	SendAndClose(*UpdateBatchResponse) error
+	Recv() (*Metrics, error)
+	grpc.ServerStream
+}
+
+type metricsServiceUpdateBatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *metricsServiceUpdateBatchServer) SendAndClose(m *UpdateBatchResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *metricsServiceUpdateBatchServer) Recv() (*Metrics, error) {
+	m := new(Metrics)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _MetricsService_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Metrics)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServiceServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricsService_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServiceServer).Get(ctx, req.(*Metrics))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricsService_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServiceServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricsService_List_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServiceServer).List(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// MetricsService_ServiceDesc is the grpc.ServiceDesc for MetricsService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MetricsService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "logogger.MetricsService",
+	HandlerType: (*MetricsServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Update",
+			Handler:    _MetricsService_Update_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _MetricsService_Get_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _MetricsService_List_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "UpdateBatch",
+			Handler:       _MetricsService_UpdateBatch_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "metrics.proto",
+}
@@ -0,0 +1,53 @@
+package reporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	clock := time.Unix(1000, 0)
+	b := NewCircuitBreaker(2, time.Minute)
+	b.now = func() time.Time { return clock }
+
+	// single failure does not open the breaker
+	assert.NoError(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, "closed", b.State())
+	assert.NoError(t, b.Allow())
+	b.Success()
+	assert.NoError(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, "closed", b.State())
+
+	assert.NoError(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, "open", b.State())
+	assert.ErrorIs(t, b.Allow(), ErrCircuitOpen)
+
+	// after cooldown only one probe is allowed
+	clock = clock.Add(time.Minute)
+	assert.NoError(t, b.Allow())
+	assert.Equal(t, "half-open", b.State())
+	assert.ErrorIs(t, b.Allow(), ErrCircuitOpen)
+
+	// failed probe opens the breaker again
+	b.Failure()
+	assert.Equal(t, "open", b.State())
+	assert.ErrorIs(t, b.Allow(), ErrCircuitOpen)
+
+	// released probe does not change the state
+	clock = clock.Add(time.Minute)
+	assert.NoError(t, b.Allow())
+	b.Release()
+	assert.Equal(t, "half-open", b.State())
+
+	// successful probe closes the breaker
+	assert.NoError(t, b.Allow())
+	b.Success()
+	assert.Equal(t, "closed", b.State())
+	assert.NoError(t, b.Allow())
+	assert.NoError(t, b.Allow())
+}
@@ -0,0 +1,101 @@
+package reporter
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"logogger/internal/proto"
+	"logogger/internal/schema"
+)
+
+// TokenCredentials attaches API token to every gRPC request,
+// it should be passed to grpc.WithPerRPCCredentials
+type TokenCredentials string
+
+func (c TokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(c)}, nil
+}
+
+// RequireTransportSecurity allows the token to be sent without TLS, as it is in HTTP transport
+func (c TokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// GRPCTransport sends metrics over gRPC connection, payload encryption is not applied
+type GRPCTransport struct {
+	client proto.MetricsServiceClient
+	// key is used to sign metrics, batches are signed at once
+	key string
+}
+
+func (t *GRPCTransport) Send(ctx context.Context, m schema.Metrics) error {
+	if t.key != "" {
+		if err := m.Sign(t.key); err != nil {
+			return err
+		}
+	}
+	start := time.Now()
+	_, err := t.client.Update(ctx, proto.FromSchema(m))
+	log.Printf("Got gRPC response after %dms", time.Since(start).Milliseconds())
+	return err
+}
+
+func (t *GRPCTransport) SendBatch(ctx context.Context, l []schema.Metrics) error {
+	if t.key != "" {
+		signature, err := schema.SignBatch(l, t.key)
+		if err != nil {
+			return err
+		}
+		// signature is passed in metadata, as the stream is signed as a whole
+		ctx = metadata.AppendToOutgoingContext(ctx, schema.BatchSignatureHeader, signature)
+	}
+	start := time.Now()
+	stream, err := t.client.UpdateBatch(ctx)
+	if err != nil {
+		return unsupported(err)
+	}
+	for _, m := range l {
+		if err = stream.Send(proto.FromSchema(m)); err != nil {
+			// actual error is returned by CloseAndRecv
+			break
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	log.Printf("Got gRPC response after %dms", time.Since(start).Milliseconds())
+	return unsupported(err)
+}
+
+// unsupported replaces error of the method, which server does not implement,
+// so batches fall back to ordinary API, as they do over HTTP
+func unsupported(err error) error {
+	if status.Code(err) == codes.Unimplemented {
+		return ErrUnsupported
+	}
+	return err
+}
+
+func (t *GRPCTransport) SendMetadata(context.Context, []schema.Metadata) error {
+	return ErrUnsupported
+}
+
+func (t *GRPCTransport) Capabilities() Capabilities {
+	// metadata could not be declared over gRPC
+	return Capabilities{Batches: true}
+}
+
+// WithKey makes transport sign metrics, batches are signed with a single metadata value
+func (t *GRPCTransport) WithKey(key string) *GRPCTransport {
+	t.key = key
+	return t
+}
+
+// NewGRPCTransport creates transport over established gRPC connection
+func NewGRPCTransport(conn grpc.ClientConnInterface) *GRPCTransport {
+	return &GRPCTransport{client: proto.NewMetricsServiceClient(conn)}
+}
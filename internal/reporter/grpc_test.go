@@ -0,0 +1,123 @@
+package reporter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"logogger/internal/proto"
+	"logogger/internal/schema"
+)
+
+type fakeMetricsService struct {
+	proto.UnimplementedMetricsServiceServer
+	updated []schema.Metrics
+	batches int
+	mu      sync.Mutex
+}
+
+func (s *fakeMetricsService) Update(_ context.Context, m *proto.Metrics) (*proto.Metrics, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updated = append(s.updated, proto.ToSchema(m))
+	return m, nil
+}
+
+func (s *fakeMetricsService) UpdateBatch(stream proto.MetricsService_UpdateBatchServer) error {
+	var l []schema.Metrics
+	for {
+		m, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		l = append(l, proto.ToSchema(m))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updated = append(s.updated, l...)
+	s.batches++
+	return stream.SendAndClose(&proto.UpdateBatchResponse{Accepted: int64(len(l))})
+}
+
+// legacyMetricsService accepts single updates only, as older servers do
+type legacyMetricsService struct {
+	proto.UnimplementedMetricsServiceServer
+	fake *fakeMetricsService
+}
+
+func (s *legacyMetricsService) Update(ctx context.Context, m *proto.Metrics) (*proto.Metrics, error) {
+	return s.fake.Update(ctx, m)
+}
+
+func newFakeGRPCReporter(t *testing.T, service proto.MetricsServiceServer) *Reporter {
+	listener := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	proto.RegisterMetricsServiceServer(s, service)
+	go func() {
+		_ = s.Serve(listener)
+	}()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return NewReporter(NewGRPCTransport(conn))
+}
+
+func TestGRPCReporter(t *testing.T) {
+	service := &fakeMetricsService{}
+	reporter := newFakeGRPCReporter(t, service)
+	l := []schema.Metrics{
+		schema.NewCounter("PollCount", 1),
+		schema.NewGauge("HeapAlloc", 1024).WithLabels(map[string]string{"host": "agent-1"}),
+	}
+
+	err := reporter.ReportMetricsBatches(context.Background(), l)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, service.batches)
+	assert.Equal(t, l, service.updated)
+
+	service.updated = nil
+	err = reporter.ReportMetrics(context.Background(), l)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, service.batches)
+	assert.ElementsMatch(t, l, service.updated)
+
+	// metadata is not supported over gRPC, so it is skipped without errors
+	err = reporter.ReportMetadata(context.Background(), []schema.Metadata{{ID: "PollCount"}})
+	assert.NoError(t, err)
+}
+
+func TestGRPCReporter_Unimplemented(t *testing.T) {
+	service := &fakeMetricsService{}
+	reporter := newFakeGRPCReporter(t, &legacyMetricsService{fake: service})
+	l := []schema.Metrics{
+		schema.NewCounter("PollCount", 1),
+		schema.NewGauge("HeapAlloc", 1024),
+	}
+
+	err := reporter.ReportMetricsBatches(context.Background(), l)
+	assert.NoError(t, err, "batch should fall back to single updates")
+	assert.ElementsMatch(t, l, service.updated)
+	assert.False(t, reporter.capabilities.Batches)
+}
@@ -0,0 +1,229 @@
+package reporter
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"logogger/internal/crypt"
+	"logogger/internal/schema"
+)
+
+// StatusError is returned by HTTPTransport, if server responds with unexpected status
+type StatusError struct {
+	RequestID string
+	Code      int
+	// RetryAfter is set if server asked to wait before the next request
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s server returned %d code", e.RequestID, e.Code)
+}
+
+// parseRetryAfter parses Retry-After header, which is either number of seconds or HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// HTTPTransport sends metrics as JSON to the server HTTP API
+type HTTPTransport struct {
+	encryptor crypt.Encryptor
+	client    *http.Client
+	host      string
+	// realIPHeader is filled with address of the interface, which is used to reach the server
+	realIPHeader string
+	token        string
+	// key is used to sign metrics, batches are signed at once
+	key string
+}
+
+func (t *HTTPTransport) Send(ctx context.Context, m schema.Metrics) error {
+	if t.key != "" {
+		if err := m.Sign(t.key); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(&m)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.postRequest(ctx, "/update/", data, map[string]string{
+		"Content-Type": "application/json; charset=UTF-8",
+	})
+	return err
+}
+
+func (t *HTTPTransport) SendBatch(ctx context.Context, l []schema.Metrics) error {
+	data, err := json.Marshal(&l)
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{
+		"Content-Type":     "application/json; charset=UTF-8",
+		"Content-Encoding": "gzip",
+		"Accept-Encoding":  "gzip",
+	}
+	if t.key != "" {
+		signature, err_ := schema.SignBatch(l, t.key)
+		if err_ != nil {
+			return err_
+		}
+		headers[schema.BatchSignatureHeader] = signature
+	}
+
+	code, err := t.postRequest(ctx, "/updates/", data, headers)
+	// if batches url is unavailable, we should use ordinary API
+	if code == http.StatusNotFound {
+		return ErrUnsupported
+	}
+	return err
+}
+
+func (t *HTTPTransport) SendMetadata(ctx context.Context, l []schema.Metadata) error {
+	data, err := json.Marshal(&l)
+	if err != nil {
+		return err
+	}
+
+	code, err := t.postRequest(ctx, "/metadata/", data, map[string]string{
+		"Content-Type": "application/json; charset=UTF-8",
+	})
+	if code == http.StatusNotFound {
+		return ErrUnsupported
+	}
+	return err
+}
+
+func (t *HTTPTransport) Capabilities() Capabilities {
+	return Capabilities{Batches: true, Metadata: true}
+}
+
+func (t *HTTPTransport) postRequest(ctx context.Context, path string, data []byte, headers map[string]string) (int, error) {
+	url := t.host + path
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("%s, Sending post request to %s", id, url)
+
+	body, err := t.encryptor.Encrypt(data)
+	if err != nil {
+		return 0, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return 0, err
+	}
+
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+	if t.token != "" {
+		request.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	if t.realIPHeader != "" {
+		ip, err_ := outboundIP(request.URL)
+		if err_ != nil {
+			log.Printf("%s Could not detect outbound address: %s", id, err_.Error())
+		} else {
+			request.Header.Set(t.realIPHeader, ip.String())
+		}
+	}
+
+	start := time.Now()
+	resp, err := t.client.Do(request)
+	dur := time.Since(start)
+
+	if err != nil {
+		log.Printf("%s Got error after %dms: %s", id, dur.Milliseconds(), err.Error())
+		return 0, err
+	}
+
+	err = resp.Body.Close()
+	log.Printf("Got response after %dms", dur.Milliseconds())
+	code := resp.StatusCode
+	if err == nil {
+		if code != http.StatusOK {
+			return code, &StatusError{
+				RequestID:  id.String(),
+				Code:       code,
+				RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			}
+		}
+	}
+	return code, nil
+}
+
+// outboundIP returns local address, which is used to connect to the server,
+// UDP socket is not actually connected, so no packets are sent
+func outboundIP(u *url.URL) (net.IP, error) {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	conn, err := net.Dial("udp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// NewHTTPTransport creates transport for the server on host (with scheme),
+// request bodies are encrypted with encryptor
+func NewHTTPTransport(host string, encryptor crypt.Encryptor) *HTTPTransport {
+	return &HTTPTransport{host: host, encryptor: encryptor, client: &http.Client{}}
+}
+
+// WithRealIPHeader makes transport report its address in the header,
+// so the server could check it against trusted subnets
+func (t *HTTPTransport) WithRealIPHeader(header string) *HTTPTransport {
+	t.realIPHeader = header
+	return t
+}
+
+// WithToken makes transport authenticate with API token
+func (t *HTTPTransport) WithToken(token string) *HTTPTransport {
+	t.token = token
+	return t
+}
+
+// WithKey makes transport sign metrics, batches are signed with a single header
+func (t *HTTPTransport) WithKey(key string) *HTTPTransport {
+	t.key = key
+	return t
+}
+
+// WithTLSConfig sets TLS configuration (e.g. client certificate) for requests to the server
+func (t *HTTPTransport) WithTLSConfig(config *tls.Config) *HTTPTransport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	t.client = &http.Client{Transport: transport}
+	return t
+}
@@ -0,0 +1,210 @@
+package reporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"logogger/internal/schema"
+)
+
+const queueFileExt = ".json"
+
+type queuedBatch struct {
+	Created time.Time        `json:"created"`
+	Metrics []schema.Metrics `json:"metrics"`
+}
+
+type queueEntry struct {
+	path    string
+	created time.Time
+	size    int64
+}
+
+// DiskQueue spools batches of metrics to the directory, one file per batch,
+// so they survive agent restarts. Oldest batches are dropped, when the queue
+// grows over its size or they get older than maximum age.
+type DiskQueue struct {
+	now     func() time.Time
+	dir     string
+	entries []queueEntry
+	maxSize int64
+	maxAge  time.Duration
+	size    int64
+	seq     uint64
+	mu      sync.Mutex
+}
+
+// NewDiskQueue opens queue in the directory (creating it if needed) and loads batches left
+// from the previous run, zero maxSize or maxAge disable corresponding limit
+func NewDiskQueue(dir string, maxSize int64, maxAge time.Duration) (*DiskQueue, error) {
+	if maxSize < 0 || maxAge < 0 {
+		return nil, fmt.Errorf("invalid queue limits")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	q := &DiskQueue{now: time.Now, dir: dir, maxSize: maxSize, maxAge: maxAge}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		name := file.Name()
+		path := filepath.Join(dir, name)
+		if strings.HasSuffix(name, queueFileExt+".tmp") {
+			// agent was stopped in the middle of the push
+			_ = os.Remove(path)
+			continue
+		}
+		if file.IsDir() || !strings.HasSuffix(name, queueFileExt) {
+			continue
+		}
+		seq, err_ := strconv.ParseUint(strings.TrimSuffix(name, queueFileExt), 10, 64)
+		if err_ != nil {
+			continue
+		}
+		batch, size, err_ := readBatch(path)
+		if err_ != nil {
+			log.Printf("Dropping corrupted queue file %s: %s", path, err_)
+			_ = os.Remove(path)
+			continue
+		}
+		q.entries = append(q.entries, queueEntry{path: path, created: batch.Created, size: size})
+		q.size += size
+		if seq >= q.seq {
+			q.seq = seq + 1
+		}
+	}
+	// file names are zero-padded, so lexical order is the order of pushes
+	sort.Slice(q.entries, func(i, j int) bool { return q.entries[i].path < q.entries[j].path })
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.expire()
+	return q, nil
+}
+
+func readBatch(path string) (queuedBatch, int64, error) {
+	var batch queuedBatch
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return batch, 0, err
+	}
+	err = json.Unmarshal(data, &batch)
+	return batch, int64(len(data)), err
+}
+
+// writeSynced flushes the file to disk, otherwise it could be left empty
+// or partially written after the crash, although it is already renamed
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err_ := f.Close(); err == nil {
+		err = err_
+	}
+	return err
+}
+
+// dropOldest should be called with the lock held
+func (q *DiskQueue) dropOldest() {
+	entry := q.entries[0]
+	if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Could not remove queue file %s: %s", entry.path, err)
+	}
+	q.entries = q.entries[1:]
+	q.size -= entry.size
+}
+
+// expire should be called with the lock held
+func (q *DiskQueue) expire() {
+	if q.maxAge == 0 {
+		return
+	}
+	for len(q.entries) > 0 && q.now().Sub(q.entries[0].created) > q.maxAge {
+		log.Printf("Dropping expired batch %s from the queue", q.entries[0].path)
+		q.dropOldest()
+	}
+}
+
+// Push appends the batch to the end of the queue
+func (q *DiskQueue) Push(l []schema.Metrics) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	created := q.now()
+	data, err := json.Marshal(queuedBatch{Created: created, Metrics: l})
+	if err != nil {
+		return err
+	}
+	size := int64(len(data))
+	if q.maxSize != 0 && size > q.maxSize {
+		return fmt.Errorf("batch of %d bytes does not fit into the queue", size)
+	}
+
+	q.expire()
+	for q.maxSize != 0 && len(q.entries) > 0 && q.size+size > q.maxSize {
+		log.Printf("Queue is full, dropping batch %s", q.entries[0].path)
+		q.dropOldest()
+	}
+
+	// file is renamed only when it is completely written,
+	// so partially written batches are never replayed
+	path := filepath.Join(q.dir, fmt.Sprintf("%020d%s", q.seq, queueFileExt))
+	tmp := path + ".tmp"
+	if err = writeSynced(tmp, data); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	q.seq++
+	q.entries = append(q.entries, queueEntry{path: path, created: created, size: size})
+	q.size += size
+	return nil
+}
+
+// Replay sends queued batches in order, each batch is removed once it is sent,
+// replay stops on the first error, so the order is preserved
+func (q *DiskQueue) Replay(send func(l []schema.Metrics) error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.expire()
+	for len(q.entries) > 0 {
+		batch, _, err := readBatch(q.entries[0].path)
+		if err != nil {
+			log.Printf("Dropping unreadable batch %s: %s", q.entries[0].path, err)
+			q.dropOldest()
+			continue
+		}
+		if err = send(batch.Metrics); err != nil {
+			return err
+		}
+		q.dropOldest()
+	}
+	return nil
+}
+
+// Len returns number of queued batches
+func (q *DiskQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
@@ -0,0 +1,174 @@
+package reporter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"logogger/internal/schema"
+)
+
+func collect(t *testing.T, q *DiskQueue) [][]schema.Metrics {
+	var res [][]schema.Metrics
+	err := q.Replay(func(l []schema.Metrics) error {
+		res = append(res, l)
+		return nil
+	})
+	assert.NoError(t, err)
+	return res
+}
+
+func TestDiskQueue_Restart(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewDiskQueue(dir, 0, 0)
+	assert.NoError(t, err)
+
+	first := []schema.Metrics{schema.NewCounter("PollCount", 5)}
+	second := []schema.Metrics{schema.NewCounter("PollCount", 3), schema.NewGauge("RandomValue", 0.5)}
+	assert.NoError(t, q.Push(first))
+	assert.NoError(t, q.Push(second))
+
+	// leftovers of interrupted push and foreign files are ignored
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000007.json.tmp"), []byte("[{"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("hello"), 0600))
+
+	q, err = NewDiskQueue(dir, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, q.Len())
+
+	// new batches are placed after restored ones
+	third := []schema.Metrics{schema.NewGauge("RandomValue", 0.7)}
+	assert.NoError(t, q.Push(third))
+	assert.Equal(t, [][]schema.Metrics{first, second, third}, collect(t, q))
+	assert.Equal(t, 0, q.Len())
+
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestDiskQueue_ReplayStopsOnError(t *testing.T) {
+	q, err := NewDiskQueue(t.TempDir(), 0, 0)
+	assert.NoError(t, err)
+	for i := int64(1); i <= 3; i++ {
+		assert.NoError(t, q.Push([]schema.Metrics{schema.NewCounter("PollCount", i)}))
+	}
+
+	sent := 0
+	err = q.Replay(func(l []schema.Metrics) error {
+		if sent == 1 {
+			return errors.New("connection refused")
+		}
+		sent++
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 2, q.Len())
+	assert.Equal(t, [][]schema.Metrics{
+		{schema.NewCounter("PollCount", 2)},
+		{schema.NewCounter("PollCount", 3)},
+	}, collect(t, q))
+}
+
+func TestDiskQueue_Limits(t *testing.T) {
+	batch := []schema.Metrics{schema.NewCounter("PollCount", 1)}
+
+	// size limit fits two batches, but not three,
+	// it is not exact, as the length of serialized timestamp varies
+	q, err := NewDiskQueue(t.TempDir(), 0, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, q.Push(batch))
+	limit := 2*q.size + q.size/2
+
+	q, err = NewDiskQueue(t.TempDir(), limit, 0)
+	assert.NoError(t, err)
+	for i := int64(1); i <= 3; i++ {
+		assert.NoError(t, q.Push([]schema.Metrics{schema.NewCounter("PollCount", i)}))
+	}
+	assert.Equal(t, [][]schema.Metrics{
+		{schema.NewCounter("PollCount", 2)},
+		{schema.NewCounter("PollCount", 3)},
+	}, collect(t, q))
+
+	q, err = NewDiskQueue(t.TempDir(), 1, 0)
+	assert.NoError(t, err)
+	assert.Error(t, q.Push(batch))
+
+	// expired batches are not replayed
+	clock := time.Unix(1000, 0)
+	q, err = NewDiskQueue(t.TempDir(), 0, time.Minute)
+	assert.NoError(t, err)
+	q.now = func() time.Time { return clock }
+	assert.NoError(t, q.Push([]schema.Metrics{schema.NewCounter("PollCount", 1)}))
+	clock = clock.Add(50 * time.Second)
+	assert.NoError(t, q.Push([]schema.Metrics{schema.NewCounter("PollCount", 2)}))
+	clock = clock.Add(50 * time.Second)
+	assert.Equal(t, [][]schema.Metrics{{schema.NewCounter("PollCount", 2)}}, collect(t, q))
+}
+
+func TestReporter_Queue(t *testing.T) {
+	transport := &fakeTransport{
+		batchErr:     errors.New("connection refused"),
+		capabilities: Capabilities{Batches: true},
+	}
+	q, err := NewDiskQueue(t.TempDir(), 0, 0)
+	assert.NoError(t, err)
+	r := NewReporter(transport).WithQueue(q)
+
+	first := []schema.Metrics{schema.NewCounter("PollCount", 5)}
+	second := []schema.Metrics{schema.NewCounter("PollCount", 3)}
+	// batches are spooled, so the agent could safely reset counters
+	assert.NoError(t, r.ReportMetricsBatches(context.Background(), first))
+	assert.NoError(t, r.ReportMetricsBatches(context.Background(), second))
+	assert.Equal(t, 2, q.Len())
+	assert.Empty(t, transport.sent)
+
+	transport.batchErr = nil
+	third := []schema.Metrics{schema.NewCounter("PollCount", 1)}
+	assert.NoError(t, r.ReportMetricsBatches(context.Background(), third))
+	assert.Equal(t, 0, q.Len())
+	assert.Equal(t, append(append(first, second...), third...), transport.sent)
+}
+
+func TestReporter_QueueRejected(t *testing.T) {
+	transport := &fakeTransport{
+		batchErr:     errors.New("connection refused"),
+		capabilities: Capabilities{Batches: true},
+	}
+	q, err := NewDiskQueue(t.TempDir(), 0, 0)
+	assert.NoError(t, err)
+	r := NewReporter(transport).WithQueue(q).WithSignature("secret", false)
+
+	first := []schema.Metrics{schema.NewCounter("PollCount", 5)}
+	second := []schema.Metrics{schema.NewCounter("PollCount", 3)}
+	assert.NoError(t, r.ReportMetricsBatches(context.Background(), first))
+	assert.NoError(t, r.ReportMetricsBatches(context.Background(), second))
+	assert.Equal(t, 2, q.Len())
+
+	// the first queued batch is rejected, it should not block the rest
+	transport.batchErr = nil
+	transport.batchErrs = []error{&StatusError{Code: http.StatusBadRequest}}
+	third := []schema.Metrics{schema.NewCounter("PollCount", 1)}
+	assert.NoError(t, r.ReportMetricsBatches(context.Background(), third))
+	assert.Equal(t, 0, q.Len())
+	require.Len(t, transport.sent, 2)
+	for i, expected := range append(second, third...) {
+		// metrics are stamped when they are sent, not when they are queued
+		assert.NotEmpty(t, transport.sent[i].Nonce)
+		assert.Equal(t, expected, transport.sent[i].Unstamped())
+	}
+
+	// rejected batch is not queued
+	transport.batchErrs = []error{status.Error(codes.FailedPrecondition, "replayed")}
+	assert.Error(t, r.ReportMetricsBatches(context.Background(), third))
+	assert.Equal(t, 0, q.Len())
+}
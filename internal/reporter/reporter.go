@@ -2,140 +2,182 @@
 package reporter
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
-	"net/http"
 	"sync"
 	"time"
 
-	"github.com/google/uuid"
 	"golang.org/x/sync/errgroup"
 
-	"logogger/internal/crypt"
 	"logogger/internal/schema"
 	"logogger/internal/utils"
 )
 
 type Reporter struct {
-	batches   bool
-	wg        sync.WaitGroup
-	encryptor crypt.Encryptor
+	transport Transport
+	// queue spools batches, which could not be sent, if set
+	queue *DiskQueue
+	wg    sync.WaitGroup
+	// capabilities are negotiated with the server,
+	// operations rejected by it are not used anymore
+	capabilities Capabilities
+	// key enables replay protection, metrics are stamped right before they are sent
+	key string
+	// legacy makes every metrics in the batch signed, as older servers do not check batch signatures
+	legacy bool
+	mu     sync.Mutex
 }
 
-func (reporter *Reporter) ReportMetrics(ctx context.Context, l []schema.Metrics, host string) error {
+func (reporter *Reporter) negotiated() Capabilities {
+	reporter.mu.Lock()
+	defer reporter.mu.Unlock()
+	return reporter.capabilities
+}
+
+// stamp returns copy of metrics stamped for replay protection. Metrics are stamped
+// at send time, so batches replayed from the queue are not rejected as stale
+func (reporter *Reporter) stamp(l []schema.Metrics) ([]schema.Metrics, error) {
+	if reporter.key == "" {
+		return l, nil
+	}
+	now := time.Now()
+	stamped := make([]schema.Metrics, 0, len(l))
+	for _, m := range l {
+		if reporter.legacy {
+			// legacy servers reject unknown fields, so metrics are signed without the stamp
+			if err := m.Sign(reporter.key); err != nil {
+				return nil, err
+			}
+		} else if err := m.Stamp(now); err != nil {
+			return nil, err
+		}
+		stamped = append(stamped, m)
+	}
+	return stamped, nil
+}
+
+func (reporter *Reporter) ReportMetrics(ctx context.Context, l []schema.Metrics) error {
 	reporter.wg.Add(1)
 	defer reporter.wg.Done()
 
+	l, err := reporter.stamp(l)
+	if err != nil {
+		return err
+	}
 	eg := &errgroup.Group{}
 
 	for _, m := range l {
 		m := m
-		url := fmt.Sprintf("%s/update/", host)
 		eg.Go(utils.WrapGoroutinePanic(func() error {
-			return postSingleRequest(ctx, url, m, reporter.encryptor)
+			return reporter.transport.Send(ctx, m)
 		}))
 	}
 
 	return eg.Wait()
 }
 
-func (reporter *Reporter) ReportMetricsBatches(ctx context.Context, l []schema.Metrics, host string) error {
+// ReportMetricsBatches sends all the metrics at once if the server supports it.
+// If the reporter has a queue, batches which could not be sent are spooled
+// (it is not considered as an error) and sent before the next ones.
+// Batches rejected by the server are not queued, so they do not block the next ones.
+func (reporter *Reporter) ReportMetricsBatches(ctx context.Context, l []schema.Metrics) error {
 	reporter.wg.Add(1)
 	defer reporter.wg.Done()
 
-	if !reporter.batches {
-		return reporter.ReportMetrics(ctx, l, host)
+	if reporter.queue == nil {
+		return reporter.sendBatch(ctx, l)
 	}
 
-	if len(l) == 0 {
-		return nil
+	// queued batches are sent first to preserve order of updates
+	err := reporter.queue.Replay(func(queued []schema.Metrics) error {
+		err := reporter.sendBatch(ctx, queued)
+		if isRejected(err) {
+			// batch would never be accepted, so it should not block the queue
+			log.Printf("Dropping queued batch rejected by server: %s", err)
+			return nil
+		}
+		return err
+	})
+	if err == nil {
+		err = reporter.sendBatch(ctx, l)
 	}
-	url := fmt.Sprintf("%s/updates/", host)
-	code, err := postBatchRequest(ctx, url, l, reporter.encryptor)
-
-	// if batches url is unavailable, we should use ordinary API
-	if code != http.StatusNotFound {
+	if err == nil || len(l) == 0 || isRejected(err) {
 		return err
 	}
-	reporter.batches = false
-	return reporter.ReportMetrics(ctx, l, host)
-}
 
-func (reporter *Reporter) Shutdown() {
-	reporter.wg.Wait()
+	if pushErr := reporter.queue.Push(l); pushErr != nil {
+		log.Printf("Could not queue metrics: %s", pushErr)
+		return err
+	}
+	log.Printf("Could not send metrics, batch is queued: %s", err)
+	return nil
 }
 
-func NewReporter(encryptor crypt.Encryptor) *Reporter {
-	return &Reporter{batches: true, encryptor: encryptor, wg: sync.WaitGroup{}}
-}
+func (reporter *Reporter) sendBatch(ctx context.Context, l []schema.Metrics) error {
+	if !reporter.negotiated().Batches {
+		return reporter.ReportMetrics(ctx, l)
+	}
 
-func postSingleRequest(ctx context.Context, url string, m schema.Metrics, encryptor crypt.Encryptor) error {
-	data, err := json.Marshal(&m)
+	if len(l) == 0 {
+		return nil
+	}
+	stamped, err := reporter.stamp(l)
 	if err != nil {
 		return err
 	}
-
-	_, err = postRequest(ctx, url, data, encryptor, map[string]string{
-		"Content-Type": "application/json; charset=UTF-8",
-	})
-	return err
-}
-
-func postBatchRequest(ctx context.Context, url string, l []schema.Metrics, encryptor crypt.Encryptor) (int, error) {
-	data, err := json.Marshal(&l)
-	if err != nil {
-		return 0, err
+	err = reporter.transport.SendBatch(ctx, stamped)
+	if !errors.Is(err, ErrUnsupported) {
+		return err
 	}
 
-	return postRequest(ctx, url, data, encryptor, map[string]string{
-		"Content-Type":     "application/json; charset=UTF-8",
-		"Content-Encoding": "gzip",
-		"Accept-Encoding":  "gzip",
-	})
+	// if batches are unavailable, we should use ordinary API
+	log.Println("Server does not support batches, falling back to single updates")
+	reporter.mu.Lock()
+	reporter.capabilities.Batches = false
+	reporter.mu.Unlock()
+	return reporter.ReportMetrics(ctx, l)
 }
 
-func postRequest(ctx context.Context, url string, data []byte, encryptor crypt.Encryptor, headers map[string]string) (int, error) {
-	id, err := uuid.NewRandom()
-	if err != nil {
-		return 0, err
-	}
-	log.Printf("%s, Sending post request to %s", id, url)
-
-	body, err := encryptor.Encrypt(data)
-	if err != nil {
-		return 0, err
-	}
+// ReportMetadata declares metadata of reported metrics on the server.
+// Servers and transports, which do not support metadata, are silently ignored.
+func (reporter *Reporter) ReportMetadata(ctx context.Context, l []schema.Metadata) error {
+	reporter.wg.Add(1)
+	defer reporter.wg.Done()
 
-	request, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
-	if err != nil {
-		return 0, err
+	if !reporter.negotiated().Metadata {
+		return nil
 	}
 
-	for key, value := range headers {
-		request.Header.Set(key, value)
+	err := reporter.transport.SendMetadata(ctx, l)
+	if errors.Is(err, ErrUnsupported) {
+		log.Println("Server does not support metadata, skipping")
+		reporter.mu.Lock()
+		reporter.capabilities.Metadata = false
+		reporter.mu.Unlock()
+		return nil
 	}
+	return err
+}
 
-	client := &http.Client{}
+func (reporter *Reporter) Shutdown() {
+	reporter.wg.Wait()
+}
 
-	start := time.Now()
-	resp, err := client.Do(request)
-	dur := time.Since(start)
+func NewReporter(transport Transport) *Reporter {
+	return &Reporter{transport: transport, capabilities: transport.Capabilities()}
+}
 
-	if err != nil {
-		log.Printf("%s Got error after %dms: %s", id, dur.Milliseconds(), err.Error())
-		return 0, err
-	}
+// WithSignature makes reporter stamp metrics for replay protection, transports sign them with the key.
+// Legacy servers do not check batch signatures and replay stamps, so every metrics is signed
+// without the stamp if requested
+func (reporter *Reporter) WithSignature(key string, legacy bool) *Reporter {
+	reporter.key = key
+	reporter.legacy = legacy
+	return reporter
+}
 
-	err = resp.Body.Close()
-	log.Printf("Got response after %dms", dur.Milliseconds())
-	code := resp.StatusCode
-	if err == nil {
-		if code != http.StatusOK {
-			return code, fmt.Errorf("%s server returned %d code", id, code)
-		}
-	}
-	return code, nil
+func (reporter *Reporter) WithQueue(queue *DiskQueue) *Reporter {
+	reporter.queue = queue
+	return reporter
 }
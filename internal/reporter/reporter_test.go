@@ -3,6 +3,7 @@ package reporter
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -10,6 +11,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"logogger/internal/crypt"
 	"logogger/internal/poller"
@@ -75,8 +77,8 @@ func TestReportMetrics(t *testing.T) {
 	defer server.Close()
 	encryptor, err := crypt.NewEncryptor("")
 	assert.NoError(t, err)
-	poller := NewReporter(encryptor)
-	err = poller.ReportMetrics(context.Background(), l, server.URL)
+	poller := NewReporter(NewHTTPTransport(server.URL, encryptor))
+	err = poller.ReportMetrics(context.Background(), l)
 
 	if err != nil {
 		assert.FailNow(t, "Error reporting data.")
@@ -106,11 +108,11 @@ func TestReportMetrics_FaultyServer(t *testing.T) {
 	server := httptest.NewServer(handler)
 	encryptor, err := crypt.NewEncryptor("")
 	assert.NoError(t, err)
-	poller := NewReporter(encryptor)
+	poller := NewReporter(NewHTTPTransport(server.URL, encryptor))
 
-	err1 := poller.ReportMetrics(context.Background(), l, server.URL)
+	err1 := poller.ReportMetrics(context.Background(), l)
 	server.Close()
-	err2 := poller.ReportMetrics(context.Background(), l, server.URL)
+	err2 := poller.ReportMetrics(context.Background(), l)
 
 	assert.NotNil(t, err1)
 	assert.NotNil(t, err2)
@@ -169,8 +171,8 @@ func TestReportBatchMetrics(t *testing.T) {
 	defer server.Close()
 	encryptor, err := crypt.NewEncryptor("")
 	assert.NoError(t, err)
-	poller := NewReporter(encryptor)
-	err = poller.ReportMetricsBatches(context.Background(), l, server.URL)
+	poller := NewReporter(NewHTTPTransport(server.URL, encryptor))
+	err = poller.ReportMetricsBatches(context.Background(), l)
 
 	if err != nil {
 		assert.FailNow(t, "Error reporting data.")
@@ -180,3 +182,200 @@ func TestReportBatchMetrics(t *testing.T) {
 		assert.True(t, value)
 	}
 }
+
+func TestReportMetadata(t *testing.T) {
+	p, err := poller.NewPoller(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("Error accessing storage.")
+	}
+
+	var reported []schema.Metadata
+	handler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "/metadata/", request.URL.String())
+		err_ := json.NewDecoder(request.Body).Decode(&reported)
+		assert.NoError(t, err_)
+	})
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	encryptor, err := crypt.NewEncryptor("")
+	assert.NoError(t, err)
+	r := NewReporter(NewHTTPTransport(server.URL, encryptor))
+	err = r.ReportMetadata(context.Background(), p.Metadata())
+
+	assert.NoError(t, err)
+	assert.Equal(t, p.Metadata(), reported)
+}
+
+func TestReportMetadata_NotSupported(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	encryptor, err := crypt.NewEncryptor("")
+	assert.NoError(t, err)
+	r := NewReporter(NewHTTPTransport(server.URL, encryptor))
+
+	err = r.ReportMetadata(context.Background(), []schema.Metadata{{ID: "PollCount"}})
+	assert.NoError(t, err)
+}
+
+type fakeTransport struct {
+	// batchErrs are returned by the first batches, batchErr is returned by the rest
+	batchErrs    []error
+	batchErr     error
+	metadataErr  error
+	capabilities Capabilities
+	sent         []schema.Metrics
+	batches      int
+	metadata     int
+	mu           sync.Mutex
+}
+
+func (t *fakeTransport) Send(_ context.Context, m schema.Metrics) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent = append(t.sent, m)
+	return nil
+}
+
+func (t *fakeTransport) SendBatch(_ context.Context, l []schema.Metrics) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.batches++
+	if len(t.batchErrs) > 0 {
+		err := t.batchErrs[0]
+		t.batchErrs = t.batchErrs[1:]
+		return err
+	}
+	if t.batchErr != nil {
+		return t.batchErr
+	}
+	t.sent = append(t.sent, l...)
+	return nil
+}
+
+func (t *fakeTransport) SendMetadata(context.Context, []schema.Metadata) error {
+	t.metadata++
+	return t.metadataErr
+}
+
+func (t *fakeTransport) Capabilities() Capabilities {
+	return t.capabilities
+}
+
+func TestReporter_Signature(t *testing.T) {
+	l := []schema.Metrics{schema.NewCounter("PollCount", 1)}
+
+	transport := &fakeTransport{capabilities: Capabilities{Batches: true}}
+	assert.NoError(t, NewReporter(transport).WithSignature("secret", false).ReportMetricsBatches(context.Background(), l))
+	require.Len(t, transport.sent, 1)
+	assert.NotEmpty(t, transport.sent[0].Nonce, "metrics should be stamped for replay protection")
+	assert.Empty(t, transport.sent[0].Hash, "batch should be signed by transport")
+
+	// legacy servers reject unknown fields, so stamp is not sent to them
+	transport = &fakeTransport{capabilities: Capabilities{Batches: true}}
+	assert.NoError(t, NewReporter(transport).WithSignature("secret", true).ReportMetricsBatches(context.Background(), l))
+	require.Len(t, transport.sent, 1)
+	assert.Zero(t, transport.sent[0].SignedAt)
+	assert.Empty(t, transport.sent[0].Nonce)
+	signed, err := transport.sent[0].IsSignedWithKey("secret")
+	assert.NoError(t, err)
+	assert.True(t, signed)
+}
+
+func TestReporter_Negotiation(t *testing.T) {
+	l := []schema.Metrics{schema.NewCounter("PollCount", 1), schema.NewGauge("RandomValue", 0.5)}
+
+	// batches are not used if transport could not send them
+	transport := &fakeTransport{}
+	r := NewReporter(transport)
+	assert.NoError(t, r.ReportMetricsBatches(context.Background(), l))
+	assert.NoError(t, r.ReportMetadata(context.Background(), []schema.Metadata{{ID: "PollCount"}}))
+	assert.Equal(t, 0, transport.batches)
+	assert.Equal(t, 0, transport.metadata)
+	assert.ElementsMatch(t, l, transport.sent)
+
+	// server rejects batches and metadata once, so they are not tried again
+	transport = &fakeTransport{
+		batchErr:     ErrUnsupported,
+		metadataErr:  ErrUnsupported,
+		capabilities: Capabilities{Batches: true, Metadata: true},
+	}
+	r = NewReporter(transport)
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, r.ReportMetricsBatches(context.Background(), l))
+		assert.NoError(t, r.ReportMetadata(context.Background(), []schema.Metadata{{ID: "PollCount"}}))
+	}
+	assert.Equal(t, 1, transport.batches)
+	assert.Equal(t, 1, transport.metadata)
+	assert.ElementsMatch(t, append(l, l...), transport.sent)
+
+	// other errors do not change capabilities
+	transport = &fakeTransport{
+		batchErr:     errors.New("connection refused"),
+		capabilities: Capabilities{Batches: true},
+	}
+	r = NewReporter(transport)
+	assert.Error(t, r.ReportMetricsBatches(context.Background(), l))
+	assert.Error(t, r.ReportMetricsBatches(context.Background(), l))
+	assert.Equal(t, 2, transport.batches)
+	assert.Empty(t, transport.sent)
+}
+
+func TestHTTPTransport_Unsupported(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	encryptor, err := crypt.NewEncryptor("")
+	assert.NoError(t, err)
+	transport := NewHTTPTransport(server.URL, encryptor)
+
+	assert.ErrorIs(t, transport.SendBatch(context.Background(), []schema.Metrics{schema.NewCounter("PollCount", 1)}), ErrUnsupported)
+	assert.ErrorIs(t, transport.SendMetadata(context.Background(), []schema.Metadata{{ID: "PollCount"}}), ErrUnsupported)
+	assert.Error(t, transport.Send(context.Background(), schema.NewCounter("PollCount", 1)))
+}
+
+func TestHTTPTransport_RealIP(t *testing.T) {
+	var reported string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reported = r.Header.Get("X-Real-IP")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	encryptor, err := crypt.NewEncryptor("")
+	assert.NoError(t, err)
+
+	transport := NewHTTPTransport(server.URL, encryptor).WithRealIPHeader("X-Real-IP")
+	assert.NoError(t, transport.Send(context.Background(), schema.NewCounter("PollCount", 1)))
+	assert.Equal(t, "127.0.0.1", reported)
+
+	transport = NewHTTPTransport(server.URL, encryptor)
+	assert.NoError(t, transport.Send(context.Background(), schema.NewCounter("PollCount", 1)))
+	assert.Empty(t, reported)
+}
+
+func TestHTTPTransport_Signature(t *testing.T) {
+	var signature string
+	var body []schema.Metrics
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get(schema.BatchSignatureHeader)
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	encryptor, err := crypt.NewEncryptor("")
+	assert.NoError(t, err)
+
+	l := []schema.Metrics{schema.NewCounter("PollCount", 1), schema.NewGauge("Alloc", 13.37)}
+	transport := NewHTTPTransport(server.URL, encryptor).WithKey("secret")
+	assert.NoError(t, transport.SendBatch(context.Background(), l))
+	signed, err := schema.IsBatchSignedWithKey(body, "secret", signature)
+	assert.NoError(t, err)
+	assert.True(t, signed)
+	assert.Empty(t, body[0].Hash, "metrics in signed batch should not be signed separately")
+
+	transport = NewHTTPTransport(server.URL, encryptor)
+	assert.NoError(t, transport.SendBatch(context.Background(), l))
+	assert.Empty(t, signature)
+}
@@ -0,0 +1,236 @@
+package reporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"logogger/internal/schema"
+)
+
+// RetryPolicy describes exponential backoff between attempts to send the request
+type RetryPolicy struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	// Jitter is the fraction of interval, which is randomly subtracted from it,
+	// so agents do not retry simultaneously
+	Jitter      float64
+	MaxAttempts int
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		InitialInterval: time.Second,
+		MaxInterval:     5 * time.Second,
+		Multiplier:      2,
+		Jitter:          0.5,
+		MaxAttempts:     3,
+	}
+}
+
+func (p RetryPolicy) Validate() error {
+	if p.InitialInterval <= 0 || p.MaxInterval < p.InitialInterval {
+		return fmt.Errorf("invalid retry intervals")
+	}
+	if p.Multiplier < 1 {
+		return fmt.Errorf("invalid retry multiplier")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("invalid retry jitter")
+	}
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("invalid number of attempts")
+	}
+	return nil
+}
+
+// interval returns the delay before retry after specified (starting from one) attempt,
+// random is a value in [0, 1)
+func (p RetryPolicy) interval(attempt int, random float64) time.Duration {
+	d := float64(p.InitialInterval) * math.Pow(p.Multiplier, float64(attempt-1))
+	if d > float64(p.MaxInterval) {
+		d = float64(p.MaxInterval)
+	}
+	return time.Duration(d * (1 - p.Jitter*random))
+}
+
+// isRetryable classifies errors, which are caused by temporary server unavailability
+func isRetryable(err error) bool {
+	var statusErr *StatusError
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, ErrUnsupported), errors.Is(err, ErrCircuitOpen):
+		return false
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.As(err, &statusErr):
+		switch statusErr.Code {
+		case http.StatusRequestTimeout,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded, codes.Internal:
+			return true
+		}
+		return false
+	}
+
+	// network errors
+	return true
+}
+
+// isRejected classifies errors, which mean the server would never accept the request,
+// e.g. it is malformed, unauthorized or replayed, so it should not be sent again
+func isRejected(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.Code {
+		case http.StatusBadRequest,
+			http.StatusUnauthorized,
+			http.StatusForbidden,
+			http.StatusConflict,
+			http.StatusRequestEntityTooLarge:
+			return true
+		}
+		return false
+	}
+
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.InvalidArgument, codes.Unauthenticated, codes.PermissionDenied, codes.FailedPrecondition:
+			return true
+		}
+	}
+	return false
+}
+
+func retryAfter(err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter
+	}
+	return 0
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RetryingTransport retries requests of the underlying transport according to the policy,
+// only errors caused by server unavailability are retried and counted by the circuit breaker
+type RetryingTransport struct {
+	transport Transport
+	breaker   *CircuitBreaker
+	sleep     func(ctx context.Context, d time.Duration) error
+	random    *rand.Rand
+	policy    RetryPolicy
+	mu        sync.Mutex
+}
+
+func (t *RetryingTransport) randomFloat() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.random.Float64()
+}
+
+func (t *RetryingTransport) do(ctx context.Context, request func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if t.breaker != nil {
+			if err = t.breaker.Allow(); err != nil {
+				return err
+			}
+		}
+
+		err = request()
+
+		if t.breaker != nil {
+			switch {
+			case isRetryable(err):
+				t.breaker.Failure()
+			case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+				t.breaker.Release()
+			default:
+				// server has responded, so it is available
+				t.breaker.Success()
+			}
+		}
+
+		if !isRetryable(err) || attempt >= t.policy.MaxAttempts {
+			return err
+		}
+
+		delay := t.policy.interval(attempt, t.randomFloat())
+		if wait := retryAfter(err); wait > 0 {
+			if wait > t.policy.MaxInterval {
+				log.Printf("Server asked to retry after %s, giving up", wait)
+				return err
+			}
+			delay = wait
+		}
+		log.Printf("Attempt %d failed, retrying in %s: %s", attempt, delay, err)
+		if sleepErr := t.sleep(ctx, delay); sleepErr != nil {
+			return err
+		}
+	}
+}
+
+func (t *RetryingTransport) Send(ctx context.Context, m schema.Metrics) error {
+	return t.do(ctx, func() error {
+		return t.transport.Send(ctx, m)
+	})
+}
+
+func (t *RetryingTransport) SendBatch(ctx context.Context, l []schema.Metrics) error {
+	return t.do(ctx, func() error {
+		return t.transport.SendBatch(ctx, l)
+	})
+}
+
+func (t *RetryingTransport) SendMetadata(ctx context.Context, l []schema.Metadata) error {
+	return t.do(ctx, func() error {
+		return t.transport.SendMetadata(ctx, l)
+	})
+}
+
+func (t *RetryingTransport) Capabilities() Capabilities {
+	return t.transport.Capabilities()
+}
+
+// NewRetryingTransport wraps transport with retries, breaker is optional
+func NewRetryingTransport(transport Transport, policy RetryPolicy, breaker *CircuitBreaker) *RetryingTransport {
+	return &RetryingTransport{
+		transport: transport,
+		breaker:   breaker,
+		sleep:     sleepContext,
+		random:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		policy:    policy,
+	}
+}
@@ -0,0 +1,165 @@
+package reporter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"logogger/internal/crypt"
+	"logogger/internal/schema"
+)
+
+func TestRetryPolicy_Interval(t *testing.T) {
+	p := RetryPolicy{
+		InitialInterval: time.Second,
+		MaxInterval:     5 * time.Second,
+		Multiplier:      2,
+		Jitter:          0.5,
+		MaxAttempts:     5,
+	}
+	assert.NoError(t, p.Validate())
+
+	assert.Equal(t, time.Second, p.interval(1, 0))
+	assert.Equal(t, 2*time.Second, p.interval(2, 0))
+	assert.Equal(t, 4*time.Second, p.interval(3, 0))
+	assert.Equal(t, 5*time.Second, p.interval(4, 0))
+	assert.Equal(t, 2*time.Second, p.interval(3, 1))
+	assert.Equal(t, 3*time.Second, p.interval(3, 0.5))
+
+	p.Jitter = 2
+	assert.Error(t, p.Validate())
+}
+
+func TestIsRetryable(t *testing.T) {
+	params := []struct {
+		err       error
+		retryable bool
+	}{
+		{nil, false},
+		{errors.New("connection refused"), true},
+		{&StatusError{Code: http.StatusServiceUnavailable}, true},
+		{&StatusError{Code: http.StatusTooManyRequests}, true},
+		{&StatusError{Code: http.StatusBadRequest}, false},
+		{&StatusError{Code: http.StatusConflict}, false},
+		{status.Error(codes.Unavailable, "down"), true},
+		{status.Error(codes.InvalidArgument, "signature mismatch"), false},
+		{ErrUnsupported, false},
+		{ErrCircuitOpen, false},
+		{context.Canceled, false},
+	}
+	for _, param := range params {
+		assert.Equal(t, param.retryable, isRetryable(param.err), "%v", param.err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 120*time.Second, parseRetryAfter("120", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter("Sat, 01 Oct 2022 12:00:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Sat, 01 Oct 2022 11:00:00 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+}
+
+func newTestRetryingTransport(transport Transport, breaker *CircuitBreaker) (*RetryingTransport, *[]time.Duration) {
+	var delays []time.Duration
+	var mu sync.Mutex
+	t := NewRetryingTransport(transport, RetryPolicy{
+		InitialInterval: time.Second,
+		MaxInterval:     10 * time.Second,
+		Multiplier:      2,
+		MaxAttempts:     3,
+	}, breaker)
+	t.sleep = func(_ context.Context, d time.Duration) error {
+		mu.Lock()
+		defer mu.Unlock()
+		delays = append(delays, d)
+		return nil
+	}
+	return t, &delays
+}
+
+func TestRetryingTransport_HTTP(t *testing.T) {
+	var responses []func(w http.ResponseWriter)
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responses[calls](w)
+		calls++
+	}))
+	defer server.Close()
+	encryptor, err := crypt.NewEncryptor("")
+	assert.NoError(t, err)
+
+	unavailable := func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) }
+	throttled := func(w http.ResponseWriter) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}
+	ok := func(w http.ResponseWriter) { w.WriteHeader(http.StatusOK) }
+	invalid := func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadRequest) }
+
+	// backoff is exponential, Retry-After overrides it
+	responses = []func(w http.ResponseWriter){unavailable, throttled, ok}
+	transport, delays := newTestRetryingTransport(NewHTTPTransport(server.URL, encryptor), nil)
+	assert.NoError(t, transport.Send(context.Background(), schema.NewCounter("PollCount", 1)))
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, []time.Duration{time.Second, 7 * time.Second}, *delays)
+
+	// attempts are limited
+	calls = 0
+	responses = []func(w http.ResponseWriter){unavailable, unavailable, unavailable, ok}
+	transport, _ = newTestRetryingTransport(NewHTTPTransport(server.URL, encryptor), nil)
+	err = transport.Send(context.Background(), schema.NewCounter("PollCount", 1))
+	var statusErr *StatusError
+	assert.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusServiceUnavailable, statusErr.Code)
+	assert.Equal(t, 3, calls)
+
+	// client errors are not retried
+	calls = 0
+	responses = []func(w http.ResponseWriter){invalid, ok}
+	transport, _ = newTestRetryingTransport(NewHTTPTransport(server.URL, encryptor), nil)
+	assert.Error(t, transport.Send(context.Background(), schema.NewCounter("PollCount", 1)))
+	assert.Equal(t, 1, calls)
+}
+
+type failingTransport struct {
+	fakeTransport
+	err   error
+	calls int
+}
+
+func (t *failingTransport) SendBatch(context.Context, []schema.Metrics) error {
+	t.calls++
+	return t.err
+}
+
+func TestRetryingTransport_Breaker(t *testing.T) {
+	clock := time.Unix(1000, 0)
+	breaker := NewCircuitBreaker(3, time.Minute)
+	breaker.now = func() time.Time { return clock }
+	failing := &failingTransport{err: status.Error(codes.Unavailable, "down")}
+	transport, _ := newTestRetryingTransport(failing, breaker)
+	l := []schema.Metrics{schema.NewCounter("PollCount", 1)}
+
+	// all attempts fail, so the breaker opens
+	assert.Error(t, transport.SendBatch(context.Background(), l))
+	assert.Equal(t, 3, failing.calls)
+	assert.ErrorIs(t, transport.SendBatch(context.Background(), l), ErrCircuitOpen)
+	assert.Equal(t, 3, failing.calls)
+
+	// the probe succeeds after cooldown
+	clock = clock.Add(time.Minute)
+	failing.err = nil
+	assert.NoError(t, transport.SendBatch(context.Background(), l))
+	assert.Equal(t, 4, failing.calls)
+	assert.Equal(t, "closed", breaker.State())
+}
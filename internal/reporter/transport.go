@@ -0,0 +1,31 @@
+package reporter
+
+import (
+	"context"
+	"errors"
+
+	"logogger/internal/schema"
+)
+
+// ErrUnsupported is returned by transport, if the server does not support requested operation,
+// reporter should not use the operation further and fall back to other ones
+var ErrUnsupported = errors.New("operation is not supported by the server")
+
+// Capabilities describe optional operations, which transport is able to perform
+type Capabilities struct {
+	Batches  bool
+	Metadata bool
+}
+
+// Transport delivers metrics to the server
+type Transport interface {
+	// Send sends single metrics
+	Send(ctx context.Context, m schema.Metrics) error
+	// SendBatch sends all the metrics at once, it is used only if Batches capability is set
+	SendBatch(ctx context.Context, l []schema.Metrics) error
+	// SendMetadata declares metrics metadata, it is used only if Metadata capability is set
+	SendMetadata(ctx context.Context, l []schema.Metadata) error
+	// Capabilities returns operations, which transport is able to perform,
+	// server could still reject them with ErrUnsupported
+	Capabilities() Capabilities
+}
@@ -0,0 +1,146 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultHistogramBounds are used for histograms created from a single observation,
+// e.g. when it is submitted via plain-text API.
+var DefaultHistogramBounds = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram holds distribution of observed values.
+//
+// Bounds are upper inclusive boundaries of buckets in increasing order,
+// Counts hold number of observations per bucket (not cumulative),
+// the last element of Counts corresponds to the implicit +Inf bucket,
+// so len(Counts) is always len(Bounds) + 1.
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []int64   `json:"counts"`
+	Sum    float64   `json:"sum"`
+	Count  int64     `json:"count"`
+}
+
+type invalidHistogramError struct {
+	reason string
+}
+
+func (e invalidHistogramError) Error() string {
+	return e.reason
+}
+
+func NewHistogramRequest(id string) Metrics {
+	return Metrics{ID: id, MType: MetricsTypeHistogram}
+}
+
+// NewHistogram creates histogram metrics without any observations
+func NewHistogram(id string, bounds []float64) Metrics {
+	h := &Histogram{
+		Bounds: append([]float64(nil), bounds...),
+		Counts: make([]int64, len(bounds)+1),
+	}
+	return Metrics{ID: id, MType: MetricsTypeHistogram, Histogram: h}
+}
+
+// Observe adds single value to the histogram
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.Bounds, value)
+	h.Counts[i]++
+	h.Count++
+	h.Sum += value
+}
+
+// Validate checks that histogram is consistent
+func (h Histogram) Validate() error {
+	if math.IsNaN(h.Sum) || math.IsInf(h.Sum, 0) {
+		return invalidHistogramError{"histogram sum should be finite"}
+	}
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return invalidHistogramError{fmt.Sprintf("histogram with %d bounds should have %d counts, got %d", len(h.Bounds), len(h.Bounds)+1, len(h.Counts))}
+	}
+	for i, bound := range h.Bounds {
+		if math.IsNaN(bound) || math.IsInf(bound, 0) {
+			return invalidHistogramError{"histogram bounds should be finite"}
+		}
+		if i > 0 && h.Bounds[i-1] >= bound {
+			return invalidHistogramError{"histogram bounds should be strictly increasing"}
+		}
+	}
+	var total int64
+	for _, count := range h.Counts {
+		if count < 0 {
+			return invalidHistogramError{"histogram counts should not be negative"}
+		}
+		total += count
+	}
+	if total != h.Count {
+		return invalidHistogramError{fmt.Sprintf("histogram count %d does not match sum of bucket counts %d", h.Count, total)}
+	}
+	return nil
+}
+
+// SameBounds checks if two histograms have the same buckets layout
+func (h Histogram) SameBounds(other Histogram) bool {
+	if len(h.Bounds) != len(other.Bounds) {
+		return false
+	}
+	for i := range h.Bounds {
+		if h.Bounds[i] != other.Bounds[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge returns a new histogram, containing observations of both histograms.
+// Histograms should have the same bounds to be merged.
+func (h Histogram) Merge(other Histogram) (Histogram, error) {
+	if !h.SameBounds(other) || len(h.Counts) != len(other.Counts) {
+		return Histogram{}, invalidHistogramError{"could not merge histograms with different bounds"}
+	}
+	res := Histogram{
+		Bounds: append([]float64(nil), h.Bounds...),
+		Counts: make([]int64, len(h.Counts)),
+		Sum:    h.Sum + other.Sum,
+		Count:  h.Count + other.Count,
+	}
+	for i := range h.Counts {
+		res.Counts[i] = h.Counts[i] + other.Counts[i]
+	}
+	return res, nil
+}
+
+func formatFloats(values []float64) string {
+	formatted := make([]string, len(values))
+	for i, value := range values {
+		formatted[i] = strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return strings.Join(formatted, ",")
+}
+
+func formatInts(values []int64) string {
+	formatted := make([]string, len(values))
+	for i, value := range values {
+		formatted[i] = strconv.FormatInt(value, 10)
+	}
+	return strings.Join(formatted, ",")
+}
+
+func (h Histogram) String() string {
+	var sb strings.Builder
+	for i, count := range h.Counts {
+		bound := "+Inf"
+		if i < len(h.Bounds) {
+			bound = strconv.FormatFloat(h.Bounds[i], 'f', -1, 64)
+		}
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(fmt.Sprintf("%s:%d", bound, count))
+	}
+	return fmt.Sprintf("count=%d sum=%s buckets=[%s]", h.Count, strconv.FormatFloat(h.Sum, 'f', -1, 64), sb.String())
+}
@@ -0,0 +1,90 @@
+package schema
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram_Observe(t *testing.T) {
+	m := NewHistogram("hID", []float64{1, 5, 10})
+	for _, v := range []float64{0.5, 1, 3, 7, 100} {
+		m.Histogram.Observe(v)
+	}
+
+	assert.Equal(t, []int64{2, 1, 1, 1}, m.Histogram.Counts)
+	assert.Equal(t, int64(5), m.Histogram.Count)
+	assert.Equal(t, 111.5, m.Histogram.Sum)
+	assert.NoError(t, m.Histogram.Validate())
+}
+
+func TestHistogram_Validate(t *testing.T) {
+	params := []struct {
+		h     Histogram
+		valid bool
+	}{
+		{Histogram{Bounds: []float64{1, 2}, Counts: []int64{1, 0, 2}, Sum: 10, Count: 3}, true},
+		{Histogram{Bounds: []float64{}, Counts: []int64{0}}, true},
+		{Histogram{Bounds: []float64{1, 2}, Counts: []int64{1, 0}, Count: 1}, false},
+		{Histogram{Bounds: []float64{2, 1}, Counts: []int64{0, 0, 0}}, false},
+		{Histogram{Bounds: []float64{1, 1}, Counts: []int64{0, 0, 0}}, false},
+		{Histogram{Bounds: []float64{1}, Counts: []int64{-1, 1}}, false},
+		{Histogram{Bounds: []float64{1}, Counts: []int64{1, 1}, Count: 3}, false},
+		{Histogram{Bounds: []float64{1}, Counts: []int64{0, 1}, Sum: math.Inf(1), Count: 1}, false},
+	}
+
+	for _, param := range params {
+		err := param.h.Validate()
+		if param.valid {
+			assert.NoError(t, err)
+		} else {
+			assert.Error(t, err)
+		}
+	}
+}
+
+func TestHistogram_Merge(t *testing.T) {
+	a := Histogram{Bounds: []float64{1, 2}, Counts: []int64{1, 0, 2}, Sum: 10, Count: 3}
+	b := Histogram{Bounds: []float64{1, 2}, Counts: []int64{0, 1, 1}, Sum: 5, Count: 2}
+
+	merged, err := a.Merge(b)
+	assert.NoError(t, err)
+	assert.Equal(t, Histogram{Bounds: []float64{1, 2}, Counts: []int64{1, 1, 3}, Sum: 15, Count: 5}, merged)
+	// merge should not modify its operands
+	assert.Equal(t, []int64{1, 0, 2}, a.Counts)
+
+	_, err = a.Merge(Histogram{Bounds: []float64{1, 3}, Counts: []int64{0, 0, 0}})
+	assert.Error(t, err)
+}
+
+func TestHistogram_Serialize(t *testing.T) {
+	m := NewHistogram("hID", []float64{1, 2})
+	m.Histogram.Observe(1.5)
+
+	serialized, err := json.Marshal(m)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"id": "hID", "type": "histogram", "histogram": {"bounds": [1, 2], "counts": [0, 1, 0], "sum": 1.5, "count": 1}}`, string(serialized))
+}
+
+func TestHistogram_Sign(t *testing.T) {
+	key := "key test number 42"
+	m := NewHistogram("hID", []float64{1, 2})
+	m.Histogram.Observe(1.5)
+
+	err := m.Sign(key)
+	assert.NoError(t, err)
+	b, err := m.IsSignedWithKey(key)
+	assert.NoError(t, err)
+	assert.True(t, b)
+
+	m.Histogram.Observe(1.5)
+	b, err = m.IsSignedWithKey(key)
+	assert.NoError(t, err)
+	assert.False(t, b)
+
+	req := NewHistogramRequest("hID")
+	err = req.Sign(key)
+	assert.Error(t, err)
+}
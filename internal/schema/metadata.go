@@ -0,0 +1,32 @@
+package schema
+
+import "fmt"
+
+// Metadata describes metrics with the given ID regardless of its labels
+type Metadata struct {
+	ID    string      `json:"id"`
+	MType MetricsType `json:"type,omitempty"`
+	Unit  string      `json:"unit,omitempty"`
+	Help  string      `json:"help,omitempty"`
+}
+
+type invalidMetadataError struct {
+	reason string
+}
+
+func (e invalidMetadataError) Error() string {
+	return e.reason
+}
+
+// Validate checks that metadata refers to metrics and declares a known type (if any)
+func (m Metadata) Validate() error {
+	if m.ID == "" {
+		return invalidMetadataError{"metadata should have metrics id"}
+	}
+	switch m.MType {
+	case MetricsTypeEmpty, MetricsTypeCounter, MetricsTypeGauge, MetricsTypeHistogram, MetricsTypeSummary:
+		return nil
+	default:
+		return invalidMetadataError{fmt.Sprintf("unknown metrics type %s", m.MType)}
+	}
+}
@@ -3,31 +3,45 @@ package schema
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Metrics struct {
-	ID    string      `json:"id"`
-	MType MetricsType `json:"type"`
-	Delta *int64      `json:"delta,omitempty"`
-	Value *float64    `json:"value,omitempty"`
-	Hash  string      `json:"hash,omitempty"`
+	ID        string            `json:"id"`
+	MType     MetricsType       `json:"type"`
+	Delta     *int64            `json:"delta,omitempty"`
+	Value     *float64          `json:"value,omitempty"`
+	Hash      string            `json:"hash,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Histogram *Histogram        `json:"histogram,omitempty"`
+	Summary   *Summary          `json:"summary,omitempty"`
+	// SignedAt (unix milliseconds) and Nonce are included into signature,
+	// so the server could reject replayed updates
+	SignedAt int64  `json:"signed_at,omitempty"`
+	Nonce    string `json:"nonce,omitempty"`
 }
 
 type MetricsType string
 
 const (
-	MetricsTypeCounter MetricsType = "counter"
-	MetricsTypeGauge   MetricsType = "gauge"
-	MetricsTypeEmpty   MetricsType = ""
+	MetricsTypeCounter   MetricsType = "counter"
+	MetricsTypeGauge     MetricsType = "gauge"
+	MetricsTypeHistogram MetricsType = "histogram"
+	MetricsTypeSummary   MetricsType = "summary"
+	MetricsTypeEmpty     MetricsType = ""
 )
 
 func NewEmptyMetrics() Metrics {
-	return Metrics{"", MetricsTypeEmpty, nil, nil, ""}
+	return Metrics{"", MetricsTypeEmpty, nil, nil, "", nil, nil, nil, 0, ""}
 }
 
 func NewCounterRequest(id string) Metrics {
@@ -46,6 +60,54 @@ func NewGauge(id string, value float64) Metrics {
 	return Metrics{ID: id, MType: MetricsTypeGauge, Value: &value}
 }
 
+// WithLabels returns a copy of metrics with the given set of labels attached
+func (m Metrics) WithLabels(labels map[string]string) Metrics {
+	if len(labels) == 0 {
+		m.Labels = nil
+		return m
+	}
+	m.Labels = make(map[string]string, len(labels))
+	for k, v := range labels {
+		m.Labels[k] = v
+	}
+	return m
+}
+
+// LabelsKey returns canonical representation of metrics labels,
+// labels are sorted by name, so the same set of labels always
+// results in the same string. Empty string is returned if there
+// are no labels.
+func (m Metrics) LabelsKey() string {
+	if len(m.Labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(m.Labels))
+	for name := range m.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(name)
+		sb.WriteString("=")
+		sb.WriteString(strconv.Quote(m.Labels[name]))
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+// Key returns identity of the metrics, which consists of its ID
+// and sorted labels. For metrics without labels key is equal to ID.
+func (m Metrics) Key() string {
+	return m.ID + m.LabelsKey()
+}
+
 func (m Metrics) Explain() (string, string, string) {
 	value := "(nil)"
 	switch m.MType {
@@ -57,9 +119,17 @@ func (m Metrics) Explain() (string, string, string) {
 		if m.Value != nil {
 			value = strconv.FormatFloat(*m.Value, 'f', -1, 64)
 		}
+	case MetricsTypeHistogram:
+		if m.Histogram != nil {
+			value = m.Histogram.String()
+		}
+	case MetricsTypeSummary:
+		if m.Summary != nil {
+			value = m.Summary.String()
+		}
 	default:
 	}
-	return m.ID, string(m.MType), value
+	return m.Key(), string(m.MType), value
 }
 
 type hashingMetricsError struct {
@@ -80,16 +150,32 @@ func (m Metrics) hash(key string) (string, error) {
 		if m.Delta == nil {
 			return "", hashingMetricsError{"cannot sign metrics without value"}
 		}
-		data = fmt.Sprintf("%s:counter:%d", m.ID, *m.Delta)
+		data = fmt.Sprintf("%s:counter:%d", m.Key(), *m.Delta)
 	case MetricsTypeGauge:
 		if m.Value == nil {
 			return "", hashingMetricsError{"cannot sign metrics without value"}
 		}
-		data = fmt.Sprintf("%s:gauge:%f", m.ID, *m.Value)
+		data = fmt.Sprintf("%s:gauge:%f", m.Key(), *m.Value)
+	case MetricsTypeHistogram:
+		if m.Histogram == nil {
+			return "", hashingMetricsError{"cannot sign metrics without value"}
+		}
+		h := m.Histogram
+		data = fmt.Sprintf("%s:histogram:%s:%s:%f:%d", m.Key(), formatFloats(h.Bounds), formatInts(h.Counts), h.Sum, h.Count)
+	case MetricsTypeSummary:
+		if m.Summary == nil {
+			return "", hashingMetricsError{"cannot sign metrics without value"}
+		}
+		s := m.Summary
+		data = fmt.Sprintf("%s:summary:%f:%s:%s:%d:%s:%f:%d", m.Key(), s.Alpha, formatBins(s.Negative), formatBins(s.Positive), s.Zero, formatFloats(s.Samples), s.Sum, s.Count)
 	default:
 		return "", hashingMetricsError{fmt.Sprintf("unknown metrics type to sign: %s", m.MType)}
 	}
 
+	if m.SignedAt != 0 || m.Nonce != "" {
+		data = fmt.Sprintf("%s:%d:%s", data, m.SignedAt, m.Nonce)
+	}
+
 	h := hmac.New(sha256.New, []byte(key))
 	_, err := h.Write([]byte(data))
 	if err != nil {
@@ -99,6 +185,24 @@ func (m Metrics) hash(key string) (string, error) {
 	return hex.EncodeToString(sum), err
 }
 
+// Stamp sets signing time and random nonce, it should be called before Sign
+func (m *Metrics) Stamp(now time.Time) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	m.SignedAt = now.UnixMilli()
+	m.Nonce = hex.EncodeToString(nonce)
+	return nil
+}
+
+// Unstamped returns a copy of metrics without signing time and nonce, so it could be stored
+func (m Metrics) Unstamped() Metrics {
+	m.SignedAt = 0
+	m.Nonce = ""
+	return m
+}
+
 func (m *Metrics) Sign(key string) error {
 	h, err := m.hash(key)
 	if err != nil {
@@ -118,3 +222,50 @@ func (m Metrics) IsSignedWithKey(key string) (bool, error) {
 	}
 	return false, err
 }
+
+// BatchSignatureHeader carries signature of the whole batch, see SignBatch
+const BatchSignatureHeader = "HashSHA256"
+
+// canonicalBatch is the form of the batch covered by its signature,
+// so it does not depend on formatting and compression of the request body
+func canonicalBatch(l []Metrics) ([]byte, error) {
+	canonical := make([]Metrics, len(l))
+	for i, m := range l {
+		// per-metrics hashes are redundant if the batch is signed
+		m.Hash = ""
+		canonical[i] = m
+	}
+	return json.Marshal(canonical)
+}
+
+// SignBatch signs all the metrics at once, it is cheaper than signing every metrics separately
+func SignBatch(l []Metrics, key string) (string, error) {
+	if key == "" {
+		return "", hashingMetricsError{"can't sign metrics with empty key"}
+	}
+	data, err := canonicalBatch(l)
+	if err != nil {
+		return "", err
+	}
+	h := hmac.New(sha256.New, []byte(key))
+	_, err = h.Write(data)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// IsBatchSignedWithKey checks signature created by SignBatch
+func IsBatchSignedWithKey(l []Metrics, key string, signature string) (bool, error) {
+	expected, err := SignBatch(l, key)
+	if err != nil {
+		return false, err
+	}
+	return hmac.Equal([]byte(expected), []byte(signature)), nil
+}
+
+// Sample is a value of metrics at some moment of time
+type Sample struct {
+	Timestamp time.Time `json:"timestamp"`
+	Metrics
+}
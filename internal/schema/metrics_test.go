@@ -3,8 +3,10 @@ package schema
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMetrics_CreateAndSerialize(t *testing.T) {
@@ -32,7 +34,7 @@ func TestMetrics_Explain(t *testing.T) {
 	}{
 		{NewCounter("cntID", 42), [...]string{"cntID", "counter", "42"}},
 		{NewGauge("ggID", 13.37), [...]string{"ggID", "gauge", "13.37"}},
-		{Metrics{"ID", "type", nil, nil, ""}, [...]string{"ID", "type", "(nil)"}},
+		{Metrics{"ID", "type", nil, nil, "", nil, nil, nil, 0, ""}, [...]string{"ID", "type", "(nil)"}},
 	}
 
 	for _, param := range params {
@@ -54,7 +56,7 @@ func TestMetrics_Sign(t *testing.T) {
 		{NewCounterRequest("cntID"), true},
 		{NewGaugeRequest("ggID"), true},
 		{NewEmptyMetrics(), true},
-		{Metrics{"ID", "type", nil, nil, ""}, true},
+		{Metrics{"ID", "type", nil, nil, "", nil, nil, nil, 0, ""}, true},
 	}
 
 	for _, param := range params {
@@ -96,7 +98,7 @@ func TestMetrics_SignInvalidMetrics(t *testing.T) {
 		NewCounterRequest("cntID"),
 		NewGaugeRequest("ggID"),
 		NewEmptyMetrics(),
-		{"ID", "type", nil, nil, ""},
+		{"ID", "type", nil, nil, "", nil, nil, nil, 0, ""},
 	}
 
 	for _, m := range params {
@@ -104,3 +106,124 @@ func TestMetrics_SignInvalidMetrics(t *testing.T) {
 		assert.Error(t, err)
 	}
 }
+
+func TestMetrics_Key(t *testing.T) {
+	params := []struct {
+		m        Metrics
+		expected string
+	}{
+		{NewCounter("cntID", 42), "cntID"},
+		{NewGauge("ggID", 13.37).WithLabels(map[string]string{}), "ggID"},
+		{NewGauge("ggID", 13.37).WithLabels(map[string]string{"host": "a"}), `ggID{host="a"}`},
+		{NewGauge("ggID", 13.37).WithLabels(map[string]string{"host": "a", "cpu": "3"}), `ggID{cpu="3",host="a"}`},
+		{NewGauge("ggID", 13.37).WithLabels(map[string]string{"host": `"quoted"`}), `ggID{host="\"quoted\""}`},
+	}
+
+	for _, param := range params {
+		assert.Equal(t, param.expected, param.m.Key())
+	}
+}
+
+func TestMetrics_SignWithLabels(t *testing.T) {
+	key := "key test number 42"
+	m := NewGauge("ggID", 13.37).WithLabels(map[string]string{"host": "a"})
+	err := m.Sign(key)
+	assert.NoError(t, err)
+
+	b, err := m.IsSignedWithKey(key)
+	assert.NoError(t, err)
+	assert.True(t, b)
+
+	// the same signature should not be valid for another label set
+	other := m.WithLabels(map[string]string{"host": "b"})
+	b, err = other.IsSignedWithKey(key)
+	assert.NoError(t, err)
+	assert.False(t, b)
+
+	// nor for the metrics without labels
+	other = m.WithLabels(nil)
+	b, err = other.IsSignedWithKey(key)
+	assert.NoError(t, err)
+	assert.False(t, b)
+}
+
+func TestMetrics_SignStamped(t *testing.T) {
+	key := "key test number 42"
+	m := NewCounter("PollCount", 1)
+	legacy := m
+	assert.NoError(t, legacy.Sign(key))
+
+	assert.NoError(t, m.Stamp(time.UnixMilli(1665000000000)))
+	assert.Equal(t, int64(1665000000000), m.SignedAt)
+	assert.Len(t, m.Nonce, 32)
+	assert.NoError(t, m.Sign(key))
+	assert.NotEqual(t, legacy.Hash, m.Hash)
+
+	b, err := m.IsSignedWithKey(key)
+	assert.NoError(t, err)
+	assert.True(t, b)
+
+	// stamp could not be replaced without the key
+	other := m
+	assert.NoError(t, other.Stamp(time.UnixMilli(1665000001000)))
+	b, err = other.IsSignedWithKey(key)
+	assert.NoError(t, err)
+	assert.False(t, b)
+
+	unstamped := m.Unstamped()
+	assert.Zero(t, unstamped.SignedAt)
+	assert.Empty(t, unstamped.Nonce)
+}
+
+func TestSignBatch(t *testing.T) {
+	key := "key test number 42"
+	l := []Metrics{NewCounter("cntID", 42), NewGauge("ggID", 13.37)}
+	signature, err := SignBatch(l, key)
+	require.NoError(t, err)
+
+	signed, err := IsBatchSignedWithKey(l, key, signature)
+	require.NoError(t, err)
+	assert.True(t, signed)
+
+	// per-metrics hashes are not covered by batch signature
+	require.NoError(t, l[0].Sign(key))
+	signed, err = IsBatchSignedWithKey(l, key, signature)
+	require.NoError(t, err)
+	assert.True(t, signed)
+
+	signed, err = IsBatchSignedWithKey(l, "wrong key", signature)
+	require.NoError(t, err)
+	assert.False(t, signed)
+
+	signed, err = IsBatchSignedWithKey([]Metrics{NewCounter("cntID", 43), NewGauge("ggID", 13.37)}, key, signature)
+	require.NoError(t, err)
+	assert.False(t, signed, "changed value should invalidate signature")
+
+	signed, err = IsBatchSignedWithKey(l[:1], key, signature)
+	require.NoError(t, err)
+	assert.False(t, signed, "removed metrics should invalidate signature")
+
+	_, err = SignBatch(l, "")
+	assert.Error(t, err)
+}
+
+func TestMetadata_Validate(t *testing.T) {
+	params := []struct {
+		meta  Metadata
+		valid bool
+	}{
+		{Metadata{ID: "HeapAlloc", MType: MetricsTypeGauge, Unit: "bytes"}, true},
+		{Metadata{ID: "HeapAlloc", Help: "type is not declared"}, true},
+		{Metadata{MType: MetricsTypeGauge}, false},
+		{Metadata{ID: "HeapAlloc", MType: "statistics"}, false},
+	}
+
+	for _, param := range params {
+		err := param.meta.Validate()
+		if param.valid {
+			assert.NoError(t, err)
+		} else {
+			assert.Error(t, err)
+		}
+	}
+}
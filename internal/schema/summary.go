@@ -0,0 +1,242 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultSummaryAccuracy is relative accuracy of summaries created from a single observation,
+// e.g. when it is submitted via plain-text API.
+const DefaultSummaryAccuracy = 0.01
+
+// DefaultSummaryQuantiles are reported by server for every summary.
+var DefaultSummaryQuantiles = []float64{0.5, 0.9, 0.99}
+
+// Summary holds quantile sketch of observed values.
+//
+// Sketch is built the same way as DDSketch does: values are mapped to
+// logarithmically sized bins, so any quantile is estimated with relative
+// error not greater than Alpha. Sketches with the same Alpha could be merged
+// without any loss of accuracy.
+//
+// Agents may either submit already built sketch or raw Samples,
+// which are folded into the sketch by the server. Quantiles are never
+// accepted from agents and are filled by the server in responses only.
+type Summary struct {
+	Positive  map[int]int64      `json:"positive,omitempty"`
+	Negative  map[int]int64      `json:"negative,omitempty"`
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+	Samples   []float64          `json:"samples,omitempty"`
+	Alpha     float64            `json:"alpha"`
+	Sum       float64            `json:"sum"`
+	Count     int64              `json:"count"`
+	Zero      int64              `json:"zero,omitempty"`
+}
+
+type invalidSummaryError struct {
+	reason string
+}
+
+func (e invalidSummaryError) Error() string {
+	return e.reason
+}
+
+func NewSummaryRequest(id string) Metrics {
+	return Metrics{ID: id, MType: MetricsTypeSummary}
+}
+
+// NewSummary creates summary metrics without any observations
+func NewSummary(id string, alpha float64) Metrics {
+	s := &Summary{
+		Positive: map[int]int64{},
+		Negative: map[int]int64{},
+		Alpha:    alpha,
+	}
+	return Metrics{ID: id, MType: MetricsTypeSummary, Summary: s}
+}
+
+func (s Summary) gamma() float64 {
+	return (1 + s.Alpha) / (1 - s.Alpha)
+}
+
+func (s Summary) index(value float64) int {
+	return int(math.Ceil(math.Log(value) / math.Log(s.gamma())))
+}
+
+func (s Summary) value(index int) float64 {
+	gamma := s.gamma()
+	return 2 * math.Pow(gamma, float64(index)) / (gamma + 1)
+}
+
+// Observe adds single value to the sketch
+func (s *Summary) Observe(value float64) {
+	switch {
+	case value > 0:
+		if s.Positive == nil {
+			s.Positive = map[int]int64{}
+		}
+		s.Positive[s.index(value)]++
+	case value < 0:
+		if s.Negative == nil {
+			s.Negative = map[int]int64{}
+		}
+		s.Negative[s.index(-value)]++
+	default:
+		s.Zero++
+	}
+	s.Count++
+	s.Sum += value
+}
+
+// Normalize folds raw samples into the sketch
+func (s *Summary) Normalize() {
+	for _, sample := range s.Samples {
+		s.Observe(sample)
+	}
+	s.Samples = nil
+}
+
+// Validate checks that sketch is consistent
+func (s Summary) Validate() error {
+	if !(s.Alpha > 0 && s.Alpha < 1) {
+		return invalidSummaryError{"summary accuracy should be within (0, 1) interval"}
+	}
+	if math.IsNaN(s.Sum) || math.IsInf(s.Sum, 0) {
+		return invalidSummaryError{"summary sum should be finite"}
+	}
+	for _, sample := range s.Samples {
+		if math.IsNaN(sample) || math.IsInf(sample, 0) {
+			return invalidSummaryError{"summary samples should be finite"}
+		}
+	}
+	total := s.Zero
+	if s.Zero < 0 {
+		return invalidSummaryError{"summary counts should not be negative"}
+	}
+	for _, bins := range [...]map[int]int64{s.Positive, s.Negative} {
+		for _, count := range bins {
+			if count < 0 {
+				return invalidSummaryError{"summary counts should not be negative"}
+			}
+			total += count
+		}
+	}
+	if total != s.Count {
+		return invalidSummaryError{fmt.Sprintf("summary count %d does not match sum of bin counts %d", s.Count, total)}
+	}
+	return nil
+}
+
+func mergeBins(a map[int]int64, b map[int]int64) map[int]int64 {
+	res := make(map[int]int64, len(a))
+	for index, count := range a {
+		res[index] += count
+	}
+	for index, count := range b {
+		res[index] += count
+	}
+	return res
+}
+
+// Merge returns a new summary, containing observations of both summaries.
+// Summaries should have the same accuracy to be merged.
+func (s Summary) Merge(other Summary) (Summary, error) {
+	if s.Alpha != other.Alpha {
+		return Summary{}, invalidSummaryError{"could not merge summaries with different accuracy"}
+	}
+	res := Summary{
+		Positive: mergeBins(s.Positive, other.Positive),
+		Negative: mergeBins(s.Negative, other.Negative),
+		Samples:  append(append([]float64(nil), s.Samples...), other.Samples...),
+		Alpha:    s.Alpha,
+		Sum:      s.Sum + other.Sum,
+		Count:    s.Count + other.Count,
+		Zero:     s.Zero + other.Zero,
+	}
+	res.Normalize()
+	return res, nil
+}
+
+func sortedIndexes(bins map[int]int64) []int {
+	indexes := make([]int, 0, len(bins))
+	for index := range bins {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// Quantile estimates q-th quantile of observed values,
+// NaN is returned if there were no observations
+func (s Summary) Quantile(q float64) float64 {
+	if s.Count == 0 {
+		return math.NaN()
+	}
+	rank := q * float64(s.Count-1)
+	var cumulative int64
+
+	// negative values are traversed from the largest magnitude
+	negative := sortedIndexes(s.Negative)
+	for i := len(negative) - 1; i >= 0; i-- {
+		cumulative += s.Negative[negative[i]]
+		if float64(cumulative) > rank {
+			return -s.value(negative[i])
+		}
+	}
+
+	cumulative += s.Zero
+	if float64(cumulative) > rank {
+		return 0
+	}
+
+	positive := sortedIndexes(s.Positive)
+	for _, index := range positive {
+		cumulative += s.Positive[index]
+		if float64(cumulative) > rank {
+			return s.value(index)
+		}
+	}
+
+	// unreachable for consistent sketch, but rounding errors are possible
+	if len(positive) > 0 {
+		return s.value(positive[len(positive)-1])
+	}
+	return 0
+}
+
+// WithQuantiles returns a copy of summary with requested quantiles estimated
+func (s Summary) WithQuantiles(quantiles ...float64) Summary {
+	s.Quantiles = nil
+	if s.Count == 0 {
+		return s
+	}
+	s.Quantiles = make(map[string]float64, len(quantiles))
+	for _, q := range quantiles {
+		s.Quantiles[strconv.FormatFloat(q, 'f', -1, 64)] = s.Quantile(q)
+	}
+	return s
+}
+
+func formatBins(bins map[int]int64) string {
+	indexes := sortedIndexes(bins)
+	formatted := make([]string, len(indexes))
+	for i, index := range indexes {
+		formatted[i] = fmt.Sprintf("%d=%d", index, bins[index])
+	}
+	return strings.Join(formatted, ",")
+}
+
+func (s Summary) String() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("count=%d sum=%s", s.Count, strconv.FormatFloat(s.Sum, 'f', -1, 64)))
+	if s.Count == 0 {
+		return sb.String()
+	}
+	for _, q := range DefaultSummaryQuantiles {
+		sb.WriteString(fmt.Sprintf(" p%s=%s", strconv.FormatFloat(q*100, 'f', -1, 64), strconv.FormatFloat(s.Quantile(q), 'f', -1, 64)))
+	}
+	return sb.String()
+}
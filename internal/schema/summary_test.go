@@ -0,0 +1,111 @@
+package schema
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummary_Quantile(t *testing.T) {
+	m := NewSummary("sID", DefaultSummaryAccuracy)
+	for i := 1; i <= 1000; i++ {
+		m.Summary.Observe(float64(i))
+	}
+
+	assert.Equal(t, int64(1000), m.Summary.Count)
+	assert.Equal(t, 500500.0, m.Summary.Sum)
+	assert.NoError(t, m.Summary.Validate())
+
+	for _, q := range []float64{0.01, 0.5, 0.9, 0.99} {
+		expected := q * 999
+		actual := m.Summary.Quantile(q)
+		assert.InDelta(t, expected, actual, expected*DefaultSummaryAccuracy+1)
+	}
+}
+
+func TestSummary_QuantileNegativeAndZero(t *testing.T) {
+	m := NewSummary("sID", DefaultSummaryAccuracy)
+	for _, v := range []float64{-100, -10, 0, 0, 10} {
+		m.Summary.Observe(v)
+	}
+
+	assert.InDelta(t, -100, m.Summary.Quantile(0), 100*DefaultSummaryAccuracy)
+	assert.InDelta(t, -10, m.Summary.Quantile(0.25), 10*DefaultSummaryAccuracy)
+	assert.Equal(t, 0.0, m.Summary.Quantile(0.5))
+	assert.InDelta(t, 10, m.Summary.Quantile(1), 10*DefaultSummaryAccuracy)
+}
+
+func TestSummary_QuantileEmpty(t *testing.T) {
+	m := NewSummary("sID", DefaultSummaryAccuracy)
+	assert.True(t, math.IsNaN(m.Summary.Quantile(0.5)))
+	assert.Nil(t, m.Summary.WithQuantiles(0.5).Quantiles)
+}
+
+func TestSummary_Merge(t *testing.T) {
+	a := NewSummary("sID", DefaultSummaryAccuracy)
+	b := NewSummary("sID", DefaultSummaryAccuracy)
+	whole := NewSummary("sID", DefaultSummaryAccuracy)
+	for i := 1; i <= 100; i++ {
+		if i%2 == 0 {
+			a.Summary.Observe(float64(i))
+		} else {
+			// raw samples should be folded into the sketch on merge
+			b.Summary.Samples = append(b.Summary.Samples, float64(i))
+		}
+		whole.Summary.Observe(float64(i))
+	}
+
+	merged, err := a.Summary.Merge(*b.Summary)
+	assert.NoError(t, err)
+	assert.Equal(t, *whole.Summary, merged)
+	// merge should not modify its operands
+	assert.Equal(t, int64(50), a.Summary.Count)
+	assert.Len(t, b.Summary.Samples, 50)
+
+	_, err = a.Summary.Merge(Summary{Alpha: 0.05})
+	assert.Error(t, err)
+}
+
+func TestSummary_Validate(t *testing.T) {
+	params := []struct {
+		s     Summary
+		valid bool
+	}{
+		{Summary{Alpha: 0.01}, true},
+		{Summary{Alpha: 0.01, Positive: map[int]int64{1: 2}, Zero: 1, Count: 3}, true},
+		{Summary{Alpha: 0.01, Samples: []float64{1, 2, 3}}, true},
+		{Summary{Alpha: 0}, false},
+		{Summary{Alpha: 1}, false},
+		{Summary{Alpha: 0.01, Positive: map[int]int64{1: -1}, Count: -1}, false},
+		{Summary{Alpha: 0.01, Positive: map[int]int64{1: 2}, Count: 3}, false},
+		{Summary{Alpha: 0.01, Samples: []float64{math.Inf(1)}}, false},
+		{Summary{Alpha: 0.01, Zero: 1, Sum: math.NaN(), Count: 1}, false},
+	}
+
+	for _, param := range params {
+		err := param.s.Validate()
+		if param.valid {
+			assert.NoError(t, err)
+		} else {
+			assert.Error(t, err)
+		}
+	}
+}
+
+func TestSummary_Sign(t *testing.T) {
+	key := "key test number 42"
+	m := NewSummary("sID", DefaultSummaryAccuracy)
+	m.Summary.Observe(1.5)
+
+	err := m.Sign(key)
+	assert.NoError(t, err)
+	b, err := m.IsSignedWithKey(key)
+	assert.NoError(t, err)
+	assert.True(t, b)
+
+	m.Summary.Samples = []float64{42}
+	b, err = m.IsSignedWithKey(key)
+	assert.NoError(t, err)
+	assert.False(t, b)
+}
@@ -0,0 +1,75 @@
+package schema
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+type TokenScope string
+
+const (
+	// TokenScopeRead allows to read metrics and metadata
+	TokenScopeRead TokenScope = "read"
+	// TokenScopeWrite allows to submit metrics and metadata with ID starting with token prefix
+	TokenScopeWrite TokenScope = "write"
+	// TokenScopeAdmin allows everything including management of tokens
+	TokenScopeAdmin TokenScope = "admin"
+)
+
+// Token is API credential of the agent or other client,
+// only hash of the secret is stored, secret itself is shown once on creation
+type Token struct {
+	Name   string     `json:"name"`
+	Scope  TokenScope `json:"scope"`
+	Prefix string     `json:"prefix,omitempty"`
+	Secret string     `json:"secret,omitempty"`
+	Hash   string     `json:"-"`
+}
+
+type invalidTokenError struct {
+	reason string
+}
+
+func (e invalidTokenError) Error() string {
+	return e.reason
+}
+
+// HashTokenSecret returns representation of the secret, which is stored and used for lookup
+func HashTokenSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Validate checks that token has name and a known scope
+func (t Token) Validate() error {
+	if t.Name == "" {
+		return invalidTokenError{"token should have name"}
+	}
+	switch t.Scope {
+	case TokenScopeRead, TokenScopeAdmin:
+		if t.Prefix != "" {
+			return invalidTokenError{fmt.Sprintf("prefix is not applicable to %s scope", t.Scope)}
+		}
+		return nil
+	case TokenScopeWrite:
+		return nil
+	default:
+		return invalidTokenError{fmt.Sprintf("unknown token scope %s", t.Scope)}
+	}
+}
+
+// Allows checks that token grants the scope, id is checked against prefix for write scope
+// (empty id means that operation is not related to specific metrics)
+func (t Token) Allows(scope TokenScope, id string) bool {
+	switch t.Scope {
+	case TokenScopeAdmin:
+		return true
+	case TokenScopeWrite:
+		return scope == TokenScopeWrite && (id == "" || strings.HasPrefix(id, t.Prefix))
+	case TokenScopeRead:
+		return scope == TokenScopeRead
+	}
+	return false
+}
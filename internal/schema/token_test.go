@@ -0,0 +1,33 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToken(t *testing.T) {
+	writer := Token{Name: "agent-1", Scope: TokenScopeWrite, Prefix: "agent1."}
+	assert.NoError(t, writer.Validate())
+	assert.True(t, writer.Allows(TokenScopeWrite, "agent1.Alloc"))
+	assert.True(t, writer.Allows(TokenScopeWrite, ""))
+	assert.False(t, writer.Allows(TokenScopeWrite, "agent2.Alloc"))
+	assert.False(t, writer.Allows(TokenScopeRead, "agent1.Alloc"))
+
+	reader := Token{Name: "dashboard", Scope: TokenScopeRead}
+	assert.NoError(t, reader.Validate())
+	assert.True(t, reader.Allows(TokenScopeRead, "agent1.Alloc"))
+	assert.False(t, reader.Allows(TokenScopeWrite, "agent1.Alloc"))
+	assert.False(t, reader.Allows(TokenScopeAdmin, ""))
+
+	admin := Token{Name: "root", Scope: TokenScopeAdmin}
+	assert.True(t, admin.Allows(TokenScopeAdmin, ""))
+	assert.True(t, admin.Allows(TokenScopeWrite, "anything"))
+
+	assert.Error(t, Token{Scope: TokenScopeRead}.Validate())
+	assert.Error(t, Token{Name: "x", Scope: "superuser"}.Validate())
+	assert.Error(t, Token{Name: "x", Scope: TokenScopeRead, Prefix: "a"}.Validate())
+
+	assert.Equal(t, HashTokenSecret("secret"), HashTokenSecret("secret"))
+	assert.NotEqual(t, HashTokenSecret("secret"), HashTokenSecret("secret2"))
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"logogger/internal/schema"
+	"logogger/internal/storage"
+)
+
+type tokenKey struct{}
+
+// adminTokenName is the name of the token, which is configured on the server and not stored
+const adminTokenName = "admin"
+
+// authenticate resolves token by Authorization header value and checks that it grants the scope,
+// token is stored in the returned context for further checks of metrics IDs
+func (app *App) authenticate(ctx context.Context, authorization string, scope schema.TokenScope) (context.Context, error) {
+	if app.adminTokenHash == "" {
+		if scope == schema.TokenScopeAdmin {
+			// tokens could not be managed, while nobody is able to authenticate as admin
+			return ctx, &requestError{status: http.StatusForbidden, body: "API tokens are disabled"}
+		}
+		// tokens are not required
+		return ctx, nil
+	}
+
+	secret := strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	if secret == "" || secret == authorization {
+		return ctx, &requestError{status: http.StatusUnauthorized, body: "Missing API token"}
+	}
+
+	hash := schema.HashTokenSecret(secret)
+	var token schema.Token
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(app.adminTokenHash)) == 1 {
+		token = schema.Token{Name: adminTokenName, Scope: schema.TokenScopeAdmin}
+	} else {
+		var err error
+		token, err = app.store.ExtractToken(ctx, hash)
+		switch err.(type) {
+		case nil:
+		case *storage.TokenNotFound:
+			return ctx, &requestError{status: http.StatusUnauthorized, body: "Invalid API token"}
+		default:
+			return ctx, err
+		}
+	}
+
+	if !token.Allows(scope, "") {
+		return ctx, &requestError{
+			status: http.StatusForbidden,
+			body:   fmt.Sprintf("Token %s does not grant %s access", token.Name, scope),
+		}
+	}
+	return context.WithValue(ctx, tokenKey{}, token), nil
+}
+
+// authorizeWrite checks that token of the request allows to write metrics with the ID
+func (app *App) authorizeWrite(ctx context.Context, id string) error {
+	token, ok := ctx.Value(tokenKey{}).(schema.Token)
+	if !ok || token.Allows(schema.TokenScopeWrite, id) {
+		return nil
+	}
+	return &requestError{
+		status: http.StatusForbidden,
+		body:   fmt.Sprintf("Token %s does not grant write access to %s", token.Name, id),
+	}
+}
+
+// grpcScope returns scope, which is required by gRPC method
+func grpcScope(fullMethod string) schema.TokenScope {
+	switch fullMethod[strings.LastIndex(fullMethod, "/")+1:] {
+	case "Update", "UpdateBatch":
+		return schema.TokenScopeWrite
+	}
+	return schema.TokenScopeRead
+}
+
+func grpcAuthorization(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (app *App) unaryAuthenticate(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := app.authenticate(ctx, grpcAuthorization(ctx), grpcScope(info.FullMethod))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return handler(ctx, req)
+}
+
+// authenticatedStream passes context with token to the handler
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (app *App) streamAuthenticate(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := app.authenticate(ss.Context(), grpcAuthorization(ss.Context()), grpcScope(info.FullMethod))
+	if err != nil {
+		return grpcError(err)
+	}
+	return handler(srv, authenticatedStream{ss, ctx})
+}
+
+func (app *App) createTokenJSON(w http.ResponseWriter, r *http.Request) error {
+	if r.Body == nil {
+		return ValidationError("empty body")
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var token schema.Token
+	err = decoder.Decode(&token)
+	if err != nil {
+		return ValidationError(err.Error())
+	}
+	if token.Secret != "" {
+		return ValidationError("Secret is generated by server and should not be submitted")
+	}
+	if token.Name == adminTokenName {
+		return ValidationError(fmt.Sprintf("Token name %s is reserved", adminTokenName))
+	}
+	if err = token.Validate(); err != nil {
+		return ValidationError(err.Error())
+	}
+
+	secret := make([]byte, 32)
+	if _, err = rand.Read(secret); err != nil {
+		return err
+	}
+	token.Hash = schema.HashTokenSecret(hex.EncodeToString(secret))
+
+	// token with the same name is replaced, so secret could be rotated
+	err = app.store.PutToken(r.Context(), token)
+	if err != nil {
+		return err
+	}
+	token.Secret = hex.EncodeToString(secret)
+
+	serialized, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	SafeWrite(w, http.StatusOK, string(serialized))
+	return nil
+}
+
+func (app *App) listTokensJSON(w http.ResponseWriter, r *http.Request) error {
+	l, err := app.store.ListTokens(r.Context())
+	if err != nil {
+		return err
+	}
+	if l == nil {
+		l = []schema.Token{}
+	}
+
+	serialized, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	SafeWrite(w, http.StatusOK, string(serialized))
+	return nil
+}
+
+func (app *App) deleteTokenJSON(w http.ResponseWriter, r *http.Request) error {
+	err := app.store.DeleteToken(r.Context(), chi.URLParam(r, "Name"))
+	if err != nil {
+		return err
+	}
+	SafeWrite(w, http.StatusOK, `{"status": "OK"}`)
+	return nil
+}
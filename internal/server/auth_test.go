@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"logogger/internal/proto"
+	"logogger/internal/schema"
+	"logogger/internal/storage"
+)
+
+func request(t *testing.T, app *App, method, url, token, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	app.Router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func createToken(t *testing.T, app *App, body string) schema.Token {
+	recorder := request(t, app, http.MethodPost, "/admin/tokens/", "root", body)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	var token schema.Token
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &token))
+	require.NotEmpty(t, token.Secret)
+	return token
+}
+
+func TestApp_Tokens(t *testing.T) {
+	app := NewApp(storage.NewMemStorage()).WithAdminToken("root")
+	writer := createToken(t, app, `{"name":"agent-1","scope":"write","prefix":"agent1."}`)
+	reader := createToken(t, app, `{"name":"dashboard","scope":"read"}`)
+
+	params := []struct {
+		method string
+		url    string
+		token  string
+		body   string
+		code   int
+	}{
+		{http.MethodPost, "/update/", "", `{"id":"agent1.Alloc","type":"gauge","value":1}`, http.StatusUnauthorized},
+		{http.MethodPost, "/update/", "garbage", `{"id":"agent1.Alloc","type":"gauge","value":1}`, http.StatusUnauthorized},
+		{http.MethodPost, "/update/", writer.Secret, `{"id":"agent1.Alloc","type":"gauge","value":1}`, http.StatusOK},
+		{http.MethodPost, "/update/", writer.Secret, `{"id":"agent2.Alloc","type":"gauge","value":1}`, http.StatusForbidden},
+		{http.MethodPost, "/update/gauge/agent2.Alloc/1", writer.Secret, "", http.StatusForbidden},
+		{http.MethodPost, "/updates/", writer.Secret, `[{"id":"agent1.Alloc","type":"gauge","value":1},{"id":"agent2.Alloc","type":"gauge","value":1}]`, http.StatusForbidden},
+		{http.MethodPost, "/metadata/", writer.Secret, `[{"id":"agent2.Alloc","type":"gauge"}]`, http.StatusForbidden},
+		{http.MethodPost, "/value/", writer.Secret, `{"id":"agent1.Alloc","type":"gauge"}`, http.StatusForbidden},
+		{http.MethodPost, "/value/", reader.Secret, `{"id":"agent1.Alloc","type":"gauge"}`, http.StatusOK},
+		{http.MethodPost, "/update/", reader.Secret, `{"id":"agent1.Alloc","type":"gauge","value":1}`, http.StatusForbidden},
+		{http.MethodGet, "/admin/tokens/", reader.Secret, "", http.StatusForbidden},
+		{http.MethodPost, "/admin/tokens/", "root", `{"name":"admin","scope":"read"}`, http.StatusBadRequest},
+		{http.MethodPost, "/admin/tokens/", "root", `{"name":"x","scope":"read","secret":"mine"}`, http.StatusBadRequest},
+		{http.MethodPost, "/admin/tokens/", "root", `{"name":"x","scope":"superuser"}`, http.StatusBadRequest},
+		{http.MethodDelete, "/admin/tokens/unknown", "root", "", http.StatusNotFound},
+	}
+	for _, param := range params {
+		recorder := request(t, app, param.method, param.url, param.token, param.body)
+		assert.Equal(t, param.code, recorder.Code, "%s %s: %s", param.method, param.url, recorder.Body.String())
+	}
+
+	// secrets are not listed
+	recorder := request(t, app, http.MethodGet, "/admin/tokens/", "root", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `[{"name":"agent-1","scope":"write","prefix":"agent1."},{"name":"dashboard","scope":"read"}]`, recorder.Body.String())
+
+	// deleted token is not accepted
+	recorder = request(t, app, http.MethodDelete, "/admin/tokens/dashboard", "root", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	recorder = request(t, app, http.MethodPost, "/value/", reader.Secret, `{"id":"agent1.Alloc","type":"gauge"}`)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func TestGRPC_Tokens(t *testing.T) {
+	app := NewApp(storage.NewMemStorage()).WithAdminToken("root")
+	writer := createToken(t, app, `{"name":"agent-1","scope":"write","prefix":"agent1."}`)
+	client := newGRPCClient(t, app)
+
+	_, err := client.Update(context.Background(), proto.FromSchema(schema.NewCounter("agent1.PollCount", 1)))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+writer.Secret)
+	_, err = client.Update(ctx, proto.FromSchema(schema.NewCounter("agent1.PollCount", 1)))
+	assert.NoError(t, err)
+	_, err = client.Update(ctx, proto.FromSchema(schema.NewCounter("agent2.PollCount", 1)))
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	stream, err := client.UpdateBatch(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(proto.FromSchema(schema.NewCounter("agent2.PollCount", 1))))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.List(ctx, &proto.ListRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestApp_TokensDisabled(t *testing.T) {
+	app := NewApp(storage.NewMemStorage())
+
+	recorder := request(t, app, http.MethodPost, "/admin/tokens/", "", `{"name":"dashboard","scope":"read"}`)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	recorder = request(t, app, http.MethodGet, "/admin/tokens/", "", "")
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	recorder = request(t, app, http.MethodDelete, "/admin/tokens/dashboard", "", "")
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+	// other endpoints are open
+	recorder = request(t, app, http.MethodPost, "/update/counter/PollCount/1", "", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
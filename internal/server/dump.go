@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"logogger/internal/storage"
+)
+
+// DumpStats describes dumps of the storage state
+type DumpStats struct {
+	Requested int64 `json:"requested"`
+	// Coalesced requests were merged into the pending dump
+	Coalesced     int64     `json:"coalesced"`
+	Completed     int64     `json:"completed"`
+	Failed        int64     `json:"failed"`
+	LastLatencyMs float64   `json:"last_latency_ms"`
+	MaxLatencyMs  float64   `json:"max_latency_ms"`
+	LastSuccess   time.Time `json:"last_success,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+// asyncDumper performs dumps in background: at most one dump is in flight and one is pending.
+// Values are listed right before the dump starts, so requests made while the dump
+// is pending are coalesced into it without losing updates
+type asyncDumper struct {
+	dump    func() error
+	pending chan struct{}
+	done    chan struct{}
+	stats   DumpStats
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	// closed makes close idempotent, the result of the final dump is returned every time
+	closed   sync.Once
+	closeErr error
+}
+
+func newAsyncDumper(dump func() error) *asyncDumper {
+	d := &asyncDumper{
+		dump:    dump,
+		pending: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	d.wg.Add(1)
+	go d.run()
+	return d
+}
+
+// request schedules the dump, it does not block
+func (d *asyncDumper) request() {
+	select {
+	case d.pending <- struct{}{}:
+		d.count(false)
+	default:
+		d.count(true)
+	}
+}
+
+func (d *asyncDumper) count(coalesced bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stats.Requested++
+	if coalesced {
+		d.stats.Coalesced++
+	}
+}
+
+func (d *asyncDumper) run() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.pending:
+			log.Print("Dumping current storage state...")
+			if err := d.perform(); err != nil {
+				log.Printf("Could not dump storage state: %s", err.Error())
+			}
+		case <-d.done:
+			return
+		}
+	}
+}
+
+func (d *asyncDumper) perform() error {
+	start := time.Now()
+	err := d.dump()
+	latency := float64(time.Since(start).Microseconds()) / 1000
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stats.LastLatencyMs = latency
+	if latency > d.stats.MaxLatencyMs {
+		d.stats.MaxLatencyMs = latency
+	}
+	if err != nil {
+		d.stats.Failed++
+		d.stats.LastError = err.Error()
+		return err
+	}
+	d.stats.Completed++
+	d.stats.LastSuccess = start
+	d.stats.LastError = ""
+	return nil
+}
+
+func (d *asyncDumper) snapshot() DumpStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stats
+}
+
+// close stops the background dumps and flushes the final state,
+// so updates, whose dumps were coalesced or still pending, are not lost
+func (d *asyncDumper) close() error {
+	d.closed.Do(func() {
+		close(d.done)
+		d.wg.Wait()
+		d.closeErr = d.perform()
+	})
+	return d.closeErr
+}
+
+// dump writes the storage state with the dumper, write-ahead log (if any) is compacted afterwards
+func (app *App) dump(ctx context.Context) error {
+	// dumps are serialized, otherwise the log could be compacted by the later dump,
+	// while the earlier one overwrites it with the older state
+	app.dumpMu.Lock()
+	defer app.dumpMu.Unlock()
+
+	compact := func() error { return nil }
+	if c, ok := app.store.(storage.Checkpointer); ok {
+		var err error
+		compact, err = c.Checkpoint()
+		if err != nil {
+			return err
+		}
+	}
+
+	l, err := app.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	err = app.dumper.Dump(l)
+	if err != nil {
+		return err
+	}
+	return compact()
+}
+
+// requestDump schedules the dump in synchronous mode (the state is dumped after every update)
+func (app *App) requestDump() {
+	if app.sync {
+		app.dumps.request()
+	}
+}
+
+func (app *App) dumpStatsJSON(w http.ResponseWriter, _ *http.Request) error {
+	var stats DumpStats
+	if app.dumps != nil {
+		stats = app.dumps.snapshot()
+	}
+	serialized, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	SafeWrite(w, http.StatusOK, "%s", serialized)
+	return nil
+}
+
+// Close flushes the final storage state, it should be called after the server is stopped
+func (app *App) Close() error {
+	if app.dumps == nil {
+		return nil
+	}
+	log.Print("Dumping final storage state...")
+	return app.dumps.close()
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"logogger/internal/schema"
+	"logogger/internal/storage"
+)
+
+func TestAsyncDumper_Coalescing(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	var dumps int64
+	d := newAsyncDumper(func() error {
+		if atomic.AddInt64(&dumps, 1) == 1 {
+			close(started)
+			<-unblock
+		}
+		return nil
+	})
+
+	d.request()
+	<-started
+	// the first dump is in flight, so the rest are merged into a single pending one
+	for i := 0; i < 100; i++ {
+		d.request()
+	}
+	close(unblock)
+	require.NoError(t, d.close())
+
+	stats := d.snapshot()
+	assert.Equal(t, int64(101), stats.Requested)
+	assert.Equal(t, int64(99), stats.Coalesced)
+	// the pending dump is either performed in background or replaced by the final one
+	assert.LessOrEqual(t, atomic.LoadInt64(&dumps), int64(3))
+	assert.Equal(t, atomic.LoadInt64(&dumps), stats.Completed)
+	assert.Zero(t, stats.Failed)
+}
+
+func TestAsyncDumper_Failure(t *testing.T) {
+	d := newAsyncDumper(func() error {
+		return errors.New("disk is full")
+	})
+	assert.Error(t, d.close())
+	assert.Error(t, d.close(), "repeated close should return result of the final dump")
+
+	stats := d.snapshot()
+	assert.Equal(t, int64(1), stats.Failed)
+	assert.Equal(t, "disk is full", stats.LastError)
+	assert.True(t, stats.LastSuccess.IsZero())
+}
+
+func TestApp_SyncDump(t *testing.T) {
+	d := &recordingDumper{}
+	app := NewApp(storage.NewMemStorage()).WithDumper(d).WithDumpInterval(0)
+
+	for i := 0; i < 10; i++ {
+		recorder := request(t, app, http.MethodPost, "/update/counter/PollCount/1", "", "")
+		require.Equal(t, http.StatusOK, recorder.Code)
+	}
+	require.NoError(t, app.Close())
+	assert.Equal(t, []schema.Metrics{schema.NewCounter("PollCount", 10)}, d.dumped, "final state should be flushed on close")
+
+	recorder := request(t, app, http.MethodGet, "/stats/dump", "", "")
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var stats DumpStats
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &stats))
+	assert.Equal(t, int64(10), stats.Requested)
+	// pending dump left by the worker is replaced by the final one
+	assert.LessOrEqual(t, stats.Completed, stats.Requested-stats.Coalesced+1)
+	assert.Zero(t, stats.Failed)
+}
+
+// blockingDumper holds the first dump until it is released
+type blockingDumper struct {
+	started chan struct{}
+	release chan struct{}
+	dumps   int
+	dumped  []schema.Metrics
+}
+
+func (d *blockingDumper) Dump(l []schema.Metrics) error {
+	d.dumps++
+	if d.dumps == 1 {
+		close(d.started)
+		<-d.release
+	}
+	d.dumped = l
+	return nil
+}
+
+func (d *blockingDumper) Close() error {
+	return nil
+}
+
+func TestApp_SyncDumpInFlight(t *testing.T) {
+	d := &blockingDumper{started: make(chan struct{}), release: make(chan struct{})}
+	app := NewApp(storage.NewMemStorage()).WithDumper(d).WithDumpInterval(0)
+
+	recorder := request(t, app, http.MethodPost, "/update/counter/PollCount/1", "", "")
+	require.Equal(t, http.StatusOK, recorder.Code)
+	<-d.started
+
+	// the first dump is in flight, it has already listed the older state
+	recorder = request(t, app, http.MethodPost, "/update/counter/PollCount/1", "", "")
+	require.Equal(t, http.StatusOK, recorder.Code)
+	close(d.release)
+
+	require.NoError(t, app.Close())
+	assert.Equal(t, []schema.Metrics{schema.NewCounter("PollCount", 2)}, d.dumped)
+	assert.NoError(t, app.Close(), "repeated close should not panic")
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"logogger/internal/proto"
+	"logogger/internal/schema"
+)
+
+// metricsService implements the same operations as JSON API over gRPC,
+// payload encryption is not applied, as gRPC relies on transport security
+type metricsService struct {
+	proto.UnimplementedMetricsServiceServer
+	app *App
+}
+
+// grpcError converts error into gRPC status with the same message, which is sent over HTTP
+func grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	log.Printf("ERROR: %+v", err)
+
+	httpStatus, message := describeError(err)
+	var code codes.Code
+	switch httpStatus {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.FailedPrecondition
+	case http.StatusNotImplemented:
+		code = codes.Unimplemented
+	default:
+		code = codes.Internal
+	}
+	return status.Error(code, message)
+}
+
+// grpcBatchSignature extracts batch signature, which is passed in metadata as HTTP header
+func grpcBatchSignature(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(schema.BatchSignatureHeader); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (s *metricsService) Update(ctx context.Context, m *proto.Metrics) (*proto.Metrics, error) {
+	value, err := s.app.updateMetrics(ctx, proto.ToSchema(m))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	s.app.requestDump()
+	return proto.FromSchema(value), nil
+}
+
+func (s *metricsService) UpdateBatch(stream proto.MetricsService_UpdateBatchServer) error {
+	var l []schema.Metrics
+	for {
+		m, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		l = append(l, proto.ToSchema(m))
+	}
+
+	// the whole stream is applied at once, as in JSON API
+	err := s.app.updateMetricsBatch(stream.Context(), l, grpcBatchSignature(stream.Context()))
+	if err != nil {
+		return grpcError(err)
+	}
+	s.app.requestDump()
+	return stream.SendAndClose(&proto.UpdateBatchResponse{Accepted: int64(len(l))})
+}
+
+func (s *metricsService) Get(ctx context.Context, m *proto.Metrics) (*proto.Metrics, error) {
+	value, err := s.app.retrieveMetrics(ctx, proto.ToSchema(m))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return proto.FromSchema(value), nil
+}
+
+func (s *metricsService) List(ctx context.Context, _ *proto.ListRequest) (*proto.ListResponse, error) {
+	l, err := s.app.store.List(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	res := &proto.ListResponse{Metrics: make([]*proto.Metrics, 0, len(l))}
+	for _, value := range l {
+		value = withQuantiles(value)
+		if s.app.key != "" {
+			if err = value.Sign(s.app.key); err != nil {
+				return nil, grpcError(err)
+			}
+		}
+		res.Metrics = append(res.Metrics, proto.FromSchema(value))
+	}
+	return res, nil
+}
+
+// NewGRPCServer creates gRPC server, which shares storage and settings with the application
+func NewGRPCServer(app *App, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(app.unaryIdentify, app.unaryTrustedOnly, app.unaryAuthenticate),
+		grpc.ChainStreamInterceptor(app.streamIdentify, app.streamTrustedOnly, app.streamAuthenticate),
+	)
+	s := grpc.NewServer(opts...)
+	proto.RegisterMetricsServiceServer(s, &metricsService{app: app})
+	return s
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"logogger/internal/proto"
+	"logogger/internal/schema"
+	"logogger/internal/storage"
+)
+
+func newGRPCClient(t *testing.T, app *App) proto.MetricsServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	s := NewGRPCServer(app)
+	go func() {
+		_ = s.Serve(listener)
+	}()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return proto.NewMetricsServiceClient(conn)
+}
+
+func TestGRPC_Update(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemStorage()
+	assert.NoError(t, store.Put(ctx, schema.NewCounter("ctrID", 42)))
+	client := newGRPCClient(t, NewApp(store))
+
+	value, err := client.Update(ctx, proto.FromSchema(schema.NewCounter("ctrID", 1)))
+	assert.NoError(t, err)
+	assert.Equal(t, schema.NewCounter("ctrID", 43), proto.ToSchema(value))
+
+	labeled := schema.NewGauge("ggID", 13.37).WithLabels(map[string]string{"host": "agent-1"})
+	value, err = client.Update(ctx, proto.FromSchema(labeled))
+	assert.NoError(t, err)
+	assert.Equal(t, labeled, proto.ToSchema(value))
+
+	_, err = client.Update(ctx, proto.FromSchema(schema.NewCounter("ggID", 1).WithLabels(labeled.Labels)))
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = client.Update(ctx, proto.FromSchema(schema.NewGaugeRequest("ggID")))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPC_UpdateBatch(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemStorage()
+	client := newGRPCClient(t, NewApp(store))
+
+	histogram := schema.NewHistogram("latency", []float64{1, 10})
+	histogram.Histogram.Observe(5)
+	l := []schema.Metrics{
+		schema.NewCounter("ctrID", 1),
+		schema.NewCounter("ctrID", 2),
+		schema.NewGauge("ggID", 13.37),
+		histogram,
+	}
+
+	stream, err := client.UpdateBatch(ctx)
+	assert.NoError(t, err)
+	for _, m := range l {
+		assert.NoError(t, stream.Send(proto.FromSchema(m)))
+	}
+	res, err := stream.CloseAndRecv()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), res.Accepted)
+
+	values, err := store.List(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []schema.Metrics{schema.NewCounter("ctrID", 3), schema.NewGauge("ggID", 13.37), histogram}, values)
+}
+
+func TestGRPC_GetAndList(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemStorage()
+	summary := schema.NewSummary("size", schema.DefaultSummaryAccuracy)
+	summary.Summary.Observe(100)
+	assert.NoError(t, store.BulkPut(ctx, []schema.Metrics{schema.NewCounter("ctrID", 42), summary}))
+	client := newGRPCClient(t, NewApp(store).WithKey("secret"))
+
+	value, err := client.Get(ctx, proto.FromSchema(schema.NewCounterRequest("ctrID")))
+	assert.NoError(t, err)
+	signed, err := proto.ToSchema(value).IsSignedWithKey("secret")
+	assert.NoError(t, err)
+	assert.True(t, signed)
+	assert.Equal(t, int64(42), value.GetDelta())
+
+	_, err = client.Get(ctx, proto.FromSchema(schema.NewCounterRequest("nonExistent")))
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.Get(ctx, &proto.Metrics{Id: "ctrID", Type: "stats"})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	res, err := client.List(ctx, &proto.ListRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, res.Metrics, 2)
+	assert.Equal(t, "size", res.Metrics[1].GetId())
+	assert.Len(t, res.Metrics[1].GetSummary().GetQuantiles(), len(schema.DefaultSummaryQuantiles))
+}
+
+func TestGRPC_Signature(t *testing.T) {
+	ctx := context.Background()
+	client := newGRPCClient(t, NewApp(storage.NewMemStorage()).WithKey("secret"))
+
+	m := schema.NewGauge("ggID", 13.37)
+	_, err := client.Update(ctx, proto.FromSchema(m))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	assert.NoError(t, m.Sign("secret"))
+	_, err = client.Update(ctx, proto.FromSchema(m))
+	assert.NoError(t, err)
+
+	stream, err := client.UpdateBatch(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Send(proto.FromSchema(schema.NewCounter("ctrID", 1))))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	l := []schema.Metrics{schema.NewCounter("ctrID", 1)}
+	signature, err := schema.SignBatch(l, "secret")
+	assert.NoError(t, err)
+	for _, param := range []struct {
+		signature string
+		code      codes.Code
+	}{
+		{signature, codes.OK},
+		{"wrong", codes.InvalidArgument},
+	} {
+		stream, err = client.UpdateBatch(metadata.AppendToOutgoingContext(ctx, schema.BatchSignatureHeader, param.signature))
+		assert.NoError(t, err)
+		assert.NoError(t, stream.Send(proto.FromSchema(l[0])))
+		_, err = stream.CloseAndRecv()
+		assert.Equal(t, param.code, status.Code(err))
+	}
+}
+
+func TestGRPC_TrustedSubnets(t *testing.T) {
+	subnets, err := ParseSubnets("10.0.0.0/8")
+	assert.NoError(t, err)
+	client := newGRPCClient(t, NewApp(storage.NewMemStorage()).WithTrustedSubnets("X-Real-IP", subnets))
+	m := proto.FromSchema(schema.NewCounter("PollCount", 1))
+
+	trusted := metadata.AppendToOutgoingContext(context.Background(), "X-Real-IP", "10.1.2.3")
+	_, err = client.Update(trusted, m)
+	assert.NoError(t, err)
+
+	untrusted := metadata.AppendToOutgoingContext(context.Background(), "X-Real-IP", "192.168.1.1")
+	_, err = client.Update(untrusted, m)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	// in-memory connection has no peer address, so it is not trusted
+	_, err = client.Update(context.Background(), m)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	stream, err := client.UpdateBatch(untrusted)
+	assert.NoError(t, err)
+	_ = stream.Send(m)
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// reads are not restricted
+	_, err = client.List(untrusted, &proto.ListRequest{})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	"logogger/internal/crypt"
+	"logogger/internal/schema"
+)
+
+// clientIdentity returns CN of the verified client certificate for both HTTP and gRPC requests
+func clientIdentity(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			return crypt.PeerCommonName(&info.State)
+		}
+		return ""
+	}
+	if identity, ok := ctx.Value(identityKey{}).(string); ok {
+		return identity
+	}
+	return ""
+}
+
+type identityKey struct{}
+
+// checkClient rejects clients, which are not in the allowlist (if it is set)
+func (app *App) checkClient(ctx context.Context) error {
+	if len(app.allowedClients) == 0 {
+		return nil
+	}
+	if _, ok := app.allowedClients[clientIdentity(ctx)]; !ok {
+		return &requestError{status: http.StatusForbidden, body: "Client is not allowed"}
+	}
+	return nil
+}
+
+// identify is a middleware, which makes client identity available to handlers
+func (app *App) identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), identityKey{}, crypt.PeerCommonName(r.TLS))
+		if err := app.checkClient(ctx); err != nil {
+			WriteError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (app *App) unaryIdentify(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := app.checkClient(ctx); err != nil {
+		return nil, grpcError(err)
+	}
+	return handler(ctx, req)
+}
+
+func (app *App) streamIdentify(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := app.checkClient(ss.Context()); err != nil {
+		return grpcError(err)
+	}
+	return handler(srv, ss)
+}
+
+// withIdentity attaches client identity label to the metrics,
+// label sent by the client is overridden, so it could not be spoofed
+func (app *App) withIdentity(ctx context.Context, m schema.Metrics) schema.Metrics {
+	if app.identityLabel == "" {
+		return m
+	}
+	identity := clientIdentity(ctx)
+	if identity == "" {
+		return m
+	}
+
+	labels := make(map[string]string, len(m.Labels)+1)
+	for k, v := range m.Labels {
+		labels[k] = v
+	}
+	labels[app.identityLabel] = identity
+	return m.WithLabels(labels)
+}
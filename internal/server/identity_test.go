@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"logogger/internal/crypt"
+	"logogger/internal/schema"
+	"logogger/internal/storage"
+)
+
+type testPKI struct {
+	dir    string
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	serial int64
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	p := &testPKI{dir: t.TempDir()}
+	p.ca, p.caKey = p.issue(t, "ca", &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	})
+	return p
+}
+
+// issue writes certificate and key into name.crt and name.key, CA certificate is self-signed
+func (p *testPKI) issue(t *testing.T, name string, template *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	p.serial++
+	template.SerialNumber = big.NewInt(p.serial)
+	template.NotBefore = time.Now().Add(-time.Minute)
+	template.NotAfter = time.Now().Add(time.Hour)
+	parent, parentKey := p.ca, p.caKey
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(p.path(name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(p.path(name+".key"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+	return cert, key
+}
+
+func (p *testPKI) path(name string) string {
+	return filepath.Join(p.dir, name)
+}
+
+func (p *testPKI) client(t *testing.T, name string) *http.Client {
+	if name != "" {
+		p.issue(t, name, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: name},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+	}
+	var certPath, keyPath string
+	if name != "" {
+		certPath, keyPath = p.path(name+".crt"), p.path(name+".key")
+	}
+	config, err := crypt.ClientTLSConfig(certPath, keyPath, p.path("ca.crt"))
+	require.NoError(t, err)
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+}
+
+func TestApp_ClientIdentity(t *testing.T) {
+	pki := newTestPKI(t)
+	pki.issue(t, "server", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	config, err := crypt.ServerTLSConfig(pki.path("server.crt"), pki.path("server.key"), pki.path("ca.crt"))
+	require.NoError(t, err)
+
+	store := storage.NewMemStorage()
+	app := NewApp(store).WithClientIdentity("host", []string{"agent-1"})
+	server := httptest.NewUnstartedServer(app.Router)
+	server.TLS = config
+	server.StartTLS()
+	defer server.Close()
+
+	post := func(client *http.Client, body string) (*http.Response, error) {
+		resp, err_ := client.Post(server.URL+"/update/", "application/json", strings.NewReader(body))
+		if err_ == nil {
+			resp.Body.Close()
+		}
+		return resp, err_
+	}
+
+	// label from the client is overridden
+	resp, err := post(pki.client(t, "agent-1"), `{"id":"PollCount","type":"counter","delta":1,"labels":{"host":"fake"}}`)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	value, err := store.Extract(context.Background(), schema.NewCounterRequest("PollCount").WithLabels(map[string]string{"host": "agent-1"}))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *value.Delta)
+
+	// client is not in allowlist
+	resp, err = post(pki.client(t, "agent-2"), `{"id":"PollCount","type":"counter","delta":1}`)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// client without certificate
+	_, err = post(pki.client(t, ""), `{"id":"PollCount","type":"counter","delta":1}`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"logogger/internal/schema"
+)
+
+// prometheusContentType is content type of Prometheus text exposition format
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// sanitizePrometheusName replaces all characters, which are not allowed
+// in Prometheus metric (or label, if colons are not allowed) names, with underscores
+func sanitizePrometheusName(name string, allowColon bool) string {
+	var sb strings.Builder
+	for i, c := range name {
+		valid := c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || allowColon && c == ':'
+		if i > 0 {
+			valid = valid || c >= '0' && c <= '9'
+		} else if c >= '0' && c <= '9' {
+			// names could not start with a digit, so we keep it prefixed
+			sb.WriteRune('_')
+			valid = true
+		}
+		if valid {
+			sb.WriteRune(c)
+		} else {
+			sb.WriteRune('_')
+		}
+	}
+	if sb.Len() == 0 {
+		return "_"
+	}
+	return sb.String()
+}
+
+func escapePrometheusLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func escapePrometheusHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func formatPrometheusValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// formatPrometheusLabels renders labels in sorted order, extra label (e.g. le or quantile)
+// is appended to the end if not empty
+func formatPrometheusLabels(labels map[string]string, extraName string, extraValue string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var pairs []string
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, sanitizePrometheusName(name, false), escapePrometheusLabelValue(labels[name])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, escapePrometheusLabelValue(extraValue)))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+type prometheusFamily struct {
+	name    string
+	help    string
+	mType   schema.MetricsType
+	metrics []schema.Metrics
+}
+
+func prometheusFamilyName(m schema.Metrics) string {
+	name := sanitizePrometheusName(m.ID, true)
+	if m.MType == schema.MetricsTypeCounter && !strings.HasSuffix(name, "_total") {
+		name += "_total"
+	}
+	return name
+}
+
+func writePrometheusFamily(sb *strings.Builder, f *prometheusFamily) {
+	if f.help != "" {
+		sb.WriteString(fmt.Sprintf("# HELP %s %s\n", f.name, escapePrometheusHelp(f.help)))
+	}
+	sb.WriteString(fmt.Sprintf("# TYPE %s %s\n", f.name, f.mType))
+
+	for _, m := range f.metrics {
+		switch m.MType {
+		case schema.MetricsTypeCounter:
+			sb.WriteString(fmt.Sprintf("%s%s %d\n", f.name, formatPrometheusLabels(m.Labels, "", ""), *m.Delta))
+		case schema.MetricsTypeGauge:
+			sb.WriteString(fmt.Sprintf("%s%s %s\n", f.name, formatPrometheusLabels(m.Labels, "", ""), formatPrometheusValue(*m.Value)))
+		case schema.MetricsTypeHistogram:
+			h := m.Histogram
+			var cumulative int64
+			for i, count := range h.Counts {
+				cumulative += count
+				le := "+Inf"
+				if i < len(h.Bounds) {
+					le = formatPrometheusValue(h.Bounds[i])
+				}
+				sb.WriteString(fmt.Sprintf("%s_bucket%s %d\n", f.name, formatPrometheusLabels(m.Labels, "le", le), cumulative))
+			}
+			sb.WriteString(fmt.Sprintf("%s_sum%s %s\n", f.name, formatPrometheusLabels(m.Labels, "", ""), formatPrometheusValue(h.Sum)))
+			sb.WriteString(fmt.Sprintf("%s_count%s %d\n", f.name, formatPrometheusLabels(m.Labels, "", ""), h.Count))
+		case schema.MetricsTypeSummary:
+			s := m.Summary
+			if s.Count > 0 {
+				for _, q := range schema.DefaultSummaryQuantiles {
+					sb.WriteString(fmt.Sprintf("%s%s %s\n", f.name, formatPrometheusLabels(m.Labels, "quantile", formatPrometheusValue(q)), formatPrometheusValue(s.Quantile(q))))
+				}
+			}
+			sb.WriteString(fmt.Sprintf("%s_sum%s %s\n", f.name, formatPrometheusLabels(m.Labels, "", ""), formatPrometheusValue(s.Sum)))
+			sb.WriteString(fmt.Sprintf("%s_count%s %d\n", f.name, formatPrometheusLabels(m.Labels, "", ""), s.Count))
+		}
+	}
+}
+
+// isExportable checks if metrics holds a value, which could be rendered
+func isExportable(m schema.Metrics) bool {
+	switch m.MType {
+	case schema.MetricsTypeCounter:
+		return m.Delta != nil
+	case schema.MetricsTypeGauge:
+		return m.Value != nil
+	case schema.MetricsTypeHistogram:
+		return m.Histogram != nil
+	case schema.MetricsTypeSummary:
+		return m.Summary != nil
+	default:
+		return false
+	}
+}
+
+func (app *App) exportPrometheus(w http.ResponseWriter, r *http.Request) error {
+	list, err := app.store.List(r.Context())
+	if err != nil {
+		return err
+	}
+
+	metadata, err := app.store.ListMetadata(r.Context())
+	if err != nil {
+		return err
+	}
+	help := map[string]string{}
+	for _, meta := range metadata {
+		help[meta.ID] = meta.Help
+	}
+
+	families := map[string]*prometheusFamily{}
+	var names []string
+	for _, m := range list {
+		if !isExportable(m) {
+			continue
+		}
+		name := prometheusFamilyName(m)
+		f, found := families[name]
+		if !found {
+			f = &prometheusFamily{name: name, help: help[m.ID], mType: m.MType}
+			families[name] = f
+			names = append(names, name)
+		}
+		if f.mType != m.MType {
+			// different metrics may end up with the same name after sanitization,
+			// but family could not contain metrics of different types
+			log.Printf("Skipping metrics %s of type %s in prometheus export, family %s has type %s", m.Key(), m.MType, name, f.mType)
+			continue
+		}
+		f.metrics = append(f.metrics, m)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		writePrometheusFamily(&sb, families[name])
+	}
+
+	SafeWrite(w, http.StatusOK, "%s", sb.String())
+	return nil
+}
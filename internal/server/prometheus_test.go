@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"logogger/internal/schema"
+	"logogger/internal/storage"
+)
+
+func TestSanitizePrometheusName(t *testing.T) {
+	params := []struct {
+		name       string
+		allowColon bool
+		expected   string
+	}{
+		{"HeapAlloc", true, "HeapAlloc"},
+		{"http.requests-count", true, "http_requests_count"},
+		{"namespace:metric", true, "namespace:metric"},
+		{"namespace:label", false, "namespace_label"},
+		{"3dPrinter", true, "_3dPrinter"},
+		{"", true, "_"},
+	}
+
+	for _, param := range params {
+		assert.Equal(t, param.expected, sanitizePrometheusName(param.name, param.allowColon))
+	}
+}
+
+func TestApp_ExportPrometheus(t *testing.T) {
+	store := storage.NewMemStorage()
+	ctx := context.Background()
+	histogram := schema.NewHistogram("request.latency", []float64{0.1, 1})
+	histogram.Histogram.Observe(0.05)
+	histogram.Histogram.Observe(0.5)
+	histogram.Histogram.Observe(5)
+	summary := schema.NewSummary("response_size", schema.DefaultSummaryAccuracy)
+	summary.Summary.Observe(100)
+
+	err := store.BulkPut(ctx, []schema.Metrics{
+		schema.NewCounter("PollCount", 5),
+		schema.NewGauge("HeapAlloc", 1024).WithLabels(map[string]string{"host": `agent "1"`}),
+		schema.NewGauge("HeapAlloc", 2048).WithLabels(map[string]string{"host": "agent-2"}),
+		histogram,
+		summary,
+		schema.NewGaugeRequest("empty"),
+	})
+	assert.NoError(t, err)
+	err = store.PutMetadata(ctx, []schema.Metadata{{ID: "HeapAlloc", Help: "Bytes of allocated heap objects"}})
+	assert.NoError(t, err)
+
+	app := NewApp(store)
+	req, err := http.NewRequest(http.MethodGet, "/metrics", nil)
+	assert.NoError(t, err)
+	recorder := httptest.NewRecorder()
+	app.Router.ServeHTTP(recorder, req)
+
+	expected := `# HELP HeapAlloc Bytes of allocated heap objects
+# TYPE HeapAlloc gauge
+HeapAlloc{host="agent \"1\""} 1024
+HeapAlloc{host="agent-2"} 2048
+# TYPE PollCount_total counter
+PollCount_total 5
+# TYPE request_latency histogram
+request_latency_bucket{le="0.1"} 1
+request_latency_bucket{le="1"} 2
+request_latency_bucket{le="+Inf"} 3
+request_latency_sum 5.55
+request_latency_count 3
+# TYPE response_size summary
+response_size{quantile="0.5"} 100.4945677085636
+response_size{quantile="0.9"} 100.4945677085636
+response_size{quantile="0.99"} 100.4945677085636
+response_size_sum 100
+response_size_count 1
+`
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, prometheusContentType, recorder.Header().Get("Content-Type"))
+	assert.Equal(t, expected, recorder.Body.String())
+}
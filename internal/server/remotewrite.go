@@ -0,0 +1,284 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"logogger/internal/schema"
+)
+
+// maxRemoteWriteSize limits decompressed size of a single remote-write request
+const maxRemoteWriteSize = 32 << 20
+
+// prometheusNameLabel is the label, which holds metric name in Prometheus series
+const prometheusNameLabel = "__name__"
+
+// field numbers of prometheus.WriteRequest and nested messages
+// (see prometheus/prompb/remote.proto and types.proto)
+const (
+	writeRequestTimeseries protowire.Number = 1
+	timeSeriesLabels       protowire.Number = 1
+	timeSeriesSamples      protowire.Number = 2
+	labelName              protowire.Number = 1
+	labelValue             protowire.Number = 2
+	sampleValue            protowire.Number = 1
+	sampleTimestamp        protowire.Number = 2
+)
+
+type remoteWriteSample struct {
+	Value     float64
+	Timestamp int64
+}
+
+type remoteWriteSeries struct {
+	Labels  map[string]string
+	Samples []remoteWriteSample
+}
+
+// consumeMessage walks over all fields of protobuf message, calling visit for each of them,
+// visit returns number of consumed bytes or negative value to skip the field
+func consumeMessage(b []byte, visit func(num protowire.Number, typ protowire.Type, b []byte) int) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		n = visit(num, typ, b)
+		if n < 0 {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func decodeLabel(b []byte) (name string, value string, err error) {
+	err = consumeMessage(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		if typ != protowire.BytesType {
+			return -1
+		}
+		switch num {
+		case labelName:
+			v, n := protowire.ConsumeString(b)
+			name = v
+			return n
+		case labelValue:
+			v, n := protowire.ConsumeString(b)
+			value = v
+			return n
+		}
+		return -1
+	})
+	return
+}
+
+func decodeSample(b []byte) (sample remoteWriteSample, err error) {
+	err = consumeMessage(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch {
+		case num == sampleValue && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			sample.Value = math.Float64frombits(v)
+			return n
+		case num == sampleTimestamp && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			sample.Timestamp = int64(v)
+			return n
+		}
+		return -1
+	})
+	return
+}
+
+func decodeTimeSeries(b []byte) (series remoteWriteSeries, err error) {
+	series.Labels = map[string]string{}
+	var nested error
+	err = consumeMessage(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		if typ != protowire.BytesType || num != timeSeriesLabels && num != timeSeriesSamples {
+			return -1
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n
+		}
+		switch num {
+		case timeSeriesLabels:
+			name, value, err_ := decodeLabel(v)
+			if err_ != nil {
+				nested = err_
+				return len(b)
+			}
+			series.Labels[name] = value
+		case timeSeriesSamples:
+			sample, err_ := decodeSample(v)
+			if err_ != nil {
+				nested = err_
+				return len(b)
+			}
+			series.Samples = append(series.Samples, sample)
+		}
+		return n
+	})
+	if err == nil {
+		err = nested
+	}
+	return
+}
+
+// decodeWriteRequest parses uncompressed prometheus.WriteRequest,
+// metadata and native histograms are ignored
+func decodeWriteRequest(b []byte) ([]remoteWriteSeries, error) {
+	var l []remoteWriteSeries
+	var nested error
+	err := consumeMessage(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		if num != writeRequestTimeseries || typ != protowire.BytesType {
+			return -1
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n
+		}
+		series, err := decodeTimeSeries(v)
+		if err != nil {
+			nested = err
+			return len(b)
+		}
+		l = append(l, series)
+		return n
+	})
+	if err == nil {
+		err = nested
+	}
+	return l, err
+}
+
+// remoteWriteGauges maps series to gauges, only the latest sample of each series is kept,
+// non-finite values (including staleness markers) could not be stored and are skipped
+func remoteWriteGauges(l []remoteWriteSeries) ([]schema.Metrics, error) {
+	latest := map[string]remoteWriteSample{}
+	gauges := map[string]schema.Metrics{}
+	var keys []string
+
+	for _, series := range l {
+		name := series.Labels[prometheusNameLabel]
+		if name == "" {
+			return nil, ValidationError("series without metric name")
+		}
+		labels := map[string]string{}
+		for k, v := range series.Labels {
+			// empty label value is the same as missing label in Prometheus
+			if k != prometheusNameLabel && v != "" {
+				labels[k] = v
+			}
+		}
+
+		for _, sample := range series.Samples {
+			if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+				continue
+			}
+			m := schema.NewGauge(name, sample.Value).WithLabels(labels)
+			key := m.Key()
+			prev, found := latest[key]
+			if !found {
+				keys = append(keys, key)
+			} else if prev.Timestamp > sample.Timestamp {
+				continue
+			}
+			latest[key] = sample
+			gauges[key] = m
+		}
+	}
+
+	res := make([]schema.Metrics, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, gauges[key])
+	}
+	return res, nil
+}
+
+func (app *App) remoteWrite(w http.ResponseWriter, r *http.Request) error {
+	if app.key != "" {
+		// remote-write samples carry no signature, so they could not be trusted
+		return &requestError{
+			status: http.StatusForbidden,
+			body:   "Remote write is not available when metrics have to be signed",
+		}
+	}
+
+	if r.Body == nil {
+		return ValidationError("empty body")
+	}
+
+	if encoding := r.Header.Get("Content-Encoding"); encoding != "" && encoding != "snappy" {
+		return &requestError{
+			status: http.StatusUnsupportedMediaType,
+			body:   fmt.Sprintf("Unsupported content encoding %s", encoding),
+		}
+	}
+
+	// compressed body is read into memory, so it is limited as well
+	compressed, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(snappy.MaxEncodedLen(maxRemoteWriteSize))))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return &requestError{
+			status: http.StatusRequestEntityTooLarge,
+			body:   "Remote write request is too large",
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	size, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		return ValidationError(err.Error())
+	}
+	if size > maxRemoteWriteSize {
+		return &requestError{
+			status: http.StatusRequestEntityTooLarge,
+			body:   "Remote write request is too large",
+		}
+	}
+
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return ValidationError(err.Error())
+	}
+
+	l, err := decodeWriteRequest(data)
+	if err != nil {
+		return ValidationError(err.Error())
+	}
+
+	gauges, err := remoteWriteGauges(l)
+	if err != nil {
+		return err
+	}
+
+	for _, gauge := range gauges {
+		if err = app.authorizeWrite(r.Context(), gauge.ID); err != nil {
+			return err
+		}
+	}
+
+	if len(gauges) != 0 {
+		err = app.store.BulkUpdate(r.Context(), nil, gauges, nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	app.requestDump()
+	return nil
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"logogger/internal/schema"
+	"logogger/internal/storage"
+)
+
+// encodeWriteRequest builds prometheus.WriteRequest by hand, so no generated code is needed
+func encodeWriteRequest(l []remoteWriteSeries) []byte {
+	var req []byte
+	for _, series := range l {
+		var ts []byte
+		for name, value := range series.Labels {
+			var label []byte
+			label = protowire.AppendTag(label, labelName, protowire.BytesType)
+			label = protowire.AppendString(label, name)
+			label = protowire.AppendTag(label, labelValue, protowire.BytesType)
+			label = protowire.AppendString(label, value)
+			ts = protowire.AppendTag(ts, timeSeriesLabels, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+		for _, s := range series.Samples {
+			var sample []byte
+			sample = protowire.AppendTag(sample, sampleValue, protowire.Fixed64Type)
+			sample = protowire.AppendFixed64(sample, math.Float64bits(s.Value))
+			sample = protowire.AppendTag(sample, sampleTimestamp, protowire.VarintType)
+			sample = protowire.AppendVarint(sample, uint64(s.Timestamp))
+			ts = protowire.AppendTag(ts, timeSeriesSamples, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, sample)
+		}
+		req = protowire.AppendTag(req, writeRequestTimeseries, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	// unknown fields (here: metadata) should be skipped
+	req = protowire.AppendTag(req, 3, protowire.BytesType)
+	req = protowire.AppendBytes(req, []byte{})
+	return req
+}
+
+func TestDecodeWriteRequest(t *testing.T) {
+	expected := []remoteWriteSeries{
+		{
+			Labels:  map[string]string{"__name__": "up", "job": "node"},
+			Samples: []remoteWriteSample{{Value: 1, Timestamp: 1000}, {Value: 0, Timestamp: 2000}},
+		},
+		{
+			Labels:  map[string]string{"__name__": "go_goroutines"},
+			Samples: []remoteWriteSample{{Value: 42, Timestamp: 1000}},
+		},
+	}
+
+	actual, err := decodeWriteRequest(encodeWriteRequest(expected))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	_, err = decodeWriteRequest([]byte{0x0a, 0x05, 0x01})
+	assert.Error(t, err)
+}
+
+func TestApp_RemoteWrite(t *testing.T) {
+	store := storage.NewMemStorage()
+	app := NewApp(store)
+
+	payload := encodeWriteRequest([]remoteWriteSeries{
+		{
+			Labels:  map[string]string{"__name__": "up", "job": "node", "env": ""},
+			Samples: []remoteWriteSample{{Value: 0, Timestamp: 2000}, {Value: 1, Timestamp: 1000}},
+		},
+		{
+			Labels:  map[string]string{"__name__": "node_load1", "job": "node"},
+			Samples: []remoteWriteSample{{Value: 0.5, Timestamp: 1000}},
+		},
+		{
+			// staleness marker could not be stored
+			Labels:  map[string]string{"__name__": "node_load5", "job": "node"},
+			Samples: []remoteWriteSample{{Value: math.Float64frombits(0x7ff0000000000002), Timestamp: 1000}},
+		},
+	})
+
+	req, err := http.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(snappy.Encode(nil, payload)))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	recorder := httptest.NewRecorder()
+	app.Router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+
+	l, err := store.List(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []schema.Metrics{
+		schema.NewGauge("node_load1", 0.5).WithLabels(map[string]string{"job": "node"}),
+		schema.NewGauge("up", 0).WithLabels(map[string]string{"job": "node"}),
+	}, l)
+}
+
+func TestApp_RemoteWriteInvalid(t *testing.T) {
+	nameless := encodeWriteRequest([]remoteWriteSeries{
+		{Labels: map[string]string{"job": "node"}, Samples: []remoteWriteSample{{Value: 1}}},
+	})
+
+	params := []struct {
+		body     []byte
+		encoding string
+		key      string
+		code     int
+	}{
+		{[]byte("not snappy at all"), "snappy", "", http.StatusBadRequest},
+		{snappy.Encode(nil, []byte{0x0a, 0x05, 0x01}), "snappy", "", http.StatusBadRequest},
+		{snappy.Encode(nil, nameless), "snappy", "", http.StatusBadRequest},
+		{snappy.Encode(nil, nameless), "gzip", "", http.StatusUnsupportedMediaType},
+		{snappy.Encode(nil, nameless), "snappy", "secret", http.StatusForbidden},
+		// decoded length in the header exceeds the limit
+		{protowire.AppendVarint(nil, 1<<30), "snappy", "", http.StatusRequestEntityTooLarge},
+		{make([]byte, snappy.MaxEncodedLen(maxRemoteWriteSize)+1), "snappy", "", http.StatusRequestEntityTooLarge},
+	}
+	for _, param := range params {
+		app := NewApp(storage.NewMemStorage()).WithKey(param.key)
+		req, err := http.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(param.body))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Encoding", param.encoding)
+		recorder := httptest.NewRecorder()
+		app.Router.ServeHTTP(recorder, req)
+		assert.Equal(t, param.code, recorder.Code)
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"logogger/internal/schema"
+)
+
+// replayGuard rejects signed metrics, which were signed too long ago or were already accepted,
+// nonces are remembered while their signing time is inside the acceptance window
+type replayGuard struct {
+	now        func() time.Time
+	nonces     map[string]time.Time
+	nextExpire time.Time
+	window     time.Duration
+	mu         sync.Mutex
+}
+
+func newReplayGuard(window time.Duration) *replayGuard {
+	return &replayGuard{
+		now:    time.Now,
+		nonces: map[string]time.Time{},
+		window: window,
+	}
+}
+
+// expire should be called with the lock held, expired nonces are removed
+// not more often than once per window, as they are harmless until then
+func (g *replayGuard) expire(now time.Time) {
+	if now.Before(g.nextExpire) {
+		return
+	}
+	g.nextExpire = now.Add(g.window)
+	for nonce, expires := range g.nonces {
+		if !now.Before(expires) {
+			delete(g.nonces, nonce)
+		}
+	}
+}
+
+// reserve checks all the metrics and remembers their nonces, so the same update is accepted only once.
+// Returned function releases nonces, it should be called if metrics were not stored
+func (g *replayGuard) reserve(l []schema.Metrics) (func(), error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	g.expire(now)
+
+	batch := make(map[string]struct{}, len(l))
+	for _, m := range l {
+		if m.SignedAt == 0 || m.Nonce == "" {
+			return nil, ValidationError(fmt.Sprintf("metrics %s has no signing time or nonce", m.Key()))
+		}
+		signedAt := time.UnixMilli(m.SignedAt)
+		if signedAt.Before(now.Add(-g.window)) || signedAt.After(now.Add(g.window)) {
+			return nil, ValidationError(fmt.Sprintf("metrics %s is signed outside of acceptance window", m.Key()))
+		}
+		if _, seen := g.nonces[m.Nonce]; seen {
+			return nil, ValidationError(fmt.Sprintf("metrics %s is replayed", m.Key()))
+		}
+		if _, seen := batch[m.Nonce]; seen {
+			return nil, ValidationError(fmt.Sprintf("metrics %s is replayed", m.Key()))
+		}
+		batch[m.Nonce] = struct{}{}
+	}
+
+	for _, m := range l {
+		// signature could not be accepted after it leaves the window, so nonce is not needed anymore
+		g.nonces[m.Nonce] = time.UnixMilli(m.SignedAt).Add(g.window)
+	}
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		for nonce := range batch {
+			delete(g.nonces, nonce)
+		}
+	}, nil
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"logogger/internal/schema"
+	"logogger/internal/storage"
+)
+
+func stamped(t *testing.T, m schema.Metrics, signedAt time.Time, key string) schema.Metrics {
+	require.NoError(t, m.Stamp(signedAt))
+	if key != "" {
+		require.NoError(t, m.Sign(key))
+	}
+	return m
+}
+
+func TestReplayGuard_Reserve(t *testing.T) {
+	now := time.Now()
+	g := newReplayGuard(time.Minute)
+	g.now = func() time.Time { return now }
+
+	fresh := stamped(t, schema.NewCounter("PollCount", 1), now, "")
+	_, err := g.reserve([]schema.Metrics{fresh})
+	require.NoError(t, err)
+
+	_, err = g.reserve([]schema.Metrics{fresh})
+	assert.Error(t, err, "replayed nonce should be rejected")
+
+	_, err = g.reserve([]schema.Metrics{schema.NewCounter("PollCount", 1)})
+	assert.Error(t, err, "metrics without nonce should be rejected")
+
+	_, err = g.reserve([]schema.Metrics{stamped(t, schema.NewCounter("PollCount", 1), now.Add(-2*time.Minute), "")})
+	assert.Error(t, err, "metrics signed before the window should be rejected")
+
+	_, err = g.reserve([]schema.Metrics{stamped(t, schema.NewCounter("PollCount", 1), now.Add(2*time.Minute), "")})
+	assert.Error(t, err, "metrics signed after the window should be rejected")
+
+	other := stamped(t, schema.NewCounter("PollCount", 1), now, "")
+	_, err = g.reserve([]schema.Metrics{other, other})
+	assert.Error(t, err, "duplicates within a batch should be rejected")
+
+	release, err := g.reserve([]schema.Metrics{other})
+	require.NoError(t, err, "rejected batch should not reserve nonces")
+	release()
+	_, err = g.reserve([]schema.Metrics{other})
+	assert.NoError(t, err, "released nonce should be accepted again")
+
+	now = now.Add(2 * time.Minute)
+	g.expire(now)
+	assert.Empty(t, g.nonces, "nonces should expire together with the window")
+}
+
+func TestApp_Replay(t *testing.T) {
+	key := "secret"
+	app := NewApp(storage.NewMemStorage()).WithKey(key).WithReplayWindow(time.Minute)
+
+	body := func(v any) string {
+		data, err := json.Marshal(v)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	m := stamped(t, schema.NewCounter("PollCount", 5), time.Now(), key)
+	recorder := request(t, app, http.MethodPost, "/update/", "", body(m))
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	recorder = request(t, app, http.MethodPost, "/update/", "", body(m))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code, "replayed update should be rejected")
+
+	batch := []schema.Metrics{
+		stamped(t, schema.NewCounter("PollCount", 5), time.Now(), key),
+		stamped(t, schema.NewGauge("Alloc", 1), time.Now(), key),
+	}
+	recorder = request(t, app, http.MethodPost, "/updates/", "", body(batch))
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	recorder = request(t, app, http.MethodPost, "/updates/", "", body(batch))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code, "replayed batch should be rejected")
+
+	legacy := schema.NewCounter("PollCount", 5)
+	require.NoError(t, legacy.Sign(key))
+	recorder = request(t, app, http.MethodPost, "/update/", "", body(legacy))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code, "unstamped update should be rejected")
+
+	tampered := stamped(t, schema.NewCounter("PollCount", 5), time.Now(), key)
+	tampered.Nonce = "replaced"
+	recorder = request(t, app, http.MethodPost, "/update/", "", body(tampered))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code, "nonce should be covered by signature")
+
+	recorder = request(t, app, http.MethodPost, "/value/", "", `{"id":"PollCount","type":"counter"}`)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	var stored schema.Metrics
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &stored))
+	assert.Equal(t, int64(10), *stored.Delta, "only original updates should be counted")
+	assert.Empty(t, stored.Nonce)
+	assert.Zero(t, stored.SignedAt)
+
+	unsigned := NewApp(storage.NewMemStorage()).WithReplayWindow(time.Minute)
+	for i := 0; i < 2; i++ {
+		recorder = request(t, unsigned, http.MethodPost, "/update/", "", `{"id":"PollCount","type":"counter","delta":1}`)
+		assert.Equal(t, http.StatusOK, recorder.Code, "unsigned deployments should not be affected")
+	}
+}
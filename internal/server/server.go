@@ -6,10 +6,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"html"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -26,17 +29,28 @@ type App struct {
 	decryptor crypt.Decryptor
 	dumper    dumper.Dumper
 	Router    *chi.Mux
-	key       string
-	sync      bool
+	// allowedClients are CNs of client certificates, all clients are allowed if empty
+	allowedClients map[string]struct{}
+	// trustedSubnets restrict updates, address of the client is taken from realIPHeader
+	trustedSubnets []*net.IPNet
+	key            string
+	// replay is used only if metrics are signed
+	replay *replayGuard
+	// adminTokenHash enables API tokens, it is empty if tokens are not required
+	adminTokenHash string
+	identityLabel  string
+	realIPHeader   string
+	sync           bool
+	// dumps are performed in background, if dump interval is set
+	dumps  *asyncDumper
+	dumpMu sync.Mutex
 }
 
 type errorHTTPHandler func(http.ResponseWriter, *http.Request) error
 
-func (app *App) newHandler(handler errorHTTPHandler) http.HandlerFunc {
+func (app *App) newHandler(scope schema.TokenScope, handler errorHTTPHandler) http.HandlerFunc {
+	plain := app.newPlainHandler(scope, handler)
 	return func(writer http.ResponseWriter, request *http.Request) {
-		errChan := make(chan error)
-		ctx := request.Context()
-
 		if request.Body != nil {
 			data, err := io.ReadAll(request.Body)
 			if err != nil {
@@ -57,6 +71,23 @@ func (app *App) newHandler(handler errorHTTPHandler) http.HandlerFunc {
 			}
 		}
 
+		plain(writer, request)
+	}
+}
+
+// newPlainHandler is the same as newHandler, but request body is passed as is,
+// it is used for endpoints, which are called by third-party clients
+func (app *App) newPlainHandler(scope schema.TokenScope, handler errorHTTPHandler) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx, err := app.authenticate(request.Context(), request.Header.Get("Authorization"), scope)
+		if err != nil {
+			log.Printf("ERROR: %+v", err)
+			WriteError(writer, err)
+			return
+		}
+		request = request.WithContext(ctx)
+		errChan := make(chan error)
+
 		go func() {
 			defer func() {
 				recover()
@@ -88,6 +119,10 @@ func (app *App) retrieveValue(w http.ResponseWriter, r *http.Request) error {
 		req = schema.NewCounterRequest(name)
 	case schema.MetricsTypeGauge:
 		req = schema.NewGaugeRequest(name)
+	case schema.MetricsTypeHistogram:
+		req = schema.NewHistogramRequest(name)
+	case schema.MetricsTypeSummary:
+		req = schema.NewSummaryRequest(name)
 	default:
 		return &requestError{
 			status: http.StatusNotImplemented,
@@ -114,6 +149,10 @@ func (app *App) updateValue(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	if err = app.authorizeWrite(r.Context(), value.ID); err != nil {
+		return err
+	}
+	value = app.withIdentity(r.Context(), value)
 
 	switch value.MType {
 	case "counter":
@@ -124,6 +163,10 @@ func (app *App) updateValue(w http.ResponseWriter, r *http.Request) error {
 		}
 	case "gauge":
 		err = app.store.Put(r.Context(), value)
+	case "histogram":
+		err = app.store.BulkUpdate(r.Context(), nil, nil, []schema.Metrics{value}, nil)
+	case "summary":
+		err = app.store.BulkUpdate(r.Context(), nil, nil, nil, []schema.Metrics{value})
 	default:
 		return &requestError{
 			status: http.StatusNotImplemented,
@@ -136,10 +179,7 @@ func (app *App) updateValue(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	SafeWrite(w, http.StatusOK, "Status: OK")
-	if app.sync {
-		// safe dump does not depend on request, so we use background context
-		go app.safeDump(context.Background())
-	}
+	app.requestDump()
 	return nil
 }
 
@@ -153,13 +193,27 @@ func (app *App) listMetrics(w http.ResponseWriter, r *http.Request) error {
 		w.WriteHeader(http.StatusOK)
 		return nil
 	}
+
+	metadata, err := app.store.ListMetadata(r.Context())
+	if err != nil {
+		return err
+	}
+	declared := map[string]schema.Metadata{}
+	for _, meta := range metadata {
+		declared[meta.ID] = meta
+	}
+
 	var sb strings.Builder
 
-	header := "<table><tr><th>Type</th><th>Name</th><th>Value</th></tr>"
+	header := "<table><tr><th>Type</th><th>Name</th><th>Value</th><th>Unit</th><th>Description</th></tr>"
 	sb.Write([]byte(header))
 	for _, metrics := range list {
 		name, mType, value := metrics.Explain()
-		row := fmt.Sprintf("<tr><td>%s</td><td>%s</td><td>%s</td></tr>", name, mType, value)
+		meta := declared[metrics.ID]
+		row := fmt.Sprintf(
+			"<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>",
+			html.EscapeString(name), html.EscapeString(mType), html.EscapeString(value), html.EscapeString(meta.Unit), html.EscapeString(meta.Help),
+		)
 		sb.Write([]byte(row))
 	}
 	footer := "</table>"
@@ -189,61 +243,18 @@ func (app *App) updateValueJSON(w http.ResponseWriter, r *http.Request) error {
 		return ValidationError(err.Error())
 	}
 
-	if m.MType == schema.MetricsTypeCounter && m.Delta == nil || m.MType == schema.MetricsTypeGauge && m.Value == nil {
-		return ValidationError("Missing Value")
-	}
-
-	if app.key != "" {
-		signed, err_ := m.IsSignedWithKey(app.key)
-		if err_ != nil {
-			return err_
-		}
-		if !signed {
-			return ValidationError("signature mismatch")
-		}
-	}
-
-	switch m.MType {
-	case schema.MetricsTypeCounter:
-		err = app.store.Increment(r.Context(), m, *m.Delta)
-		switch err.(type) {
-		case *storage.NotFound:
-			err = app.store.Put(r.Context(), m)
-		}
-	case schema.MetricsTypeGauge:
-		err = app.store.Put(r.Context(), m)
-	default:
-		return &requestError{
-			status: http.StatusNotImplemented,
-			body:   fmt.Sprintf("Could not perform requested operation on metric type %s", m.MType),
-		}
-	}
-
-	if err != nil {
-		return err
-	}
-
-	value, err := app.store.Extract(r.Context(), m)
+	value, err := app.updateMetrics(r.Context(), m)
 	if err != nil {
 		return err
 	}
 
-	if app.key != "" {
-		if err = value.Sign(app.key); err != nil {
-			return err
-		}
-	}
-
 	serialized, err := json.Marshal(value)
 	if err != nil {
 		return err
 	}
 
 	SafeWrite(w, http.StatusOK, string(serialized))
-	if app.sync {
-		// safe dump does not depend on request, so we use background context
-		go app.safeDump(context.Background())
-	}
+	app.requestDump()
 	return nil
 }
 
@@ -266,33 +277,7 @@ func (app *App) updateValuesJSON(w http.ResponseWriter, r *http.Request) error {
 		return ValidationError(err.Error())
 	}
 
-	var counters []schema.Metrics
-	var gauges []schema.Metrics
-
-	for _, item := range m {
-		if app.key != "" {
-			signed, err_ := item.IsSignedWithKey(app.key)
-			if err_ != nil {
-				return err_
-			}
-			if !signed {
-				return ValidationError("signature mismatch")
-			}
-		}
-		switch item.MType {
-		case schema.MetricsTypeCounter:
-			counters = append(counters, item)
-		case schema.MetricsTypeGauge:
-			gauges = append(gauges, item)
-		default:
-			return &requestError{
-				status: http.StatusNotImplemented,
-				body:   fmt.Sprintf("Could not perform requested operation on metric type %s", item.MType),
-			}
-		}
-	}
-
-	err = app.store.BulkUpdate(r.Context(), counters, gauges)
+	err = app.updateMetricsBatch(r.Context(), m, r.Header.Get(schema.BatchSignatureHeader))
 	if err != nil {
 		return err
 	}
@@ -311,7 +296,7 @@ func (app *App) updateValuesJSON(w http.ResponseWriter, r *http.Request) error {
 		//
 		// There is no description, how I have to choose this single value,
 		// so I just send the first one.
-		value := values[0]
+		value := withQuantiles(values[0])
 		if app.key != "" {
 			if err = value.Sign(app.key); err != nil {
 				return err
@@ -327,10 +312,7 @@ func (app *App) updateValuesJSON(w http.ResponseWriter, r *http.Request) error {
 		w.WriteHeader(http.StatusOK)
 	}
 
-	if app.sync {
-		// safe dump does not depend on request, so we use background context
-		go app.safeDump(context.Background())
-	}
+	app.requestDump()
 	return nil
 }
 
@@ -353,30 +335,276 @@ func (app *App) retrieveValueJSON(w http.ResponseWriter, r *http.Request) error
 		return ValidationError(err.Error())
 	}
 
-	var value schema.Metrics
+	value, err := app.retrieveMetrics(r.Context(), m)
+	if err != nil {
+		return err
+	}
+
+	serialized, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	SafeWrite(w, http.StatusOK, string(serialized))
+	return nil
+}
+
+// updateMetrics validates and stores single metrics,
+// stored value is returned in the form it should be sent to the client
+func (app *App) updateMetrics(ctx context.Context, m schema.Metrics) (schema.Metrics, error) {
+	if m.MType == schema.MetricsTypeCounter && m.Delta == nil || m.MType == schema.MetricsTypeGauge && m.Value == nil {
+		return schema.Metrics{}, ValidationError("Missing Value")
+	}
+
+	err := validateDistribution(m)
+	if err != nil {
+		return schema.Metrics{}, err
+	}
+
+	if err = app.authorizeWrite(ctx, m.ID); err != nil {
+		return schema.Metrics{}, err
+	}
+
+	if app.key != "" {
+		signed, err_ := m.IsSignedWithKey(app.key)
+		if err_ != nil {
+			return schema.Metrics{}, err_
+		}
+		if !signed {
+			return schema.Metrics{}, ValidationError("signature mismatch")
+		}
+	}
+	// nonce is released if metrics is not stored, so the agent could retry
+	release, err := app.reserveNonces([]schema.Metrics{m})
+	if err != nil {
+		return schema.Metrics{}, err
+	}
+	m = app.withIdentity(ctx, m.Unstamped())
+
 	switch m.MType {
 	case schema.MetricsTypeCounter:
+		err = app.store.Increment(ctx, m, *m.Delta)
+		switch err.(type) {
+		case *storage.NotFound:
+			err = app.store.Put(ctx, m)
+		}
 	case schema.MetricsTypeGauge:
+		err = app.store.Put(ctx, m)
+	case schema.MetricsTypeHistogram:
+		err = app.store.BulkUpdate(ctx, nil, nil, []schema.Metrics{m}, nil)
+	case schema.MetricsTypeSummary:
+		err = app.store.BulkUpdate(ctx, nil, nil, nil, []schema.Metrics{m})
 	default:
-		return &requestError{
+		err = &requestError{
 			status: http.StatusNotImplemented,
 			body:   fmt.Sprintf("Could not perform requested operation on metric type %s", m.MType),
 		}
 	}
 
-	value, err = app.store.Extract(r.Context(), m)
+	if err != nil {
+		release()
+		return schema.Metrics{}, err
+	}
+
+	return app.retrieveMetrics(ctx, m)
+}
+
+// updateMetricsBatch validates all the metrics and stores them in a single bulk update.
+// If the batch signature is set, it is checked instead of per-metrics hashes
+func (app *App) updateMetricsBatch(ctx context.Context, l []schema.Metrics, signature string) error {
+	var counters []schema.Metrics
+	var gauges []schema.Metrics
+	var histograms []schema.Metrics
+	var summaries []schema.Metrics
+
+	batchSigned := false
+	if app.key != "" && signature != "" {
+		signed, err := schema.IsBatchSignedWithKey(l, app.key, signature)
+		if err != nil {
+			return err
+		}
+		if !signed {
+			return ValidationError("batch signature mismatch")
+		}
+		batchSigned = true
+	}
+
+	for _, item := range l {
+		if err := app.authorizeWrite(ctx, item.ID); err != nil {
+			return err
+		}
+		// legacy agents sign every metrics separately
+		if app.key != "" && !batchSigned {
+			signed, err := item.IsSignedWithKey(app.key)
+			if err != nil {
+				return err
+			}
+			if !signed {
+				return ValidationError("signature mismatch")
+			}
+		}
+		item = app.withIdentity(ctx, item.Unstamped())
+		switch item.MType {
+		case schema.MetricsTypeCounter:
+			counters = append(counters, item)
+		case schema.MetricsTypeGauge:
+			gauges = append(gauges, item)
+		case schema.MetricsTypeHistogram:
+			if err := validateDistribution(item); err != nil {
+				return err
+			}
+			histograms = append(histograms, item)
+		case schema.MetricsTypeSummary:
+			if err := validateDistribution(item); err != nil {
+				return err
+			}
+			summaries = append(summaries, item)
+		default:
+			return &requestError{
+				status: http.StatusNotImplemented,
+				body:   fmt.Sprintf("Could not perform requested operation on metric type %s", item.MType),
+			}
+		}
+	}
+
+	release, err := app.reserveNonces(l)
 	if err != nil {
 		return err
 	}
+	err = app.store.BulkUpdate(ctx, counters, gauges, histograms, summaries)
+	if err != nil {
+		release()
+	}
+	return err
+}
+
+// reserveNonces protects signed metrics from replay, returned function releases reserved nonces
+func (app *App) reserveNonces(l []schema.Metrics) (func(), error) {
+	if app.key == "" || app.replay == nil {
+		return func() {}, nil
+	}
+	return app.replay.reserve(l)
+}
+
+// retrieveMetrics extracts stored value in the form it should be sent to the client
+func (app *App) retrieveMetrics(ctx context.Context, m schema.Metrics) (schema.Metrics, error) {
+	switch m.MType {
+	case schema.MetricsTypeCounter:
+	case schema.MetricsTypeGauge:
+	case schema.MetricsTypeHistogram:
+	case schema.MetricsTypeSummary:
+	default:
+		return schema.Metrics{}, &requestError{
+			status: http.StatusNotImplemented,
+			body:   fmt.Sprintf("Could not perform requested operation on metric type %s", m.MType),
+		}
+	}
+
+	value, err := app.store.Extract(ctx, m)
+	if err != nil {
+		return schema.Metrics{}, err
+	}
+	value = withQuantiles(value)
 
 	if app.key != "" {
 		err = value.Sign(app.key)
 		if err != nil {
-			return err
+			return schema.Metrics{}, err
 		}
 	}
+	return value, nil
+}
 
-	serialized, err := json.Marshal(value)
+// validateDistribution checks that histogram or summary in request is consistent,
+// other metrics types are not checked
+func validateDistribution(m schema.Metrics) error {
+	switch m.MType {
+	case schema.MetricsTypeHistogram:
+		if m.Histogram == nil {
+			return ValidationError("Missing Value")
+		}
+		if err := m.Histogram.Validate(); err != nil {
+			return ValidationError(err.Error())
+		}
+	case schema.MetricsTypeSummary:
+		if m.Summary == nil {
+			return ValidationError("Missing Value")
+		}
+		if len(m.Summary.Quantiles) != 0 {
+			return ValidationError("Quantiles are calculated by server and should not be submitted")
+		}
+		if err := m.Summary.Validate(); err != nil {
+			return ValidationError(err.Error())
+		}
+	}
+	return nil
+}
+
+// withQuantiles fills summary quantiles for the response,
+// stored value is left intact
+func withQuantiles(m schema.Metrics) schema.Metrics {
+	if m.MType == schema.MetricsTypeSummary && m.Summary != nil {
+		s := m.Summary.WithQuantiles(schema.DefaultSummaryQuantiles...)
+		m.Summary = &s
+	}
+	return m
+}
+
+type historyRequest struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	schema.Metrics
+}
+
+func (app *App) retrieveHistoryJSON(w http.ResponseWriter, r *http.Request) error {
+	history, ok := app.store.(storage.MetricsHistory)
+	if !ok {
+		return &requestError{
+			status: http.StatusNotImplemented,
+			body:   "History is not retained by the storage",
+		}
+	}
+
+	if r.Body == nil {
+		return ValidationError("empty body")
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return ValidationError(err.Error())
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var req historyRequest
+	err = decoder.Decode(&req)
+	if err != nil {
+		return ValidationError(err.Error())
+	}
+
+	if req.To.IsZero() {
+		req.To = time.Now()
+	}
+	if req.To.Before(req.From) {
+		return ValidationError("Invalid time range")
+	}
+
+	samples, err := history.History(r.Context(), req.Metrics, req.From, req.To)
+	if err != nil {
+		return err
+	}
+
+	for i := range samples {
+		samples[i].Metrics = withQuantiles(samples[i].Metrics)
+		if app.key != "" {
+			if err = samples[i].Sign(app.key); err != nil {
+				return err
+			}
+		}
+	}
+
+	serialized, err := json.Marshal(samples)
 	if err != nil {
 		return err
 	}
@@ -385,28 +613,69 @@ func (app *App) retrieveValueJSON(w http.ResponseWriter, r *http.Request) error
 	return nil
 }
 
-func (app *App) ping(w http.ResponseWriter, r *http.Request) error {
-	err := app.store.Ping(r.Context())
-	log.Printf("Ping result: %v", err)
+func (app *App) declareMetadataJSON(w http.ResponseWriter, r *http.Request) error {
+	if r.Body == nil {
+		return ValidationError("empty body")
+	}
+
+	data, err := io.ReadAll(r.Body)
 	if err != nil {
 		return err
 	}
-	SafeWrite(w, http.StatusOK, "Status: OK")
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var l []schema.Metadata
+	err = decoder.Decode(&l)
+	if err != nil {
+		return ValidationError(err.Error())
+	}
+
+	for _, meta := range l {
+		if err = meta.Validate(); err != nil {
+			return ValidationError(err.Error())
+		}
+		if err = app.authorizeWrite(r.Context(), meta.ID); err != nil {
+			return err
+		}
+	}
+
+	err = app.store.PutMetadata(r.Context(), l)
+	if err != nil {
+		return err
+	}
+
+	SafeWrite(w, http.StatusOK, `{"status": "OK"}`)
 	return nil
 }
 
-func (app *App) safeDump(ctx context.Context) {
-	log.Print("Dumping current storage state...")
-	l, err := app.store.List(ctx)
+func (app *App) listMetadataJSON(w http.ResponseWriter, r *http.Request) error {
+	l, err := app.store.ListMetadata(r.Context())
 	if err != nil {
-		log.Print("Could not retrieve values from storage")
-		return
+		return err
+	}
+	if l == nil {
+		l = []schema.Metadata{}
 	}
 
-	err = app.dumper.Dump(l)
+	serialized, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	SafeWrite(w, http.StatusOK, string(serialized))
+	return nil
+}
+
+func (app *App) ping(w http.ResponseWriter, r *http.Request) error {
+	err := app.store.Ping(r.Context())
+	log.Printf("Ping result: %v", err)
 	if err != nil {
-		log.Print("Could not write storage data")
+		return err
 	}
+	SafeWrite(w, http.StatusOK, "Status: OK")
+	return nil
 }
 
 func NewApp(
@@ -420,6 +689,7 @@ func NewApp(
 	app.sync = false
 	app.decryptor = crypt.NoOpDecryptor{}
 	app.key = ""
+	app.realIPHeader = "X-Real-IP"
 
 	// useful middlewares
 	r.Use(middleware.RequestID)
@@ -427,14 +697,24 @@ func NewApp(
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Timeout(60 * time.Second))
 	r.Use(middleware.Compress(5))
-
-	r.With(middleware.SetHeader("Content-Type", "text/plain")).Post("/update/{Type}/{Name}/{Value}", app.newHandler(app.updateValue))
-	r.With(middleware.SetHeader("Content-Type", "text/plain")).Get("/value/{Type}/{Name}", app.newHandler(app.retrieveValue))
-	r.With(middleware.SetHeader("Content-Type", "application/json")).Post("/update/", app.newHandler(app.updateValueJSON))
-	r.With(middleware.SetHeader("Content-Type", "application/json")).Post("/updates/", app.newHandler(app.updateValuesJSON))
-	r.With(middleware.SetHeader("Content-Type", "application/json")).Post("/value/", app.newHandler(app.retrieveValueJSON))
-	r.With(middleware.SetHeader("Content-Type", "text/plain")).Get("/ping", app.newHandler(app.ping))
-	r.With(middleware.SetHeader("Content-Type", "text/html")).Get("/", app.newHandler(app.listMetrics))
+	r.Use(app.identify)
+
+	r.With(app.trustedOnly, middleware.SetHeader("Content-Type", "text/plain")).Post("/update/{Type}/{Name}/{Value}", app.newHandler(schema.TokenScopeWrite, app.updateValue))
+	r.With(middleware.SetHeader("Content-Type", "text/plain")).Get("/value/{Type}/{Name}", app.newHandler(schema.TokenScopeRead, app.retrieveValue))
+	r.With(app.trustedOnly, middleware.SetHeader("Content-Type", "application/json")).Post("/update/", app.newHandler(schema.TokenScopeWrite, app.updateValueJSON))
+	r.With(app.trustedOnly, middleware.SetHeader("Content-Type", "application/json")).Post("/updates/", app.newHandler(schema.TokenScopeWrite, app.updateValuesJSON))
+	r.With(middleware.SetHeader("Content-Type", "application/json")).Post("/value/", app.newHandler(schema.TokenScopeRead, app.retrieveValueJSON))
+	r.With(middleware.SetHeader("Content-Type", "application/json")).Post("/history/", app.newHandler(schema.TokenScopeRead, app.retrieveHistoryJSON))
+	r.With(app.trustedOnly, middleware.SetHeader("Content-Type", "application/json")).Post("/metadata/", app.newHandler(schema.TokenScopeWrite, app.declareMetadataJSON))
+	r.With(middleware.SetHeader("Content-Type", "application/json")).Get("/metadata/", app.newHandler(schema.TokenScopeRead, app.listMetadataJSON))
+	r.With(middleware.SetHeader("Content-Type", "application/json")).Get("/stats/dump", app.newHandler(schema.TokenScopeRead, app.dumpStatsJSON))
+	r.With(middleware.SetHeader("Content-Type", "text/plain")).Get("/ping", app.newHandler(schema.TokenScopeRead, app.ping))
+	r.With(middleware.SetHeader("Content-Type", prometheusContentType)).Get("/metrics", app.newHandler(schema.TokenScopeRead, app.exportPrometheus))
+	r.With(app.trustedOnly, middleware.SetHeader("Content-Type", "text/plain")).Post("/api/v1/write", app.newPlainHandler(schema.TokenScopeWrite, app.remoteWrite))
+	r.With(middleware.SetHeader("Content-Type", "application/json")).Post("/admin/tokens/", app.newHandler(schema.TokenScopeAdmin, app.createTokenJSON))
+	r.With(middleware.SetHeader("Content-Type", "application/json")).Get("/admin/tokens/", app.newHandler(schema.TokenScopeAdmin, app.listTokensJSON))
+	r.With(middleware.SetHeader("Content-Type", "application/json")).Delete("/admin/tokens/{Name}", app.newHandler(schema.TokenScopeAdmin, app.deleteTokenJSON))
+	r.With(middleware.SetHeader("Content-Type", "text/html")).Get("/", app.newHandler(schema.TokenScopeRead, app.listMetrics))
 
 	return app
 }
@@ -444,7 +724,13 @@ func (app *App) WithDumper(d dumper.Dumper) *App {
 	return app
 }
 
+// WithDumpInterval starts background dumps, the state is dumped after every update if interval is zero.
+// Dumps are performed one at a time, so App.Close should be called to flush the final state
 func (app *App) WithDumpInterval(interval time.Duration) *App {
+	app.dumps = newAsyncDumper(func() error {
+		// dump does not depend on request, so we use background context
+		return app.dump(context.Background())
+	})
 	if interval == 0 {
 		app.sync = true
 		return app
@@ -455,7 +741,7 @@ func (app *App) WithDumpInterval(interval time.Duration) *App {
 	go func() {
 		for {
 			<-t.C
-			app.safeDump(context.Background())
+			app.dumps.request()
 		}
 	}()
 
@@ -467,6 +753,51 @@ func (app *App) WithKey(key string) *App {
 	return app
 }
 
+// WithClientIdentity configures usage of client certificate CN:
+// it is attached to submitted metrics as label (if label is not empty)
+// and only clients from allowed list are served (if the list is not empty)
+func (app *App) WithClientIdentity(label string, allowed []string) *App {
+	app.identityLabel = label
+	app.allowedClients = nil
+	if len(allowed) > 0 {
+		app.allowedClients = make(map[string]struct{}, len(allowed))
+		for _, name := range allowed {
+			app.allowedClients[name] = struct{}{}
+		}
+	}
+	return app
+}
+
+// WithTrustedSubnets allows updates only from the specified subnets,
+// client address is taken from the header, which is set by agent or reverse proxy
+func (app *App) WithTrustedSubnets(header string, subnets []*net.IPNet) *App {
+	if header != "" {
+		app.realIPHeader = header
+	}
+	app.trustedSubnets = subnets
+	return app
+}
+
+// WithAdminToken makes API tokens required, token with the secret has admin scope
+// and could be used to manage other tokens (tokens are not required if secret is empty)
+func (app *App) WithAdminToken(secret string) *App {
+	app.adminTokenHash = ""
+	if secret != "" {
+		app.adminTokenHash = schema.HashTokenSecret(secret)
+	}
+	return app
+}
+
+// WithReplayWindow makes signed metrics acceptable only within the window from signing time
+// and only once (protection is disabled if window is zero)
+func (app *App) WithReplayWindow(window time.Duration) *App {
+	app.replay = nil
+	if window > 0 {
+		app.replay = newReplayGuard(window)
+	}
+	return app
+}
+
 func (app *App) WithDecryptor(decryptor crypt.Decryptor) *App {
 	app.decryptor = decryptor
 	return app
@@ -9,9 +9,13 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"logogger/internal/schema"
 	"logogger/internal/storage"
@@ -254,6 +258,272 @@ func TestApp_UpdateValueJson(t *testing.T) {
 	}
 }
 
+func TestApp_UpdateValueJSONLabels(t *testing.T) {
+	store := storage.NewMemStorage()
+	app := NewApp(store)
+
+	hostA := map[string]string{"host": "a"}
+	hostB := map[string]string{"host": "b"}
+	requests := []schema.Metrics{
+		schema.NewCounter("cntID", 42).WithLabels(hostA),
+		schema.NewCounter("cntID", 13).WithLabels(hostB),
+		schema.NewCounter("cntID", 1).WithLabels(hostA),
+	}
+
+	for _, m := range requests {
+		serialized, err := json.Marshal(m)
+		assert.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, "/update/", bytes.NewBuffer(serialized))
+		assert.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		app.Router.ServeHTTP(recorder, req)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	}
+
+	params := [...]struct {
+		labels map[string]string
+		delta  int64
+	}{
+		{hostA, 43},
+		{hostB, 13},
+	}
+
+	for _, param := range params {
+		serialized, err := json.Marshal(schema.NewCounterRequest("cntID").WithLabels(param.labels))
+		assert.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, "/value/", bytes.NewBuffer(serialized))
+		assert.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		app.Router.ServeHTTP(recorder, req)
+
+		var actual schema.Metrics
+		err = json.NewDecoder(recorder.Body).Decode(&actual)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, param.labels, actual.Labels)
+		assert.Equal(t, param.delta, *actual.Delta)
+	}
+
+	serialized, err := json.Marshal(schema.NewCounterRequest("cntID"))
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, "/value/", bytes.NewBuffer(serialized))
+	assert.NoError(t, err)
+	recorder := httptest.NewRecorder()
+	app.Router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestApp_UpdateValueJSONHistogram(t *testing.T) {
+	store := storage.NewMemStorage()
+	app := NewApp(store)
+
+	valid := schema.NewHistogram("hID", []float64{1, 2})
+	valid.Histogram.Observe(1.5)
+	invalid := schema.NewHistogram("hID", []float64{1, 2})
+	invalid.Histogram.Count = 42
+	otherBounds := schema.NewHistogram("hID", []float64{1, 3})
+
+	params := [...]struct {
+		data schema.Metrics
+		code int
+	}{
+		{valid, http.StatusOK},
+		{valid, http.StatusOK},
+		{schema.NewHistogramRequest("hID"), http.StatusBadRequest},
+		{invalid, http.StatusBadRequest},
+		{otherBounds, http.StatusConflict},
+	}
+
+	for _, param := range params {
+		serialized, err := json.Marshal(param.data)
+		assert.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, "/update/", bytes.NewBuffer(serialized))
+		assert.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		app.Router.ServeHTTP(recorder, req)
+
+		assert.Equal(t, param.code, recorder.Code)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "/update/histogram/latency/0.3", nil)
+	assert.NoError(t, err)
+	recorder := httptest.NewRecorder()
+	app.Router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	stored, err := store.Extract(context.Background(), schema.NewHistogramRequest("hID"))
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{0, 2, 0}, stored.Histogram.Counts)
+
+	stored, err = store.Extract(context.Background(), schema.NewHistogramRequest("latency"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), stored.Histogram.Count)
+}
+
+func TestApp_UpdateValuesJSONSummary(t *testing.T) {
+	store := storage.NewMemStorage()
+	app := NewApp(store)
+
+	var samples []float64
+	for i := 1; i <= 100; i++ {
+		samples = append(samples, float64(i))
+	}
+	pushed := schema.NewSummary("latency", schema.DefaultSummaryAccuracy)
+	pushed.Summary.Samples = samples
+
+	serialized, err := json.Marshal([]schema.Metrics{pushed})
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, "/updates/", bytes.NewBuffer(serialized))
+	assert.NoError(t, err)
+	recorder := httptest.NewRecorder()
+	app.Router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	serialized, err = json.Marshal(schema.NewSummaryRequest("latency"))
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, "/value/", bytes.NewBuffer(serialized))
+	assert.NoError(t, err)
+	recorder = httptest.NewRecorder()
+	app.Router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var actual schema.Metrics
+	err = json.NewDecoder(recorder.Body).Decode(&actual)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), actual.Summary.Count)
+	assert.InDelta(t, 50, actual.Summary.Quantiles["0.5"], 1)
+	assert.InDelta(t, 90, actual.Summary.Quantiles["0.9"], 1)
+	assert.InDelta(t, 99, actual.Summary.Quantiles["0.99"], 1)
+
+	// quantiles are calculated by server only
+	serialized, err = json.Marshal([]schema.Metrics{actual})
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, "/updates/", bytes.NewBuffer(serialized))
+	assert.NoError(t, err)
+	recorder = httptest.NewRecorder()
+	app.Router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	req, err = http.NewRequest(http.MethodGet, "/value/summary/latency", nil)
+	assert.NoError(t, err)
+	recorder = httptest.NewRecorder()
+	app.Router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "count=100")
+	assert.Contains(t, recorder.Body.String(), "p99=")
+}
+
+func TestApp_RetrieveHistoryJSON(t *testing.T) {
+	store := storage.NewHistoryStorage(storage.NewMemStorage(), 0, 10)
+	app := NewApp(store)
+	start := time.Now()
+
+	for _, v := range []float64{1, 2, 3} {
+		err := store.Put(context.Background(), schema.NewGauge("ggID", v))
+		assert.NoError(t, err)
+	}
+
+	params := [...]struct {
+		body string
+		code int
+		len  int
+	}{
+		{fmt.Sprintf(`{"id": "ggID", "type": "gauge", "from": "%s"}`, start.Format(time.RFC3339Nano)), http.StatusOK, 3},
+		{`{"id": "ggID", "type": "gauge", "to": "2000-01-01T00:00:00Z"}`, http.StatusOK, 0},
+		{`{"id": "ggID", "type": "gauge", "from": "2000-01-01T00:00:00Z", "to": "1999-01-01T00:00:00Z"}`, http.StatusBadRequest, 0},
+		{`{"id": "ggID", "type": "counter"}`, http.StatusConflict, 0},
+		{`{"id": "nonExistent", "type": "gauge"}`, http.StatusNotFound, 0},
+		{`{"id": "ggID", "type": "gauge", "until": "now"}`, http.StatusBadRequest, 0},
+	}
+
+	for _, param := range params {
+		req, err := http.NewRequest(http.MethodPost, "/history/", bytes.NewBufferString(param.body))
+		assert.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		app.Router.ServeHTTP(recorder, req)
+
+		assert.Equal(t, param.code, recorder.Code)
+		if param.code != http.StatusOK {
+			continue
+		}
+		var samples []schema.Sample
+		err = json.NewDecoder(recorder.Body).Decode(&samples)
+		assert.NoError(t, err)
+		assert.Len(t, samples, param.len)
+		for i, sample := range samples {
+			assert.Equal(t, float64(i+1), *sample.Value)
+			assert.Equal(t, "ggID", sample.ID)
+		}
+	}
+}
+
+func TestApp_RetrieveHistoryJSONNotRetained(t *testing.T) {
+	app := NewApp(storage.NewMemStorage())
+
+	req, err := http.NewRequest(http.MethodPost, "/history/", bytes.NewBufferString(`{"id": "ggID", "type": "gauge"}`))
+	assert.NoError(t, err)
+	recorder := httptest.NewRecorder()
+	app.Router.ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusNotImplemented, recorder.Code)
+}
+
+func TestApp_Metadata(t *testing.T) {
+	store := storage.NewMemStorage()
+	app := NewApp(store)
+
+	params := [...]struct {
+		body string
+		code int
+	}{
+		{`[{"id": "HeapAlloc", "type": "gauge", "unit": "bytes", "help": "Bytes of <allocated> heap objects"}]`, http.StatusOK},
+		{`[{"id": "HeapAlloc", "type": "statistics"}]`, http.StatusBadRequest},
+		{`[{"type": "gauge"}]`, http.StatusBadRequest},
+		{`{"id": "HeapAlloc"}`, http.StatusBadRequest},
+	}
+
+	for _, param := range params {
+		req, err := http.NewRequest(http.MethodPost, "/metadata/", bytes.NewBufferString(param.body))
+		assert.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		app.Router.ServeHTTP(recorder, req)
+		assert.Equal(t, param.code, recorder.Code)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/metadata/", nil)
+	assert.NoError(t, err)
+	recorder := httptest.NewRecorder()
+	app.Router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var l []schema.Metadata
+	err = json.NewDecoder(recorder.Body).Decode(&l)
+	assert.NoError(t, err)
+	assert.Equal(t, []schema.Metadata{{ID: "HeapAlloc", MType: schema.MetricsTypeGauge, Unit: "bytes", Help: "Bytes of <allocated> heap objects"}}, l)
+
+	// declared type is enforced
+	req, err = http.NewRequest(http.MethodPost, "/update/counter/HeapAlloc/1", nil)
+	assert.NoError(t, err)
+	recorder = httptest.NewRecorder()
+	app.Router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+
+	req, err = http.NewRequest(http.MethodPost, "/update/gauge/HeapAlloc/1024", nil)
+	assert.NoError(t, err)
+	recorder = httptest.NewRecorder()
+	app.Router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	// and metadata is shown on the main page
+	req, err = http.NewRequest(http.MethodGet, "/", nil)
+	assert.NoError(t, err)
+	recorder = httptest.NewRecorder()
+	app.Router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "<td>bytes</td>")
+	assert.Contains(t, recorder.Body.String(), "Bytes of &lt;allocated&gt; heap objects")
+}
+
 func TestApp_UpdateValueJSON_WrongType(t *testing.T) {
 	store := storage.NewMemStorage()
 	app := NewApp(store)
@@ -526,7 +796,31 @@ func (faultyStorage) BulkPut(_ context.Context, metrics []schema.Metrics) error
 	return errors.New("generic error")
 }
 
-func (faultyStorage) BulkUpdate(_ context.Context, counters []schema.Metrics, gauges []schema.Metrics) error {
+func (faultyStorage) BulkUpdate(_ context.Context, counters []schema.Metrics, gauges []schema.Metrics, histograms []schema.Metrics, summaries []schema.Metrics) error {
+	return errors.New("generic error")
+}
+
+func (faultyStorage) PutMetadata(_ context.Context, values []schema.Metadata) error {
+	return errors.New("generic error")
+}
+
+func (faultyStorage) ListMetadata(_ context.Context) ([]schema.Metadata, error) {
+	return nil, errors.New("generic error")
+}
+
+func (faultyStorage) PutToken(_ context.Context, token schema.Token) error {
+	return errors.New("generic error")
+}
+
+func (faultyStorage) ExtractToken(_ context.Context, hash string) (schema.Token, error) {
+	return schema.Token{}, errors.New("generic error")
+}
+
+func (faultyStorage) ListTokens(_ context.Context) ([]schema.Token, error) {
+	return nil, errors.New("generic error")
+}
+
+func (faultyStorage) DeleteToken(_ context.Context, name string) error {
 	return errors.New("generic error")
 }
 
@@ -537,3 +831,77 @@ func (faultyStorage) Ping(_ context.Context) error {
 func (faultyStorage) Close() error {
 	return nil
 }
+
+func TestApp_BatchSignature(t *testing.T) {
+	key := "secret"
+	app := NewApp(storage.NewMemStorage()).WithKey(key)
+	l := []schema.Metrics{schema.NewCounter("PollCount", 1), schema.NewGauge("Alloc", 13.37)}
+	signature, err := schema.SignBatch(l, key)
+	require.NoError(t, err)
+
+	legacy := make([]schema.Metrics, len(l))
+	for i, m := range l {
+		require.NoError(t, m.Sign(key))
+		legacy[i] = m
+	}
+
+	params := []struct {
+		name      string
+		l         []schema.Metrics
+		signature string
+		code      int
+	}{
+		{"batch signature", l, signature, http.StatusOK},
+		{"wrong batch signature", l, strings.Repeat("0", len(signature)), http.StatusBadRequest},
+		{"legacy signature", legacy, "", http.StatusOK},
+		{"wrong batch signature with legacy signature", legacy, strings.Repeat("0", len(signature)), http.StatusBadRequest},
+		{"unsigned", l, "", http.StatusBadRequest},
+	}
+	for _, param := range params {
+		t.Run(param.name, func(t *testing.T) {
+			// formatting of the body is not covered by signature
+			serialized, err := json.MarshalIndent(param.l, "", "  ")
+			require.NoError(t, err)
+			req, err := http.NewRequest(http.MethodPost, "/updates/", bytes.NewBuffer(serialized))
+			require.NoError(t, err)
+			if param.signature != "" {
+				req.Header.Set(schema.BatchSignatureHeader, param.signature)
+			}
+			recorder := httptest.NewRecorder()
+			app.Router.ServeHTTP(recorder, req)
+			assert.Equal(t, param.code, recorder.Code, recorder.Body.String())
+		})
+	}
+}
+
+type recordingDumper struct {
+	dumped []schema.Metrics
+}
+
+func (d *recordingDumper) Dump(l []schema.Metrics) error {
+	d.dumped = l
+	return nil
+}
+
+func (d *recordingDumper) Close() error {
+	return nil
+}
+
+func TestApp_DumpCompactsWAL(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := storage.OpenWAL(dir, 0)
+	require.NoError(t, err)
+	store := storage.NewMemStorage().WithWAL(wal)
+	defer store.Close()
+	d := &recordingDumper{}
+	app := NewApp(store).WithDumper(d)
+
+	require.NoError(t, store.Put(context.Background(), schema.NewCounter("PollCount", 1)))
+	require.NoError(t, app.dump(context.Background()))
+	assert.Equal(t, []schema.Metrics{schema.NewCounter("PollCount", 1)}, d.dumped)
+
+	// the segment with dumped update is removed, the current one is left
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"logogger/internal/schema"
+)
+
+// ParseSubnets parses comma separated list of CIDR ranges
+func ParseSubnets(raw string) ([]*net.IPNet, error) {
+	var subnets []*net.IPNet
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet %q: %w", item, err)
+		}
+		subnets = append(subnets, subnet)
+	}
+	return subnets, nil
+}
+
+// isTrusted checks the address reported by the client against trusted subnets
+func (app *App) isTrusted(address string) bool {
+	ip := net.ParseIP(strings.TrimSpace(address))
+	if ip == nil {
+		return false
+	}
+	for _, subnet := range app.trustedSubnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (app *App) untrusted() error {
+	return &requestError{
+		status: http.StatusForbidden,
+		body:   fmt.Sprintf("Address in %s header is not in trusted subnet", app.realIPHeader),
+	}
+}
+
+// trustedOnly is a middleware, which rejects requests from outside of trusted subnets (if they are set)
+func (app *App) trustedOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(app.trustedSubnets) > 0 && !app.isTrusted(r.Header.Get(app.realIPHeader)) {
+			WriteError(w, app.untrusted())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// grpcClientAddress returns address reported in metadata under the same header as over HTTP,
+// address of the peer is used if the client does not report it
+func (app *App) grpcClientAddress(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(app.realIPHeader); len(values) > 0 {
+		return values[0]
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+	}
+	return ""
+}
+
+// checkTrusted rejects gRPC updates from outside of trusted subnets (if they are set), reads are not restricted
+func (app *App) checkTrusted(ctx context.Context, fullMethod string) error {
+	if len(app.trustedSubnets) == 0 || grpcScope(fullMethod) != schema.TokenScopeWrite {
+		return nil
+	}
+	if !app.isTrusted(app.grpcClientAddress(ctx)) {
+		return app.untrusted()
+	}
+	return nil
+}
+
+func (app *App) unaryTrustedOnly(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := app.checkTrusted(ctx, info.FullMethod); err != nil {
+		return nil, grpcError(err)
+	}
+	return handler(ctx, req)
+}
+
+func (app *App) streamTrustedOnly(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := app.checkTrusted(ss.Context(), info.FullMethod); err != nil {
+		return grpcError(err)
+	}
+	return handler(srv, ss)
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"logogger/internal/storage"
+)
+
+func TestParseSubnets(t *testing.T) {
+	subnets, err := ParseSubnets("10.0.0.0/8, 192.168.1.0/24,")
+	require.NoError(t, err)
+	assert.Len(t, subnets, 2)
+
+	subnets, err = ParseSubnets("")
+	assert.NoError(t, err)
+	assert.Empty(t, subnets)
+
+	_, err = ParseSubnets("10.0.0.1")
+	assert.Error(t, err)
+}
+
+func TestApp_TrustedSubnets(t *testing.T) {
+	subnets, err := ParseSubnets("10.0.0.0/8")
+	require.NoError(t, err)
+	app := NewApp(storage.NewMemStorage()).WithTrustedSubnets("X-Real-IP", subnets)
+
+	params := []struct {
+		method string
+		url    string
+		body   string
+		ip     string
+		code   int
+	}{
+		{http.MethodPost, "/update/counter/PollCount/1", "", "10.1.2.3", http.StatusOK},
+		{http.MethodPost, "/update/counter/PollCount/1", "", "192.168.1.1", http.StatusForbidden},
+		{http.MethodPost, "/update/counter/PollCount/1", "", "", http.StatusForbidden},
+		{http.MethodPost, "/update/", `{"id":"PollCount","type":"counter","delta":1}`, "garbage", http.StatusForbidden},
+		{http.MethodPost, "/updates/", `[{"id":"PollCount","type":"counter","delta":1}]`, "10.0.0.1", http.StatusOK},
+		{http.MethodPost, "/updates/", `[{"id":"PollCount","type":"counter","delta":1}]`, "11.0.0.1", http.StatusForbidden},
+		{http.MethodPost, "/metadata/", `[{"id":"PollCount","type":"counter"}]`, "10.0.0.1", http.StatusOK},
+		{http.MethodPost, "/metadata/", `[{"id":"PollCount","type":"counter"}]`, "11.0.0.1", http.StatusForbidden},
+		// body is not even read for untrusted remote-write requests
+		{http.MethodPost, "/api/v1/write", "not snappy", "10.0.0.1", http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/write", "not snappy", "11.0.0.1", http.StatusForbidden},
+		// reads are not restricted
+		{http.MethodGet, "/value/counter/PollCount", "", "", http.StatusOK},
+		{http.MethodPost, "/value/", `{"id":"PollCount","type":"counter"}`, "192.168.1.1", http.StatusOK},
+	}
+	for _, param := range params {
+		req, err := http.NewRequest(param.method, param.url, strings.NewReader(param.body))
+		require.NoError(t, err)
+		if param.ip != "" {
+			req.Header.Set("X-Real-IP", param.ip)
+		}
+		recorder := httptest.NewRecorder()
+		app.Router.ServeHTTP(recorder, req)
+		assert.Equal(t, param.code, recorder.Code, "%s %s from %s", param.method, param.url, param.ip)
+	}
+}
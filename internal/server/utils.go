@@ -3,6 +3,7 @@ package server
 import (
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
 
@@ -19,9 +20,8 @@ func SafeWrite(w http.ResponseWriter, status int, format string, args ...interfa
 	}
 }
 
-func WriteError(w http.ResponseWriter, e error) {
-	var status int
-	var error string
+// describeError returns HTTP status and message, which should be sent to the client
+func describeError(e error) (status int, error string) {
 	switch err := e.(type) {
 	case nil:
 	case *requestError:
@@ -39,10 +39,24 @@ func WriteError(w http.ResponseWriter, e error) {
 	case *storage.TypeMismatch:
 		status = http.StatusConflict
 		error = fmt.Sprintf("Requested operation on metrics %s with type %s, but actual type in storage is %s", err.ID, err.Requested, err.Stored)
+	case *storage.BoundsMismatch:
+		status = http.StatusConflict
+		error = fmt.Sprintf("Could not merge histogram %s with stored one, bounds are different", err.ID)
+	case *storage.AccuracyMismatch:
+		status = http.StatusConflict
+		error = fmt.Sprintf("Could not merge summary %s with stored one, accuracy is different", err.ID)
+	case *storage.TokenNotFound:
+		status = http.StatusNotFound
+		error = fmt.Sprintf("Could not find token %s", err.Name)
 	default:
 		status = http.StatusInternalServerError
 		error = "Internal Server Error"
 	}
+	return
+}
+
+func WriteError(w http.ResponseWriter, e error) {
+	status, error := describeError(e)
 	if w.Header().Get("Content-Type") == "application/json" {
 		error = fmt.Sprintf(`{error: "%s"}`, error)
 	}
@@ -63,7 +77,38 @@ func ParseMetric(valueType string, name string, rawValue string) (schema.Metrics
 			return schema.NewEmptyMetrics(), ValidationError(fmt.Sprintf("Could not parse float from %s", rawValue))
 		}
 		return schema.NewGauge(name, value), nil
+	case schema.MetricsTypeHistogram:
+		// plain-text API allows to submit single observation only,
+		// so default buckets layout is used
+		value, err := parseObservation(rawValue)
+		if err != nil {
+			return schema.NewEmptyMetrics(), err
+		}
+		m := schema.NewHistogram(name, schema.DefaultHistogramBounds)
+		m.Histogram.Observe(value)
+		return m, nil
+	case schema.MetricsTypeSummary:
+		value, err := parseObservation(rawValue)
+		if err != nil {
+			return schema.NewEmptyMetrics(), err
+		}
+		m := schema.NewSummary(name, schema.DefaultSummaryAccuracy)
+		m.Summary.Observe(value)
+		return m, nil
 	default:
 		return schema.NewEmptyMetrics(), &requestError{fmt.Sprintf("Could not perform requested operation on type %s", valueType), http.StatusNotImplemented}
 	}
 }
+
+// parseObservation parses value observed by histogram or summary, it should be finite,
+// otherwise the distribution could not be updated consistently
+func parseObservation(rawValue string) (float64, error) {
+	value, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil {
+		return 0, ValidationError(fmt.Sprintf("Could not parse float from %s", rawValue))
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, ValidationError(fmt.Sprintf("Observed value should be finite, got %s", rawValue))
+	}
+	return value, nil
+}
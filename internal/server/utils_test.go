@@ -52,6 +52,8 @@ func TestWriteError(t *testing.T) {
 		&storage.NotFound{ID: ""}:                                http.StatusNotFound,
 		&storage.IncrementingNonCounterMetrics{ActualType: ""}:   http.StatusNotImplemented,
 		&storage.TypeMismatch{ID: "", Requested: "", Stored: ""}: http.StatusConflict,
+		&storage.BoundsMismatch{ID: ""}:                          http.StatusConflict,
+		&storage.AccuracyMismatch{ID: ""}:                        http.StatusConflict,
 		errors.New("generic error"):                              http.StatusInternalServerError,
 	}
 	writer := okWriter{}
@@ -90,6 +92,40 @@ func TestParseMetric_InvalidGauge(t *testing.T) {
 	assert.IsType(t, &validationError{}, err)
 }
 
+func TestParseMetric_ValidHistogram(t *testing.T) {
+	expected := schema.NewHistogram("name", schema.DefaultHistogramBounds)
+	expected.Histogram.Observe(0.3)
+	actual, err := ParseMetric("histogram", "name", "0.3")
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestParseMetric_InvalidHistogram(t *testing.T) {
+	_, err := ParseMetric("histogram", "name", "leet")
+
+	assert.IsType(t, &validationError{}, err)
+}
+
+func TestParseMetric_ValidSummary(t *testing.T) {
+	expected := schema.NewSummary("name", schema.DefaultSummaryAccuracy)
+	expected.Summary.Observe(0.3)
+	actual, err := ParseMetric("summary", "name", "0.3")
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestParseMetric_NonFiniteObservation(t *testing.T) {
+	for _, valueType := range []string{"histogram", "summary"} {
+		for _, rawValue := range []string{"NaN", "+Inf", "-Inf"} {
+			_, err := ParseMetric(valueType, "name", rawValue)
+
+			assert.IsType(t, &validationError{}, err, "%s %s", valueType, rawValue)
+		}
+	}
+}
+
 func TestParseMetric_InvalidGeneric(t *testing.T) {
 	_, err := ParseMetric("generic", "name", "42")
 
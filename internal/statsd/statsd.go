@@ -0,0 +1,366 @@
+// Package statsd implements StatsD protocol listener, which aggregates received
+// metrics and periodically flushes them into the storage
+package statsd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"logogger/internal/schema"
+	"logogger/internal/storage"
+)
+
+// maxPacketSize is the maximum size of UDP datagram payload
+const maxPacketSize = 65535
+
+const (
+	typeCounter = "c"
+	typeGauge   = "g"
+	typeTimer   = "ms"
+)
+
+type sample struct {
+	labels   map[string]string
+	name     string
+	kind     string
+	value    float64
+	rate     float64
+	relative bool
+}
+
+type parseError struct {
+	line   string
+	reason string
+}
+
+func (e parseError) Error() string {
+	return fmt.Sprintf("could not parse statsd line %q: %s", e.line, e.reason)
+}
+
+// parseLine parses single line of StatsD protocol: name:value|type[|@rate][|#tag:value,...]
+func parseLine(line string) (sample, error) {
+	s := sample{rate: 1}
+
+	// tags could contain colons, so name is everything up to the last colon before the first pipe
+	pipe := strings.IndexByte(line, '|')
+	if pipe < 0 {
+		return s, parseError{line, "missing type"}
+	}
+	colon := strings.LastIndexByte(line[:pipe], ':')
+	if colon <= 0 {
+		return s, parseError{line, "expected name:value|type"}
+	}
+	s.name = line[:colon]
+
+	parts := strings.Split(line[colon+1:], "|")
+
+	raw := parts[0]
+	s.kind = parts[1]
+	switch s.kind {
+	case typeCounter, typeTimer:
+	case typeGauge:
+		s.relative = strings.HasPrefix(raw, "+") || strings.HasPrefix(raw, "-")
+	default:
+		return s, parseError{line, fmt.Sprintf("unsupported type %s", s.kind)}
+	}
+
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return s, parseError{line, fmt.Sprintf("invalid value %s", raw)}
+	}
+	s.value = value
+
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err_ := strconv.ParseFloat(part[1:], 64)
+			if err_ != nil || rate <= 0 || rate > 1 {
+				return s, parseError{line, fmt.Sprintf("invalid sample rate %s", part[1:])}
+			}
+			s.rate = rate
+		case strings.HasPrefix(part, "#"):
+			// DogStatsD style tags are mapped to labels
+			s.labels = map[string]string{}
+			for _, tag := range strings.Split(part[1:], ",") {
+				if tag == "" {
+					continue
+				}
+				k, v, _ := strings.Cut(tag, ":")
+				s.labels[k] = v
+			}
+		default:
+			return s, parseError{line, fmt.Sprintf("unexpected section %s", part)}
+		}
+	}
+
+	return s, nil
+}
+
+type gaugeState struct {
+	metrics schema.Metrics
+	delta   float64
+	// absolute is set if gauge was assigned during the interval,
+	// otherwise stored value is adjusted by delta on flush
+	absolute bool
+}
+
+// aggregator accumulates samples between flushes
+type aggregator struct {
+	counters  map[string]schema.Metrics
+	sums      map[string]float64
+	gauges    map[string]*gaugeState
+	summaries map[string]schema.Metrics
+	mu        sync.Mutex
+}
+
+func newAggregator() *aggregator {
+	a := new(aggregator)
+	a.reset()
+	return a
+}
+
+// reset should be called with the lock held
+func (a *aggregator) reset() {
+	a.counters = map[string]schema.Metrics{}
+	a.sums = map[string]float64{}
+	a.gauges = map[string]*gaugeState{}
+	a.summaries = map[string]schema.Metrics{}
+}
+
+// conflicts checks if the key is already aggregated with another type,
+// it should be called with the lock held
+func (a *aggregator) conflicts(key string, kind string) bool {
+	_, counter := a.counters[key]
+	_, gauge := a.gauges[key]
+	_, timer := a.summaries[key]
+	switch kind {
+	case typeCounter:
+		return gauge || timer
+	case typeGauge:
+		return counter || timer
+	default:
+		return counter || gauge
+	}
+}
+
+func (a *aggregator) add(s sample) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := schema.Metrics{ID: s.name, Labels: s.labels}.Key()
+	if a.conflicts(key, s.kind) {
+		// the store would reject the whole interval because of the mismatch
+		log.Printf("Dropped statsd sample %s of type %s: the name is already used with another type", key, s.kind)
+		return
+	}
+
+	switch s.kind {
+	case typeCounter:
+		m := schema.NewCounterRequest(s.name).WithLabels(s.labels)
+		a.counters[key] = m
+		// sampled counters are scaled up to estimate the real count
+		a.sums[key] += s.value / s.rate
+	case typeGauge:
+		m := schema.NewGaugeRequest(s.name).WithLabels(s.labels)
+		state, found := a.gauges[key]
+		if !found {
+			state = &gaugeState{metrics: m}
+			a.gauges[key] = state
+		}
+		if s.relative {
+			state.delta += s.value
+		} else {
+			state.absolute = true
+			state.delta = s.value
+		}
+	case typeTimer:
+		m := schema.NewSummary(s.name, schema.DefaultSummaryAccuracy).WithLabels(s.labels)
+		if prev, found := a.summaries[key]; found {
+			m = prev
+		}
+		m.Summary.Observe(s.value)
+		a.summaries[key] = m
+	}
+}
+
+// flush writes all aggregated values into the storage and starts new interval
+func (a *aggregator) flush(ctx context.Context, store storage.MetricsStorage) error {
+	a.mu.Lock()
+	counters := make([]schema.Metrics, 0, len(a.counters))
+	for key, m := range a.counters {
+		m.Delta = new(int64)
+		*m.Delta = int64(math.Round(a.sums[key]))
+		counters = append(counters, m)
+	}
+	states := a.gauges
+	summaries := make([]schema.Metrics, 0, len(a.summaries))
+	for _, m := range a.summaries {
+		summaries = append(summaries, m)
+	}
+	a.reset()
+	a.mu.Unlock()
+
+	gauges := make([]schema.Metrics, 0, len(states))
+	for _, state := range states {
+		value := state.delta
+		if !state.absolute {
+			stored, err := store.Extract(ctx, state.metrics)
+			var notFound *storage.NotFound
+			switch {
+			case errors.As(err, &notFound):
+			case err != nil:
+				// the whole interval should not be lost because of a single gauge
+				log.Printf("Could not adjust statsd gauge %s: %s", state.metrics.Key(), err)
+				continue
+			case stored.Value != nil:
+				value += *stored.Value
+			}
+		}
+		gauges = append(gauges, schema.NewGauge(state.metrics.ID, value).WithLabels(state.metrics.Labels))
+	}
+
+	if len(counters) == 0 && len(gauges) == 0 && len(summaries) == 0 {
+		return nil
+	}
+	err := store.BulkUpdate(ctx, counters, gauges, nil, summaries)
+	var mismatch *storage.TypeMismatch
+	if !errors.As(err, &mismatch) {
+		return err
+	}
+	// some name is stored with another type, the rest of the interval is written one by one
+	return writeEach(ctx, store, counters, gauges, summaries)
+}
+
+// writeEach writes metrics separately, so ones rejected due to type mismatch are skipped
+func writeEach(ctx context.Context, store storage.MetricsStorage, counters, gauges, summaries []schema.Metrics) error {
+	write := func(m schema.Metrics, err error) error {
+		var mismatch *storage.TypeMismatch
+		if errors.As(err, &mismatch) {
+			log.Printf("Dropped statsd metric %s: %s", m.Key(), err)
+			return nil
+		}
+		return err
+	}
+	for _, m := range counters {
+		if err := write(m, store.BulkUpdate(ctx, []schema.Metrics{m}, nil, nil, nil)); err != nil {
+			return err
+		}
+	}
+	for _, m := range gauges {
+		if err := write(m, store.BulkUpdate(ctx, nil, []schema.Metrics{m}, nil, nil)); err != nil {
+			return err
+		}
+	}
+	for _, m := range summaries {
+		if err := write(m, store.BulkUpdate(ctx, nil, nil, nil, []schema.Metrics{m})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type Listener struct {
+	store     storage.MetricsStorage
+	conn      net.PacketConn
+	agg       *aggregator
+	done      chan struct{}
+	wg        sync.WaitGroup
+	interval  time.Duration
+	closeOnce sync.Once
+}
+
+// Listen starts listening to StatsD metrics on the UDP address,
+// received metrics are written to the storage every interval
+func Listen(address string, store storage.MetricsStorage, interval time.Duration) (*Listener, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid flush interval %s", interval)
+	}
+
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Listener{
+		store:    store,
+		conn:     conn,
+		agg:      newAggregator(),
+		done:     make(chan struct{}),
+		interval: interval,
+	}
+	l.wg.Add(2)
+	go l.receive()
+	go l.flushPeriodically()
+	return l, nil
+}
+
+// Addr returns the address listener is bound to
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+func (l *Listener) receive() {
+	defer l.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Could not read statsd packet: %s", err)
+			continue
+		}
+
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			s, err_ := parseLine(line)
+			if err_ != nil {
+				log.Print(err_)
+				continue
+			}
+			l.agg.add(s)
+		}
+	}
+}
+
+func (l *Listener) flushPeriodically() {
+	defer l.wg.Done()
+	t := time.NewTicker(l.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := l.agg.flush(context.Background(), l.store); err != nil {
+				log.Printf("Could not flush statsd metrics: %s", err)
+			}
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// Close stops the listener and flushes metrics aggregated since the last interval
+func (l *Listener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = l.conn.Close()
+		l.wg.Wait()
+		if flushErr := l.agg.flush(context.Background(), l.store); flushErr != nil && err == nil {
+			err = flushErr
+		}
+	})
+	return err
+}
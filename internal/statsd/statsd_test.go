@@ -0,0 +1,161 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"logogger/internal/schema"
+	"logogger/internal/storage"
+)
+
+func TestParseLine(t *testing.T) {
+	params := []struct {
+		line     string
+		expected sample
+	}{
+		{"hits:1|c", sample{name: "hits", kind: typeCounter, value: 1, rate: 1}},
+		{"hits:3|c|@0.1", sample{name: "hits", kind: typeCounter, value: 3, rate: 0.1}},
+		{"queue.size:42.5|g", sample{name: "queue.size", kind: typeGauge, value: 42.5, rate: 1}},
+		{"queue.size:-5|g", sample{name: "queue.size", kind: typeGauge, value: -5, rate: 1, relative: true}},
+		{"queue.size:+5|g", sample{name: "queue.size", kind: typeGauge, value: 5, rate: 1, relative: true}},
+		{"latency:320|ms", sample{name: "latency", kind: typeTimer, value: 320, rate: 1}},
+		{
+			"latency:320|ms|@0.5|#host:web-1,url:http://x",
+			sample{name: "latency", kind: typeTimer, value: 320, rate: 0.5, labels: map[string]string{"host": "web-1", "url": "http://x"}},
+		},
+	}
+	for _, param := range params {
+		actual, err := parseLine(param.line)
+		assert.NoError(t, err, param.line)
+		assert.Equal(t, param.expected, actual, param.line)
+	}
+
+	invalid := []string{
+		"hits",
+		"hits:1",
+		":1|c",
+		"hits:one|c",
+		"hits:1|s",
+		"hits:1|c|@2",
+		"hits:1|c|garbage",
+		"hits:NaN|g",
+	}
+	for _, line := range invalid {
+		_, err := parseLine(line)
+		assert.IsType(t, parseError{}, err, line)
+	}
+}
+
+func TestAggregator_Flush(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemStorage()
+	assert.NoError(t, store.BulkPut(ctx, []schema.Metrics{
+		schema.NewCounter("hits", 10),
+		schema.NewGauge("queue", 7),
+	}))
+
+	a := newAggregator()
+	for _, line := range []string{
+		"hits:1|c",
+		"hits:1|c|@0.5",
+		"queue:+3|g",
+		"queue:-1|g",
+		"temperature:20|g",
+		"temperature:21|g",
+		"latency:10|ms",
+		"latency:30|ms",
+	} {
+		s, err := parseLine(line)
+		assert.NoError(t, err)
+		a.add(s)
+	}
+	assert.NoError(t, a.flush(ctx, store))
+
+	hits, err := store.Extract(ctx, schema.NewCounterRequest("hits"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(13), *hits.Delta)
+
+	queue, err := store.Extract(ctx, schema.NewGaugeRequest("queue"))
+	assert.NoError(t, err)
+	assert.Equal(t, 9.0, *queue.Value)
+
+	temperature, err := store.Extract(ctx, schema.NewGaugeRequest("temperature"))
+	assert.NoError(t, err)
+	assert.Equal(t, 21.0, *temperature.Value)
+
+	latency, err := store.Extract(ctx, schema.NewSummaryRequest("latency"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), latency.Summary.Count)
+	assert.Equal(t, 40.0, latency.Summary.Sum)
+
+	// nothing is written for the empty interval
+	assert.NoError(t, a.flush(ctx, store))
+	hits, err = store.Extract(ctx, schema.NewCounterRequest("hits"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(13), *hits.Delta)
+}
+
+func TestAggregator_FlushTypeMismatch(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemStorage()
+	assert.NoError(t, store.BulkPut(ctx, []schema.Metrics{schema.NewGauge("stored", 1)}))
+
+	a := newAggregator()
+	for _, line := range []string{
+		"hits:1|c",
+		// the name is already aggregated as counter in the interval
+		"hits:5|g",
+		// the name is stored as gauge
+		"stored:1|c",
+		"latency:10|ms",
+	} {
+		s, err := parseLine(line)
+		assert.NoError(t, err)
+		a.add(s)
+	}
+	assert.NoError(t, a.flush(ctx, store))
+
+	hits, err := store.Extract(ctx, schema.NewCounterRequest("hits"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), *hits.Delta)
+
+	stored, err := store.Extract(ctx, schema.NewGaugeRequest("stored"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, *stored.Value)
+
+	latency, err := store.Extract(ctx, schema.NewSummaryRequest("latency"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), latency.Summary.Count)
+}
+
+func TestListener(t *testing.T) {
+	store := storage.NewMemStorage()
+	l, err := Listen("127.0.0.1:0", store, time.Hour)
+	assert.NoError(t, err)
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	assert.NoError(t, err)
+	_, err = conn.Write([]byte("hits:2|c\nbroken line\nqueue:5|g|#host:web-1\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Close())
+
+	// wait for the datagram to be received
+	assert.Eventually(t, func() bool {
+		l.agg.mu.Lock()
+		defer l.agg.mu.Unlock()
+		return len(l.agg.counters) == 1 && len(l.agg.gauges) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// metrics are flushed on close, although the interval has not passed
+	assert.NoError(t, l.Close())
+	values, err := store.List(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []schema.Metrics{
+		schema.NewCounter("hits", 2),
+		schema.NewGauge("queue", 5).WithLabels(map[string]string{"host": "web-1"}),
+	}, values)
+}
@@ -48,3 +48,42 @@ func (err *TypeMismatch) Error() string {
 func typeMismatch(key string, requestedType schema.MetricsType, storedType schema.MetricsType) *TypeMismatch {
 	return &TypeMismatch{fmt.Errorf("expected value of type %s but got %s", requestedType, storedType), key, string(requestedType), string(storedType)}
 }
+
+type BoundsMismatch struct {
+	wrapped error
+	ID      string
+}
+
+func (err *BoundsMismatch) Error() string {
+	return err.wrapped.Error()
+}
+
+func boundsMismatch(key string) *BoundsMismatch {
+	return &BoundsMismatch{fmt.Errorf("could not merge histogram %s, stored histogram has different bounds", key), key}
+}
+
+type AccuracyMismatch struct {
+	wrapped error
+	ID      string
+}
+
+func (err *AccuracyMismatch) Error() string {
+	return err.wrapped.Error()
+}
+
+func accuracyMismatch(key string) *AccuracyMismatch {
+	return &AccuracyMismatch{fmt.Errorf("could not merge summary %s, stored summary has different accuracy", key), key}
+}
+
+type TokenNotFound struct {
+	wrapped error
+	Name    string
+}
+
+func (err *TokenNotFound) Error() string {
+	return err.wrapped.Error()
+}
+
+func tokenNotFound(name string) *TokenNotFound {
+	return &TokenNotFound{fmt.Errorf("token %s not found in the storage", name), name}
+}
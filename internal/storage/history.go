@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"logogger/internal/schema"
+)
+
+// ring is a fixed size buffer of samples, the oldest samples
+// are overwritten once buffer is full
+type ring struct {
+	samples []schema.Sample
+	start   int
+	size    int
+}
+
+func newRing(capacity int) *ring {
+	return &ring{samples: make([]schema.Sample, capacity)}
+}
+
+func (r *ring) push(s schema.Sample) {
+	end := (r.start + r.size) % len(r.samples)
+	r.samples[end] = s
+	if r.size < len(r.samples) {
+		r.size++
+	} else {
+		r.start = (r.start + 1) % len(r.samples)
+	}
+}
+
+func (r *ring) at(i int) schema.Sample {
+	return r.samples[(r.start+i)%len(r.samples)]
+}
+
+// expire drops samples older than the given moment
+func (r *ring) expire(before time.Time) {
+	for r.size > 0 && r.at(0).Timestamp.Before(before) {
+		r.samples[r.start] = schema.Sample{}
+		r.start = (r.start + 1) % len(r.samples)
+		r.size--
+	}
+}
+
+func (r *ring) between(from time.Time, to time.Time) []schema.Sample {
+	res := []schema.Sample{}
+	for i := 0; i < r.size; i++ {
+		s := r.at(i)
+		if s.Timestamp.Before(from) || s.Timestamp.After(to) {
+			continue
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+// HistoryStorage wraps any MetricsStorage and records every accepted
+// update into per-series ring buffer. History is kept in memory only,
+// series hold at most depth samples not older than retention
+// (zero retention means that samples are not expired by age).
+type HistoryStorage struct {
+	MetricsStorage
+	now       func() time.Time
+	series    map[string]*ring
+	retention time.Duration
+	depth     int
+	mu        sync.Mutex
+}
+
+// Checkpoint is delegated to the wrapped storage, history itself is not persisted
+func (s *HistoryStorage) Checkpoint() (func() error, error) {
+	if c, ok := s.MetricsStorage.(Checkpointer); ok {
+		return c.Checkpoint()
+	}
+	return func() error { return nil }, nil
+}
+
+func NewHistoryStorage(store MetricsStorage, retention time.Duration, depth int) *HistoryStorage {
+	return &HistoryStorage{
+		MetricsStorage: store,
+		now:            time.Now,
+		series:         map[string]*ring{},
+		retention:      retention,
+		depth:          depth,
+	}
+}
+
+func (h *HistoryStorage) expire(r *ring, now time.Time) {
+	if h.retention > 0 {
+		r.expire(now.Add(-h.retention))
+	}
+}
+
+// record appends current stored values of the given metrics to their series.
+// Should be called with mu held, so the order of samples corresponds to the
+// order of updates.
+func (h *HistoryStorage) record(ctx context.Context, values []schema.Metrics) error {
+	now := h.now()
+	for _, value := range values {
+		stored, err := h.MetricsStorage.Extract(ctx, value)
+		if err != nil {
+			return err
+		}
+		key := stored.Key()
+		r, found := h.series[key]
+		if !found {
+			r = newRing(h.depth)
+			h.series[key] = r
+		}
+		h.expire(r, now)
+		r.push(schema.Sample{Timestamp: now, Metrics: stored})
+	}
+	return nil
+}
+
+func (h *HistoryStorage) Put(ctx context.Context, value schema.Metrics) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	err := h.MetricsStorage.Put(ctx, value)
+	if err != nil {
+		return err
+	}
+	return h.record(ctx, []schema.Metrics{value})
+}
+
+func (h *HistoryStorage) Increment(ctx context.Context, req schema.Metrics, value int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	err := h.MetricsStorage.Increment(ctx, req, value)
+	if err != nil {
+		return err
+	}
+	return h.record(ctx, []schema.Metrics{req})
+}
+
+func (h *HistoryStorage) BulkPut(ctx context.Context, values []schema.Metrics) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	err := h.MetricsStorage.BulkPut(ctx, values)
+	if err != nil {
+		return err
+	}
+	return h.record(ctx, values)
+}
+
+func (h *HistoryStorage) BulkUpdate(ctx context.Context, counters []schema.Metrics, gauges []schema.Metrics, histograms []schema.Metrics, summaries []schema.Metrics) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	err := h.MetricsStorage.BulkUpdate(ctx, counters, gauges, histograms, summaries)
+	if err != nil {
+		return err
+	}
+
+	// the same series may be updated several times within a batch,
+	// but only the resulting value is recorded
+	var touched []schema.Metrics
+	seen := map[string]bool{}
+	for _, l := range [...][]schema.Metrics{counters, gauges, histograms, summaries} {
+		for _, m := range l {
+			if !seen[m.Key()] {
+				seen[m.Key()] = true
+				touched = append(touched, m)
+			}
+		}
+	}
+	return h.record(ctx, touched)
+}
+
+// History returns samples of the requested series recorded within [from, to] interval
+func (h *HistoryStorage) History(_ context.Context, req schema.Metrics, from time.Time, to time.Time) ([]schema.Sample, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, found := h.series[req.Key()]
+	if !found || r.size == 0 {
+		return nil, notFound(req.Key())
+	}
+	h.expire(r, h.now())
+	if r.size == 0 {
+		return nil, notFound(req.Key())
+	}
+	if latest := r.at(r.size - 1); latest.MType != req.MType {
+		return nil, typeMismatch(req.Key(), req.MType, latest.MType)
+	}
+	return r.between(from, to), nil
+}
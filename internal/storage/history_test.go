@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"logogger/internal/schema"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestHistoryStorage(retention time.Duration, depth int) (*HistoryStorage, *fakeClock) {
+	clock := &fakeClock{time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)}
+	h := NewHistoryStorage(NewMemStorage(), retention, depth)
+	h.now = clock.Now
+	return h, clock
+}
+
+func TestHistoryStorage_Record(t *testing.T) {
+	h, clock := newTestHistoryStorage(0, 10)
+	ctx := context.Background()
+	start := clock.Now()
+
+	err := h.Put(ctx, schema.NewCounter("counter", 1))
+	assert.NoError(t, err)
+	clock.Advance(time.Second)
+	err = h.Increment(ctx, schema.NewCounterRequest("counter"), 2)
+	assert.NoError(t, err)
+	clock.Advance(time.Second)
+	err = h.BulkUpdate(ctx, []schema.Metrics{schema.NewCounter("counter", 3), schema.NewCounter("counter", 4)}, nil, nil, nil)
+	assert.NoError(t, err)
+
+	samples, err := h.History(ctx, schema.NewCounterRequest("counter"), start, clock.Now())
+	assert.NoError(t, err)
+	assert.Len(t, samples, 3)
+	for i, expected := range []int64{1, 3, 10} {
+		assert.Equal(t, expected, *samples[i].Delta)
+		assert.Equal(t, start.Add(time.Duration(i)*time.Second), samples[i].Timestamp)
+	}
+
+	samples, err = h.History(ctx, schema.NewCounterRequest("counter"), start.Add(time.Second), start.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Len(t, samples, 1)
+	assert.Equal(t, int64(3), *samples[0].Delta)
+
+	_, err = h.History(ctx, schema.NewGaugeRequest("counter"), start, clock.Now())
+	assert.IsType(t, &TypeMismatch{}, err)
+	_, err = h.History(ctx, schema.NewCounterRequest("nonExistent"), start, clock.Now())
+	assert.IsType(t, &NotFound{}, err)
+}
+
+func TestHistoryStorage_Depth(t *testing.T) {
+	h, clock := newTestHistoryStorage(0, 3)
+	ctx := context.Background()
+	start := clock.Now()
+
+	for i := 0; i < 5; i++ {
+		err := h.Put(ctx, schema.NewGauge("gauge", float64(i)))
+		assert.NoError(t, err)
+		clock.Advance(time.Second)
+	}
+
+	samples, err := h.History(ctx, schema.NewGaugeRequest("gauge"), start, clock.Now())
+	assert.NoError(t, err)
+	assert.Len(t, samples, 3)
+	for i, expected := range []float64{2, 3, 4} {
+		assert.Equal(t, expected, *samples[i].Value)
+	}
+}
+
+func TestHistoryStorage_Retention(t *testing.T) {
+	h, clock := newTestHistoryStorage(time.Minute, 100)
+	ctx := context.Background()
+	start := clock.Now()
+
+	for i := 0; i < 5; i++ {
+		err := h.Put(ctx, schema.NewGauge("gauge", float64(i)))
+		assert.NoError(t, err)
+		clock.Advance(30 * time.Second)
+	}
+
+	samples, err := h.History(ctx, schema.NewGaugeRequest("gauge"), start, clock.Now())
+	assert.NoError(t, err)
+	assert.Len(t, samples, 2)
+	assert.Equal(t, 3.0, *samples[0].Value)
+
+	clock.Advance(time.Hour)
+	_, err = h.History(ctx, schema.NewGaugeRequest("gauge"), start, clock.Now())
+	assert.IsType(t, &NotFound{}, err)
+}
+
+func TestHistoryStorage_FailedUpdateNotRecorded(t *testing.T) {
+	h, clock := newTestHistoryStorage(0, 10)
+	ctx := context.Background()
+	start := clock.Now()
+
+	err := h.Put(ctx, schema.NewGauge("gauge", 1))
+	assert.NoError(t, err)
+	err = h.Increment(ctx, schema.NewCounterRequest("gauge"), 1)
+	assert.Error(t, err)
+
+	samples, err := h.History(ctx, schema.NewGaugeRequest("gauge"), start, clock.Now())
+	assert.NoError(t, err)
+	assert.Len(t, samples, 1)
+}
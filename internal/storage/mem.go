@@ -18,47 +18,80 @@ type MemStorage struct {
 
 	So I use only one mutex for the whole storage.
 	*/
-	m map[string]schema.Metrics
+	m      map[string]schema.Metrics
+	meta   map[string]schema.Metadata
+	tokens map[string]schema.Token
+	// wal records every update of metrics, if set
+	wal *WAL
 	sync.Mutex
 }
 
+// commit should be called with the lock held, values are written
+// to the log first, so the update is not applied if it could not be logged
+func (storage *MemStorage) commit(values ...schema.Metrics) error {
+	if storage.wal != nil {
+		if err := storage.wal.Append(values); err != nil {
+			return err
+		}
+	}
+	for _, m := range values {
+		storage.m[m.Key()] = m
+	}
+	return nil
+}
+
+// checkDeclaredType should be called with the lock held
+func (storage *MemStorage) checkDeclaredType(req schema.Metrics) error {
+	meta, found := storage.meta[req.ID]
+	if found && meta.MType != schema.MetricsTypeEmpty && meta.MType != req.MType {
+		return typeMismatch(req.Key(), req.MType, meta.MType)
+	}
+	return nil
+}
+
 func (storage *MemStorage) Put(_ context.Context, req schema.Metrics) error {
 	storage.Lock()
 	defer storage.Unlock()
-	storage.m[req.ID] = req
-	return nil
+	if err := storage.checkDeclaredType(req); err != nil {
+		return err
+	}
+	return storage.commit(req)
 }
 
 func (storage *MemStorage) Extract(_ context.Context, req schema.Metrics) (schema.Metrics, error) {
 	// Note: this extract should not be re-used in other
 	// methods, this would require a recursive mutex
 	storage.Lock()
-	value, found := storage.m[req.ID]
+	value, found := storage.m[req.Key()]
 	storage.Unlock()
 	if !found {
-		return req, notFound(req.ID)
+		return req, notFound(req.Key())
 	}
 	if req.MType != value.MType {
-		return req, typeMismatch(req.ID, req.MType, value.MType)
+		return req, typeMismatch(req.Key(), req.MType, value.MType)
 	}
 	return value, nil
 }
 
 func (storage *MemStorage) Increment(_ context.Context, req schema.Metrics, value int64) error {
 	if req.MType != schema.MetricsTypeCounter {
-		return incrementingNonCounterMetrics(req.ID, req.MType)
+		return incrementingNonCounterMetrics(req.Key(), req.MType)
 	}
 
 	storage.Lock()
 	defer storage.Unlock()
 
-	current, found := storage.m[req.ID]
+	if err := storage.checkDeclaredType(req); err != nil {
+		return err
+	}
+
+	current, found := storage.m[req.Key()]
 
 	if !found {
 		// Note: I do not assume any required behaviour here,
 		// it's up to application to decide, whether this is an
 		// error or the value should be just set as is.
-		return notFound(req.ID)
+		return notFound(req.Key())
 	}
 
 	if req.MType != current.MType {
@@ -66,24 +99,25 @@ func (storage *MemStorage) Increment(_ context.Context, req schema.Metrics, valu
 		// it's up to application to decide, whether this is an
 		// error or the value in storage should change its type and
 		// be reset.
-		return typeMismatch(req.ID, req.MType, current.MType)
+		return typeMismatch(req.Key(), req.MType, current.MType)
 	}
 
 	delta := *current.Delta + value
 	req.Delta = &delta
-	storage.m[req.ID] = req
-	return nil
+	return storage.commit(req)
 }
 
 func (storage *MemStorage) List(_ context.Context) ([]schema.Metrics, error) {
 	var res []schema.Metrics
 
+	storage.Lock()
 	for _, value := range storage.m {
 		res = append(res, value)
 	}
+	storage.Unlock()
 
 	sort.Slice(res, func(i, j int) bool {
-		return res[i].ID < res[j].ID
+		return res[i].Key() < res[j].Key()
 	})
 
 	return res, nil
@@ -92,26 +126,130 @@ func (storage *MemStorage) List(_ context.Context) ([]schema.Metrics, error) {
 func (storage *MemStorage) BulkPut(_ context.Context, values []schema.Metrics) error {
 	storage.Lock()
 	defer storage.Unlock()
-	for _, req := range values {
-		storage.m[req.ID] = req
-	}
-	return nil
+	return storage.commit(values...)
 }
 
-func (storage *MemStorage) BulkUpdate(_ context.Context, counters []schema.Metrics, gauges []schema.Metrics) error {
+func (storage *MemStorage) BulkUpdate(_ context.Context, counters []schema.Metrics, gauges []schema.Metrics, histograms []schema.Metrics, summaries []schema.Metrics) error {
 	storage.Lock()
 	defer storage.Unlock()
+
+	for _, l := range [...][]schema.Metrics{counters, gauges, histograms, summaries} {
+		for _, m := range l {
+			if err := storage.checkDeclaredType(m); err != nil {
+				return err
+			}
+			// values are merged with the stored ones, so they should be of the same type
+			if prev, found := storage.m[m.Key()]; found && prev.MType != m.MType {
+				return typeMismatch(m.Key(), m.MType, prev.MType)
+			}
+		}
+	}
+
+	// histograms and summaries are merged before anything is written,
+	// so the storage is left intact if some of them could not be merged
+	var merged []schema.Metrics
+	pending := map[string]schema.Metrics{}
+	for _, m := range append(append([]schema.Metrics(nil), histograms...), summaries...) {
+		key := m.Key()
+		prev, found := pending[key]
+		if !found {
+			prev, found = storage.m[key]
+		}
+		value, err := mergeMetrics(prev, found, m)
+		if err != nil {
+			return err
+		}
+		pending[key] = value
+		merged = append(merged, value)
+	}
+
+	// values are committed at once, so counters are summed up with the pending ones
+	var updated []schema.Metrics
+	summed := map[string]schema.Metrics{}
 	for _, counter := range counters {
-		prev, found := storage.m[counter.ID]
+		key := counter.Key()
+		prev, found := summed[key]
+		if !found {
+			prev, found = storage.m[key]
+		}
 		if found {
 			value := *prev.Delta + *counter.Delta
 			counter.Delta = &value
 		}
-		storage.m[counter.ID] = counter
+		summed[key] = counter
+		updated = append(updated, counter)
+	}
+	updated = append(updated, gauges...)
+	return storage.commit(append(updated, merged...)...)
+}
+
+func (storage *MemStorage) PutMetadata(_ context.Context, values []schema.Metadata) error {
+	storage.Lock()
+	defer storage.Unlock()
+	for _, meta := range values {
+		storage.meta[meta.ID] = meta
+	}
+	return nil
+}
+
+func (storage *MemStorage) ListMetadata(_ context.Context) ([]schema.Metadata, error) {
+	var res []schema.Metadata
+
+	storage.Lock()
+	for _, meta := range storage.meta {
+		res = append(res, meta)
+	}
+	storage.Unlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+
+	return res, nil
+}
+
+func (storage *MemStorage) PutToken(_ context.Context, token schema.Token) error {
+	storage.Lock()
+	defer storage.Unlock()
+	storage.tokens[token.Name] = token
+	return nil
+}
+
+func (storage *MemStorage) ExtractToken(_ context.Context, hash string) (schema.Token, error) {
+	storage.Lock()
+	defer storage.Unlock()
+	// there are only a few tokens, so there is no index by hash
+	for _, token := range storage.tokens {
+		if token.Hash == hash {
+			return token, nil
+		}
 	}
-	for _, gauge := range gauges {
-		storage.m[gauge.ID] = gauge
+	return schema.Token{}, tokenNotFound("")
+}
+
+func (storage *MemStorage) ListTokens(_ context.Context) ([]schema.Token, error) {
+	var res []schema.Token
+
+	storage.Lock()
+	for _, token := range storage.tokens {
+		res = append(res, token)
 	}
+	storage.Unlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+
+	return res, nil
+}
+
+func (storage *MemStorage) DeleteToken(_ context.Context, name string) error {
+	storage.Lock()
+	defer storage.Unlock()
+	if _, found := storage.tokens[name]; !found {
+		return tokenNotFound(name)
+	}
+	delete(storage.tokens, name)
 	return nil
 }
 
@@ -119,12 +257,42 @@ func (*MemStorage) Ping(_ context.Context) error {
 	return nil
 }
 
-func (*MemStorage) Close() error {
+// WithWAL makes storage log every update of metrics, the log should be replayed beforehand
+func (storage *MemStorage) WithWAL(wal *WAL) *MemStorage {
+	storage.wal = wal
+	return storage
+}
+
+// Checkpoint starts the new log segment, returned function compacts the log
+// and should be called once the storage state listed after the checkpoint is dumped
+func (storage *MemStorage) Checkpoint() (func() error, error) {
+	if storage.wal == nil {
+		return func() error { return nil }, nil
+	}
+	// updates are logged with the lock held, so all the records
+	// in previous segments are applied to the storage at this moment
+	storage.Lock()
+	segment, err := storage.wal.Rotate()
+	storage.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		return storage.wal.Checkpointed(segment)
+	}, nil
+}
+
+func (storage *MemStorage) Close() error {
+	if storage.wal != nil {
+		return storage.wal.Close()
+	}
 	return nil
 }
 
 func NewMemStorage() *MemStorage {
 	m := new(MemStorage)
 	m.m = map[string]schema.Metrics{}
+	m.meta = map[string]schema.Metadata{}
+	m.tokens = map[string]schema.Token{}
 	return m
 }
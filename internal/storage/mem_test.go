@@ -284,7 +284,7 @@ func TestMemStorage_BulkPutAndUpdate(t *testing.T) {
 
 	gauge = schema.NewGauge("gauge", 17.19)
 	counter = schema.NewCounter("counter", 13)
-	err = storage.BulkUpdate(context.Background(), []schema.Metrics{counter}, []schema.Metrics{gauge})
+	err = storage.BulkUpdate(context.Background(), []schema.Metrics{counter}, []schema.Metrics{gauge}, nil, nil)
 	assert.NoError(t, err)
 	actual, err = storage.List(context.Background())
 	assert.NoError(t, err)
@@ -294,3 +294,166 @@ func TestMemStorage_BulkPutAndUpdate(t *testing.T) {
 
 	assert.Equal(t, expected, actual)
 }
+
+func TestMemStorage_Labels(t *testing.T) {
+	storage := NewMemStorage()
+	first := schema.NewCounter("counter", 42).WithLabels(map[string]string{"host": "a"})
+	second := schema.NewCounter("counter", 13).WithLabels(map[string]string{"host": "b"})
+	unlabeled := schema.NewCounter("counter", 1)
+
+	err := storage.BulkPut(context.Background(), []schema.Metrics{first, second, unlabeled})
+	assert.NoError(t, err)
+	err = storage.Increment(context.Background(), schema.NewCounterRequest("counter").WithLabels(map[string]string{"host": "a"}), 1)
+	assert.NoError(t, err)
+
+	actual, err := storage.Extract(context.Background(), schema.NewCounterRequest("counter").WithLabels(map[string]string{"host": "a"}))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(43), *actual.Delta)
+
+	actual, err = storage.Extract(context.Background(), schema.NewCounterRequest("counter").WithLabels(map[string]string{"host": "b"}))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(13), *actual.Delta)
+
+	actual, err = storage.Extract(context.Background(), schema.NewCounterRequest("counter"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), *actual.Delta)
+
+	_, err = storage.Extract(context.Background(), schema.NewCounterRequest("counter").WithLabels(map[string]string{"host": "c"}))
+	assert.IsType(t, &NotFound{}, err)
+
+	l, err := storage.List(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, l, 3)
+}
+
+func TestMemStorage_BulkUpdateHistograms(t *testing.T) {
+	storage := NewMemStorage()
+	first := schema.NewHistogram("histogram", []float64{1, 2})
+	first.Histogram.Observe(0.5)
+	second := schema.NewHistogram("histogram", []float64{1, 2})
+	second.Histogram.Observe(1.5)
+	second.Histogram.Observe(10)
+
+	err := storage.BulkUpdate(context.Background(), nil, nil, []schema.Metrics{first, second}, nil)
+	assert.NoError(t, err)
+	actual, err := storage.Extract(context.Background(), schema.NewHistogramRequest("histogram"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []int64{1, 1, 1}, actual.Histogram.Counts)
+	assert.Equal(t, int64(3), actual.Histogram.Count)
+	assert.Equal(t, 12.0, actual.Histogram.Sum)
+	// stored values should not be modified by merge
+	assert.Equal(t, []int64{1, 0, 0}, first.Histogram.Counts)
+
+	other := schema.NewHistogram("histogram", []float64{1, 3})
+	counter := schema.NewCounter("counter", 1)
+	err = storage.BulkUpdate(context.Background(), []schema.Metrics{counter}, nil, []schema.Metrics{other}, nil)
+	assert.IsType(t, &BoundsMismatch{}, err)
+	_, err = storage.Extract(context.Background(), schema.NewCounterRequest("counter"))
+	assert.IsType(t, &NotFound{}, err)
+
+	err = storage.Put(context.Background(), schema.NewGauge("gauge", 1))
+	assert.NoError(t, err)
+	err = storage.BulkUpdate(context.Background(), nil, nil, []schema.Metrics{schema.NewHistogram("gauge", nil)}, nil)
+	assert.IsType(t, &TypeMismatch{}, err)
+}
+
+func TestMemStorage_BulkUpdateTypeMismatch(t *testing.T) {
+	storage := NewMemStorage()
+	histogram := schema.NewHistogram("histogram", []float64{1, 2})
+	histogram.Histogram.Observe(0.5)
+	err := storage.BulkPut(context.Background(), []schema.Metrics{histogram, schema.NewCounter("counter", 1)})
+	assert.NoError(t, err)
+
+	gauge := schema.NewGauge("gauge", 1)
+	err = storage.BulkUpdate(context.Background(), []schema.Metrics{schema.NewCounter("histogram", 1)}, []schema.Metrics{gauge}, nil, nil)
+	assert.IsType(t, &TypeMismatch{}, err)
+	err = storage.BulkUpdate(context.Background(), nil, []schema.Metrics{schema.NewGauge("counter", 1)}, nil, nil)
+	assert.IsType(t, &TypeMismatch{}, err)
+
+	// storage should be left intact
+	actual, err := storage.List(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []schema.Metrics{schema.NewCounter("counter", 1), histogram}, actual)
+}
+
+func TestMemStorage_BulkUpdateSummaries(t *testing.T) {
+	storage := NewMemStorage()
+	first := schema.NewSummary("summary", schema.DefaultSummaryAccuracy)
+	first.Summary.Observe(1)
+	second := schema.NewSummary("summary", schema.DefaultSummaryAccuracy)
+	second.Summary.Samples = []float64{2, 3}
+
+	err := storage.BulkUpdate(context.Background(), nil, nil, nil, []schema.Metrics{first})
+	assert.NoError(t, err)
+	err = storage.BulkUpdate(context.Background(), nil, nil, nil, []schema.Metrics{second})
+	assert.NoError(t, err)
+	actual, err := storage.Extract(context.Background(), schema.NewSummaryRequest("summary"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, int64(3), actual.Summary.Count)
+	assert.Equal(t, 6.0, actual.Summary.Sum)
+	assert.Empty(t, actual.Summary.Samples)
+	assert.Equal(t, int64(1), first.Summary.Count)
+
+	other := schema.NewSummary("summary", 0.05)
+	err = storage.BulkUpdate(context.Background(), nil, nil, nil, []schema.Metrics{other})
+	assert.IsType(t, &AccuracyMismatch{}, err)
+}
+
+func TestMemStorage_DeclaredType(t *testing.T) {
+	storage := NewMemStorage()
+	err := storage.PutMetadata(context.Background(), []schema.Metadata{
+		{ID: "gauge", MType: schema.MetricsTypeGauge, Unit: "bytes"},
+		{ID: "anything", Help: "type is not declared"},
+	})
+	assert.NoError(t, err)
+
+	meta, err := storage.ListMetadata(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, meta, 2)
+	assert.Equal(t, "anything", meta[0].ID)
+
+	err = storage.Put(context.Background(), schema.NewGauge("gauge", 1))
+	assert.NoError(t, err)
+	err = storage.Put(context.Background(), schema.NewCounter("gauge", 1))
+	assert.IsType(t, &TypeMismatch{}, err)
+	err = storage.Increment(context.Background(), schema.NewCounterRequest("gauge"), 1)
+	assert.IsType(t, &TypeMismatch{}, err)
+	err = storage.BulkUpdate(context.Background(), []schema.Metrics{schema.NewCounter("gauge", 1)}, nil, nil, nil)
+	assert.IsType(t, &TypeMismatch{}, err)
+
+	err = storage.Put(context.Background(), schema.NewCounter("anything", 1))
+	assert.NoError(t, err)
+}
+
+func TestMemStorage_Tokens(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemStorage()
+	writer := schema.Token{Name: "agent-1", Scope: schema.TokenScopeWrite, Prefix: "agent1.", Hash: schema.HashTokenSecret("first")}
+	reader := schema.Token{Name: "dashboard", Scope: schema.TokenScopeRead, Hash: schema.HashTokenSecret("second")}
+	assert.NoError(t, storage.PutToken(ctx, reader))
+	assert.NoError(t, storage.PutToken(ctx, writer))
+
+	token, err := storage.ExtractToken(ctx, schema.HashTokenSecret("first"))
+	assert.NoError(t, err)
+	assert.Equal(t, writer, token)
+	_, err = storage.ExtractToken(ctx, schema.HashTokenSecret("unknown"))
+	assert.IsType(t, &TokenNotFound{}, err)
+
+	// secret is rotated
+	writer.Hash = schema.HashTokenSecret("third")
+	assert.NoError(t, storage.PutToken(ctx, writer))
+	_, err = storage.ExtractToken(ctx, schema.HashTokenSecret("first"))
+	assert.Error(t, err)
+
+	l, err := storage.ListTokens(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []schema.Token{writer, reader}, l)
+
+	assert.NoError(t, storage.DeleteToken(ctx, "agent-1"))
+	assert.IsType(t, &TokenNotFound{}, storage.DeleteToken(ctx, "agent-1"))
+	l, err = storage.ListTokens(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []schema.Token{reader}, l)
+}
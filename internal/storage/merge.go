@@ -0,0 +1,40 @@
+package storage
+
+import "logogger/internal/schema"
+
+// mergeMetrics merges observations of distribution (histogram or summary)
+// metrics into the stored ones. If nothing is stored yet, a copy of
+// metrics is returned, so stored value never shares state with the request.
+func mergeMetrics(prev schema.Metrics, found bool, next schema.Metrics) (schema.Metrics, error) {
+	key := next.Key()
+	if found && prev.MType != next.MType {
+		return next, typeMismatch(key, next.MType, prev.MType)
+	}
+
+	switch next.MType {
+	case schema.MetricsTypeHistogram:
+		base := schema.Histogram{
+			Bounds: next.Histogram.Bounds,
+			Counts: make([]int64, len(next.Histogram.Counts)),
+		}
+		if found {
+			base = *prev.Histogram
+		}
+		h, err := base.Merge(*next.Histogram)
+		if err != nil {
+			return next, boundsMismatch(key)
+		}
+		next.Histogram = &h
+	case schema.MetricsTypeSummary:
+		base := schema.Summary{Alpha: next.Summary.Alpha}
+		if found {
+			base = *prev.Summary
+		}
+		s, err := base.Merge(*next.Summary)
+		if err != nil {
+			return next, accuracyMismatch(key)
+		}
+		next.Summary = &s
+	}
+	return next, nil
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -16,62 +17,164 @@ type PostgresStorage struct {
 	db *sql.DB
 }
 
+// queryer is implemented by both sql.DB and sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 func (p PostgresStorage) Put(ctx context.Context, req schema.Metrics) error {
 	var value interface{}
 	var query string
 
 	switch req.MType {
+	case schema.MetricsTypeHistogram, schema.MetricsTypeSummary:
+		tx, rollback, err := p.Transaction(ctx)
+		if err != nil {
+			return err
+		}
+		defer rollback()
+
+		err = checkDeclaredType(ctx, tx, req)
+		if err != nil {
+			return err
+		}
+		err = putDistribution(ctx, tx, req)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
 	case schema.MetricsTypeCounter:
-		query = "INSERT INTO metric(id, type, delta, value) VALUES($1, 'counter', $2, NULL) ON CONFLICT (id) DO UPDATE SET type='counter', delta=EXCLUDED.delta, value=NULL"
+		query = "INSERT INTO metric(id, labels, type, delta, value) VALUES($1, $2, 'counter', $3, NULL) ON CONFLICT (id, labels) DO UPDATE SET type='counter', delta=EXCLUDED.delta, value=NULL"
 		value = *req.Delta
 	case schema.MetricsTypeGauge:
-		query = "INSERT INTO metric(id, type, delta, value) VALUES($1, 'gauge', NULL, $2) ON CONFLICT (id) DO UPDATE SET type='gauge', delta=NULL, value=EXCLUDED.value"
+		query = "INSERT INTO metric(id, labels, type, delta, value) VALUES($1, $2, 'gauge', NULL, $3) ON CONFLICT (id, labels) DO UPDATE SET type='gauge', delta=NULL, value=EXCLUDED.value"
 		value = *req.Value
 	default:
 		return fmt.Errorf("unsupported metrics type: %s", req.MType)
 	}
 
+	labels, err := encodeLabels(req.Labels)
+	if err != nil {
+		return err
+	}
+
+	err = checkDeclaredType(ctx, p.db, req)
+	if err != nil {
+		return err
+	}
+
 	putQuery, err := p.db.PrepareContext(ctx, query)
 	if err != nil {
 		return err
 	}
-	_, err = putQuery.ExecContext(ctx, req.ID, value)
+	_, err = putQuery.ExecContext(ctx, req.ID, labels, value)
 	return err
 }
 
 func (p PostgresStorage) Extract(ctx context.Context, req schema.Metrics) (schema.Metrics, error) {
 	res := schema.NewEmptyMetrics()
 
-	extractQuery, err := p.db.PrepareContext(ctx, "SELECT type, delta, value FROM metric WHERE id = $1")
+	labels, err := encodeLabels(req.Labels)
+	if err != nil {
+		return schema.NewEmptyMetrics(), err
+	}
+
+	extractQuery, err := p.db.PrepareContext(ctx, "SELECT type, delta, value FROM metric WHERE id = $1 AND labels = $2")
 	if err != nil {
 		return schema.NewEmptyMetrics(), err
 	}
-	row := extractQuery.QueryRowContext(ctx, req.ID)
+	row := extractQuery.QueryRowContext(ctx, req.ID, labels)
 	var delta sql.NullInt64
 	var value sql.NullFloat64
 
 	err = row.Scan(&res.MType, &delta, &value)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return schema.NewEmptyMetrics(), notFound(req.ID)
+			return schema.NewEmptyMetrics(), notFound(req.Key())
 		}
 		return schema.NewEmptyMetrics(), err
 	}
 	if res.MType != req.MType {
-		return schema.NewEmptyMetrics(), typeMismatch(req.ID, req.MType, res.MType)
+		return schema.NewEmptyMetrics(), typeMismatch(req.Key(), req.MType, res.MType)
 	}
+	res = res.WithLabels(req.Labels)
+	res.ID = req.ID
 	if delta.Valid {
 		res.Delta = &delta.Int64
 	}
+	switch res.MType {
+	case schema.MetricsTypeHistogram:
+		h := schema.Histogram{Sum: value.Float64, Count: delta.Int64}
+		h.Bounds, h.Counts, err = extractBuckets(ctx, p.db, req.ID, labels)
+		if err != nil {
+			return schema.NewEmptyMetrics(), err
+		}
+		res.Delta = nil
+		res.Histogram = &h
+		return res, nil
+	case schema.MetricsTypeSummary:
+		res.Delta = nil
+		res.Summary, err = extractSketch(ctx, p.db, req.ID, labels)
+		if err != nil {
+			return schema.NewEmptyMetrics(), err
+		}
+		return res, nil
+	}
 	if value.Valid {
 		res.Value = &value.Float64
 	}
 	return res, nil
 }
 
+func extractBuckets(ctx context.Context, q queryer, id string, labels string) ([]float64, []int64, error) {
+	rows, err := q.QueryContext(ctx, "SELECT bound, count FROM histogram_bucket WHERE id = $1 AND labels = $2 ORDER BY idx", id, labels)
+	if err != nil {
+		return nil, nil, err
+	}
+	return scanBuckets(rows)
+}
+
+func extractSketch(ctx context.Context, q queryer, id string, labels string) (*schema.Summary, error) {
+	var raw string
+	err := q.QueryRowContext(ctx, "SELECT sketch FROM summary_sketch WHERE id = $1 AND labels = $2", id, labels).Scan(&raw)
+	if err != nil {
+		return nil, err
+	}
+	var summary schema.Summary
+	err = json.Unmarshal([]byte(raw), &summary)
+	return &summary, err
+}
+
+func scanBuckets(rows *sql.Rows) ([]float64, []int64, error) {
+	defer rows.Close()
+
+	bounds := []float64{}
+	var counts []int64
+	for rows.Next() {
+		var bound sql.NullFloat64
+		var count int64
+		err := rows.Scan(&bound, &count)
+		if err != nil {
+			return nil, nil, err
+		}
+		// +Inf bucket is stored with NULL bound
+		if bound.Valid {
+			bounds = append(bounds, bound.Float64)
+		}
+		counts = append(counts, count)
+	}
+	return bounds, counts, rows.Err()
+}
+
 func (p PostgresStorage) Increment(ctx context.Context, req schema.Metrics, value int64) error {
 	if req.MType != "counter" {
-		return incrementingNonCounterMetrics(req.ID, req.MType)
+		return incrementingNonCounterMetrics(req.Key(), req.MType)
+	}
+
+	labels, err := encodeLabels(req.Labels)
+	if err != nil {
+		return err
 	}
 
 	tx, rollback, err := p.Transaction(ctx)
@@ -80,16 +183,21 @@ func (p PostgresStorage) Increment(ctx context.Context, req schema.Metrics, valu
 	}
 	defer rollback()
 
+	err = checkDeclaredType(ctx, p.db, req)
+	if err != nil {
+		return err
+	}
+
 	_, err = p.Extract(ctx, req)
 	if err != nil {
 		return err
 	}
 
-	incrementQuery, err := p.db.PrepareContext(ctx, "UPDATE metric SET delta = delta + $2 WHERE id = $1")
+	incrementQuery, err := p.db.PrepareContext(ctx, "UPDATE metric SET delta = delta + $3 WHERE id = $1 AND labels = $2")
 	if err != nil {
 		return err
 	}
-	_, err = incrementQuery.ExecContext(ctx, req.ID, value)
+	_, err = incrementQuery.ExecContext(ctx, req.ID, labels, value)
 	if err != nil {
 		return err
 	}
@@ -107,7 +215,17 @@ func (p PostgresStorage) List(ctx context.Context) ([]schema.Metrics, error) {
 	}
 	defer rollback()
 
-	query, err := tx.PrepareContext(ctx, "SELECT id, type, delta, value FROM metric")
+	buckets, err := listBuckets(ctx, tx)
+	if err != nil {
+		return res, err
+	}
+
+	sketches, err := listSketches(ctx, tx)
+	if err != nil {
+		return res, err
+	}
+
+	query, err := tx.PrepareContext(ctx, "SELECT id, labels, type, delta, value FROM metric ORDER BY id, labels")
 	if err != nil {
 		return res, err
 	}
@@ -119,22 +237,232 @@ func (p PostgresStorage) List(ctx context.Context) ([]schema.Metrics, error) {
 		var row schema.Metrics
 		var delta sql.NullInt64
 		var value sql.NullFloat64
-		err = rows.Scan(&row.ID, &row.MType, &delta, &value)
-		if delta.Valid {
-			row.Delta = &delta.Int64
-		}
-		if value.Valid {
-			row.Value = &value.Float64
+		var labels string
+		err = rows.Scan(&row.ID, &labels, &row.MType, &delta, &value)
+		if err != nil {
+			return res, err
 		}
+		row.Labels, err = decodeLabels(labels)
 		if err != nil {
 			return res, err
 		}
+		switch row.MType {
+		case schema.MetricsTypeHistogram:
+			h := buckets[row.ID+"/"+labels]
+			h.Sum = value.Float64
+			h.Count = delta.Int64
+			row.Histogram = &h
+		case schema.MetricsTypeSummary:
+			summary := sketches[row.ID+"/"+labels]
+			row.Summary = &summary
+		default:
+			if delta.Valid {
+				row.Delta = &delta.Int64
+			}
+			if value.Valid {
+				row.Value = &value.Float64
+			}
+		}
 		res = append(res, row)
 	}
 	err = rows.Err()
 	return res, err
 }
 
+// listBuckets retrieves buckets of all the stored histograms,
+// histograms are identified by id and labels joined with slash
+func listBuckets(ctx context.Context, tx *sql.Tx) (map[string]schema.Histogram, error) {
+	res := map[string]schema.Histogram{}
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, labels, bound, count FROM histogram_bucket ORDER BY id, labels, idx")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, labels string
+		var bound sql.NullFloat64
+		var count int64
+		err = rows.Scan(&id, &labels, &bound, &count)
+		if err != nil {
+			return nil, err
+		}
+		key := id + "/" + labels
+		h := res[key]
+		if h.Bounds == nil {
+			h.Bounds = []float64{}
+		}
+		if bound.Valid {
+			h.Bounds = append(h.Bounds, bound.Float64)
+		}
+		h.Counts = append(h.Counts, count)
+		res[key] = h
+	}
+	return res, rows.Err()
+}
+
+// listSketches retrieves sketches of all the stored summaries,
+// summaries are identified by id and labels joined with slash
+func listSketches(ctx context.Context, tx *sql.Tx) (map[string]schema.Summary, error) {
+	res := map[string]schema.Summary{}
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, labels, sketch FROM summary_sketch")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, labels, raw string
+		err = rows.Scan(&id, &labels, &raw)
+		if err != nil {
+			return nil, err
+		}
+		var summary schema.Summary
+		err = json.Unmarshal([]byte(raw), &summary)
+		if err != nil {
+			return nil, err
+		}
+		res[id+"/"+labels] = summary
+	}
+	return res, rows.Err()
+}
+
+// putDistribution overwrites histogram or summary with the given value
+func putDistribution(ctx context.Context, tx *sql.Tx, m schema.Metrics) error {
+	switch m.MType {
+	case schema.MetricsTypeHistogram:
+		return putHistogram(ctx, tx, m)
+	case schema.MetricsTypeSummary:
+		return putSummary(ctx, tx, m)
+	default:
+		return fmt.Errorf("unsupported metrics type: %s", m.MType)
+	}
+}
+
+// putSummary overwrites summary with the given value,
+// sketch is stored as is, count and sum are duplicated in metric table
+func putSummary(ctx context.Context, tx *sql.Tx, m schema.Metrics) error {
+	labels, err := encodeLabels(m.Labels)
+	if err != nil {
+		return err
+	}
+	summary := *m.Summary
+	summary.Normalize()
+	summary.Quantiles = nil
+
+	sketch, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO metric(id, labels, type, delta, value) VALUES($1, $2, 'summary', $3, $4) ON CONFLICT (id, labels) DO UPDATE SET type='summary', delta=EXCLUDED.delta, value=EXCLUDED.value", m.ID, labels, summary.Count, summary.Sum)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO summary_sketch(id, labels, sketch) VALUES($1, $2, $3) ON CONFLICT (id, labels) DO UPDATE SET sketch=EXCLUDED.sketch", m.ID, labels, string(sketch))
+	return err
+}
+
+// putHistogram overwrites histogram with the given value
+func putHistogram(ctx context.Context, tx *sql.Tx, m schema.Metrics) error {
+	labels, err := encodeLabels(m.Labels)
+	if err != nil {
+		return err
+	}
+	h := m.Histogram
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO metric(id, labels, type, delta, value) VALUES($1, $2, 'histogram', $3, $4) ON CONFLICT (id, labels) DO UPDATE SET type='histogram', delta=EXCLUDED.delta, value=EXCLUDED.value", m.ID, labels, h.Count, h.Sum)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM histogram_bucket WHERE id = $1 AND labels = $2", m.ID, labels)
+	if err != nil {
+		return err
+	}
+
+	insertQuery, err := tx.PrepareContext(ctx, "INSERT INTO histogram_bucket(id, labels, idx, bound, count) VALUES($1, $2, $3, $4, $5)")
+	if err != nil {
+		return err
+	}
+	for i, count := range h.Counts {
+		var bound interface{}
+		if i < len(h.Bounds) {
+			bound = h.Bounds[i]
+		}
+		_, err = insertQuery.ExecContext(ctx, m.ID, labels, i, bound, count)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkStoredType locks the row of the metrics, so its type could not be changed
+// until the transaction ends, and checks that it is of the same type as the update
+func checkStoredType(ctx context.Context, tx *sql.Tx, m schema.Metrics) error {
+	labels, err := encodeLabels(m.Labels)
+	if err != nil {
+		return err
+	}
+
+	var stored schema.MetricsType
+	err = tx.QueryRowContext(ctx, "SELECT type FROM metric WHERE id = $1 AND labels = $2 FOR UPDATE", m.ID, labels).Scan(&stored)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if stored != m.MType {
+		return typeMismatch(m.Key(), m.MType, stored)
+	}
+	return nil
+}
+
+// mergeDistribution adds observations of the given histogram or summary to the stored one
+func mergeDistribution(ctx context.Context, tx *sql.Tx, m schema.Metrics) error {
+	labels, err := encodeLabels(m.Labels)
+	if err != nil {
+		return err
+	}
+
+	stored := schema.Metrics{ID: m.ID, Labels: m.Labels}
+	var count sql.NullInt64
+	var sum sql.NullFloat64
+	row := tx.QueryRowContext(ctx, "SELECT type, delta, value FROM metric WHERE id = $1 AND labels = $2 FOR UPDATE", m.ID, labels)
+	err = row.Scan(&stored.MType, &count, &sum)
+	found := true
+	if errors.Is(err, sql.ErrNoRows) {
+		found = false
+	} else if err != nil {
+		return err
+	}
+
+	if found {
+		switch stored.MType {
+		case schema.MetricsTypeHistogram:
+			h := schema.Histogram{Sum: sum.Float64, Count: count.Int64}
+			h.Bounds, h.Counts, err = extractBuckets(ctx, tx, m.ID, labels)
+			stored.Histogram = &h
+		case schema.MetricsTypeSummary:
+			stored.Summary, err = extractSketch(ctx, tx, m.ID, labels)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	merged, err := mergeMetrics(stored, found, m)
+	if err != nil {
+		return err
+	}
+	return putDistribution(ctx, tx, merged)
+}
+
 func (p PostgresStorage) BulkPut(ctx context.Context, values []schema.Metrics) error {
 	tx, rollback, err := p.Transaction(ctx)
 	if err != nil {
@@ -142,16 +470,23 @@ func (p PostgresStorage) BulkPut(ctx context.Context, values []schema.Metrics) e
 	}
 	defer rollback()
 
-	putQuery, err := p.db.PrepareContext(ctx, "INSERT INTO metric(id, type, delta, value) VALUES($1, $2, $3, $4)")
+	putQuery, err := p.db.PrepareContext(ctx, "INSERT INTO metric(id, labels, type, delta, value) VALUES($1, $2, $3, $4, $5)")
 	if err != nil {
 		return err
 	}
 	for _, metric := range values {
+		var labels string
+		labels, err = encodeLabels(metric.Labels)
+		if err != nil {
+			return err
+		}
 		switch metric.MType {
 		case schema.MetricsTypeCounter:
-			_, err = putQuery.ExecContext(ctx, metric.ID, metric.MType, *metric.Delta, nil)
+			_, err = putQuery.ExecContext(ctx, metric.ID, labels, metric.MType, *metric.Delta, nil)
 		case schema.MetricsTypeGauge:
-			_, err = putQuery.ExecContext(ctx, metric.ID, metric.MType, nil, *metric.Value)
+			_, err = putQuery.ExecContext(ctx, metric.ID, labels, metric.MType, nil, *metric.Value)
+		case schema.MetricsTypeHistogram, schema.MetricsTypeSummary:
+			err = putDistribution(ctx, tx, metric)
 		default:
 			return fmt.Errorf("unsupported metrics type: %s", metric.MType)
 		}
@@ -163,30 +498,80 @@ func (p PostgresStorage) BulkPut(ctx context.Context, values []schema.Metrics) e
 	return tx.Commit()
 }
 
-func (p PostgresStorage) BulkUpdate(ctx context.Context, counters []schema.Metrics, gauges []schema.Metrics) error {
+func (p PostgresStorage) BulkUpdate(ctx context.Context, counters []schema.Metrics, gauges []schema.Metrics, histograms []schema.Metrics, summaries []schema.Metrics) error {
 	tx, rollback, err := p.Transaction(ctx)
 	if err != nil {
 		return err
 	}
 	defer rollback()
 
-	putQuery, err := p.db.PrepareContext(ctx, "INSERT INTO metric(id, type, delta, value) VALUES($1, 'counter', $2, NULL) ON CONFLICT (id) DO UPDATE SET type='counter', delta=metric.delta+EXCLUDED.delta, value=NULL")
+	for _, l := range [...][]schema.Metrics{counters, gauges, histograms, summaries} {
+		for _, m := range l {
+			err = checkDeclaredType(ctx, tx, m)
+			if err != nil {
+				return err
+			}
+			err = checkStoredType(ctx, tx, m)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	putQuery, err := tx.PrepareContext(ctx, "INSERT INTO metric(id, labels, type, delta, value) VALUES($1, $2, 'counter', $3, NULL) ON CONFLICT (id, labels) DO UPDATE SET type='counter', delta=metric.delta+EXCLUDED.delta, value=NULL")
 	if err != nil {
 		return err
 	}
 	for _, m := range counters {
-		_, err = putQuery.ExecContext(ctx, m.ID, *m.Delta)
+		var labels string
+		labels, err = encodeLabels(m.Labels)
+		if err != nil {
+			return err
+		}
+		_, err = putQuery.ExecContext(ctx, m.ID, labels, *m.Delta)
 		if err != nil {
 			return err
 		}
 	}
 
-	putQuery, err = p.db.PrepareContext(ctx, "INSERT INTO metric(id, type, delta, value) VALUES($1, 'gauge', NULL, $2) ON CONFLICT (id) DO UPDATE SET type='gauge', delta=NULL, value=EXCLUDED.value")
+	putQuery, err = tx.PrepareContext(ctx, "INSERT INTO metric(id, labels, type, delta, value) VALUES($1, $2, 'gauge', NULL, $3) ON CONFLICT (id, labels) DO UPDATE SET type='gauge', delta=NULL, value=EXCLUDED.value")
 	if err != nil {
 		return err
 	}
 	for _, m := range gauges {
-		_, err = putQuery.ExecContext(ctx, m.ID, *m.Value)
+		var labels string
+		labels, err = encodeLabels(m.Labels)
+		if err != nil {
+			return err
+		}
+		_, err = putQuery.ExecContext(ctx, m.ID, labels, *m.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, m := range append(append([]schema.Metrics(nil), histograms...), summaries...) {
+		err = mergeDistribution(ctx, tx, m)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (p PostgresStorage) PutMetadata(ctx context.Context, values []schema.Metadata) error {
+	tx, rollback, err := p.Transaction(ctx)
+	if err != nil {
+		return err
+	}
+	defer rollback()
+
+	putQuery, err := tx.PrepareContext(ctx, "INSERT INTO metadata(id, type, unit, help) VALUES($1, $2, $3, $4) ON CONFLICT (id) DO UPDATE SET type=EXCLUDED.type, unit=EXCLUDED.unit, help=EXCLUDED.help")
+	if err != nil {
+		return err
+	}
+	for _, meta := range values {
+		_, err = putQuery.ExecContext(ctx, meta.ID, meta.MType, meta.Unit, meta.Help)
 		if err != nil {
 			return err
 		}
@@ -194,6 +579,96 @@ func (p PostgresStorage) BulkUpdate(ctx context.Context, counters []schema.Metri
 	return tx.Commit()
 }
 
+func (p PostgresStorage) ListMetadata(ctx context.Context) ([]schema.Metadata, error) {
+	var res []schema.Metadata
+
+	rows, err := p.db.QueryContext(ctx, "SELECT id, type, unit, help FROM metadata ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var meta schema.Metadata
+		err = rows.Scan(&meta.ID, &meta.MType, &meta.Unit, &meta.Help)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, meta)
+	}
+	return res, rows.Err()
+}
+
+func (p PostgresStorage) PutToken(ctx context.Context, token schema.Token) error {
+	_, err := p.db.ExecContext(
+		ctx,
+		"INSERT INTO token(name, hash, scope, prefix) VALUES($1, $2, $3, $4) ON CONFLICT (name) DO UPDATE SET hash=EXCLUDED.hash, scope=EXCLUDED.scope, prefix=EXCLUDED.prefix",
+		token.Name, token.Hash, token.Scope, token.Prefix,
+	)
+	return err
+}
+
+func (p PostgresStorage) ExtractToken(ctx context.Context, hash string) (schema.Token, error) {
+	var token schema.Token
+	err := p.db.QueryRowContext(ctx, "SELECT name, hash, scope, prefix FROM token WHERE hash = $1", hash).
+		Scan(&token.Name, &token.Hash, &token.Scope, &token.Prefix)
+	if errors.Is(err, sql.ErrNoRows) {
+		return token, tokenNotFound("")
+	}
+	return token, err
+}
+
+func (p PostgresStorage) ListTokens(ctx context.Context) ([]schema.Token, error) {
+	var res []schema.Token
+
+	rows, err := p.db.QueryContext(ctx, "SELECT name, hash, scope, prefix FROM token ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var token schema.Token
+		err = rows.Scan(&token.Name, &token.Hash, &token.Scope, &token.Prefix)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, token)
+	}
+	return res, rows.Err()
+}
+
+func (p PostgresStorage) DeleteToken(ctx context.Context, name string) error {
+	res, err := p.db.ExecContext(ctx, "DELETE FROM token WHERE name = $1", name)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return tokenNotFound(name)
+	}
+	return nil
+}
+
+// checkDeclaredType ensures that metrics type does not contradict the declared one
+func checkDeclaredType(ctx context.Context, q queryer, req schema.Metrics) error {
+	var declared schema.MetricsType
+	err := q.QueryRowContext(ctx, "SELECT type FROM metadata WHERE id = $1", req.ID).Scan(&declared)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if declared != schema.MetricsTypeEmpty && declared != req.MType {
+		return typeMismatch(req.Key(), req.MType, declared)
+	}
+	return nil
+}
+
 func (p PostgresStorage) Ping(ctx context.Context) error {
 	return p.db.PingContext(ctx)
 }
@@ -225,6 +700,44 @@ func NewPostgresStorage(dsn string) (PostgresStorage, error) {
 	}
 	p := PostgresStorage{db}
 
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS metric (id VARCHAR(255) PRIMARY KEY, type VARCHAR(255) NOT NULL, delta BIGINT, value DOUBLE PRECISION)")
-	return p, err
+	migrations := [...]string{
+		"CREATE TABLE IF NOT EXISTS metric (id VARCHAR(255) NOT NULL, labels TEXT NOT NULL DEFAULT '', type VARCHAR(255) NOT NULL, delta BIGINT, value DOUBLE PRECISION)",
+		// tables created before labels were introduced are keyed by id only
+		"ALTER TABLE metric ADD COLUMN IF NOT EXISTS labels TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE metric DROP CONSTRAINT IF EXISTS metric_pkey",
+		"CREATE UNIQUE INDEX IF NOT EXISTS metric_id_labels_idx ON metric (id, labels)",
+		// histogram sum and count are stored in metric table as value and delta respectively,
+		// buckets are stored separately, +Inf bucket has NULL bound
+		"CREATE TABLE IF NOT EXISTS histogram_bucket (id VARCHAR(255) NOT NULL, labels TEXT NOT NULL DEFAULT '', idx INTEGER NOT NULL, bound DOUBLE PRECISION, count BIGINT NOT NULL, UNIQUE (id, labels, idx))",
+		// summary sketches are stored serialized, they are merged by the application
+		"CREATE TABLE IF NOT EXISTS summary_sketch (id VARCHAR(255) NOT NULL, labels TEXT NOT NULL DEFAULT '', sketch TEXT NOT NULL, UNIQUE (id, labels))",
+		"CREATE TABLE IF NOT EXISTS metadata (id VARCHAR(255) PRIMARY KEY, type VARCHAR(255) NOT NULL DEFAULT '', unit TEXT NOT NULL DEFAULT '', help TEXT NOT NULL DEFAULT '')",
+		"CREATE TABLE IF NOT EXISTS token (name VARCHAR(255) PRIMARY KEY, hash CHAR(64) NOT NULL UNIQUE, scope VARCHAR(255) NOT NULL, prefix TEXT NOT NULL DEFAULT '')",
+	}
+	for _, migration := range migrations {
+		_, err = db.Exec(migration)
+		if err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// encodeLabels serializes labels into canonical form to be stored in the database.
+// json.Marshal sorts map keys, so the same set of labels always results in the same string.
+func encodeLabels(labels map[string]string) (string, error) {
+	if len(labels) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(labels)
+	return string(b), err
+}
+
+func decodeLabels(raw string) (map[string]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var labels map[string]string
+	err := json.Unmarshal([]byte(raw), &labels)
+	return labels, err
 }
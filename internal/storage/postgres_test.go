@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"logogger/internal/schema"
+)
+
+// newTestPostgresStorage connects to the database from TEST_DATABASE_DSN, the test is skipped if it is not set
+func newTestPostgresStorage(t *testing.T) PostgresStorage {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	storage, err := NewPostgresStorage(dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = storage.Close()
+	})
+	return storage
+}
+
+func TestPostgresStorage_BulkUpdateTypeMismatch(t *testing.T) {
+	storage := newTestPostgresStorage(t)
+	ctx := context.Background()
+	// names are unique, so the test does not depend on the state of the database
+	suffix := fmt.Sprintf("-%d", time.Now().UnixNano())
+
+	histogram := schema.NewHistogram("histogram"+suffix, []float64{1, 2})
+	histogram.Histogram.Observe(0.5)
+	counter := schema.NewCounter("counter"+suffix, 1)
+	err := storage.BulkPut(ctx, []schema.Metrics{histogram, counter})
+	require.NoError(t, err)
+
+	gauge := schema.NewGauge("gauge"+suffix, 1)
+	err = storage.BulkUpdate(ctx, []schema.Metrics{schema.NewCounter(histogram.ID, 1)}, []schema.Metrics{gauge}, nil, nil)
+	assert.IsType(t, &TypeMismatch{}, err)
+	err = storage.BulkUpdate(ctx, nil, []schema.Metrics{schema.NewGauge(counter.ID, 1)}, nil, nil)
+	assert.IsType(t, &TypeMismatch{}, err)
+
+	// storage should be left intact
+	actual, err := storage.Extract(ctx, schema.NewHistogramRequest(histogram.ID))
+	require.NoError(t, err)
+	assert.Equal(t, histogram.Histogram.Counts, actual.Histogram.Counts)
+	actual, err = storage.Extract(ctx, schema.NewCounterRequest(counter.ID))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *actual.Delta)
+	_, err = storage.Extract(ctx, schema.NewGaugeRequest(gauge.ID))
+	assert.IsType(t, &NotFound{}, err)
+}
@@ -3,6 +3,7 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"logogger/internal/schema"
 )
@@ -13,7 +14,27 @@ type MetricsStorage interface {
 	Increment(ctx context.Context, req schema.Metrics, value int64) error
 	List(ctx context.Context) ([]schema.Metrics, error)
 	BulkPut(ctx context.Context, values []schema.Metrics) error
-	BulkUpdate(ctx context.Context, counters []schema.Metrics, gauges []schema.Metrics) error
+	BulkUpdate(ctx context.Context, counters []schema.Metrics, gauges []schema.Metrics, histograms []schema.Metrics, summaries []schema.Metrics) error
+	PutMetadata(ctx context.Context, values []schema.Metadata) error
+	ListMetadata(ctx context.Context) ([]schema.Metadata, error)
+	// PutToken creates token or replaces the one with the same name
+	PutToken(ctx context.Context, token schema.Token) error
+	// ExtractToken finds token by hash of its secret
+	ExtractToken(ctx context.Context, hash string) (schema.Token, error)
+	ListTokens(ctx context.Context) ([]schema.Token, error)
+	DeleteToken(ctx context.Context, name string) error
 	Ping(ctx context.Context) error
 	Close() error
 }
+
+// Checkpointer is implemented by storages with write-ahead log. Checkpoint should be taken
+// before storage state is listed for dump, returned function compacts the log after the dump
+type Checkpointer interface {
+	Checkpoint() (func() error, error)
+}
+
+// MetricsHistory is implemented by storages, which retain previous values of metrics
+type MetricsHistory interface {
+	MetricsStorage
+	History(ctx context.Context, req schema.Metrics, from time.Time, to time.Time) ([]schema.Sample, error)
+}
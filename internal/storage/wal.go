@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"logogger/internal/schema"
+)
+
+const walSegmentPattern = "wal-%020d.log"
+
+// WAL is an append-only log of updated metrics. Every record holds resulting values
+// of the update (not deltas), so replaying the same record twice is harmless.
+// The log is split into segments, old segments are removed once storage state is dumped.
+type WAL struct {
+	dir     string
+	file    *os.File
+	segment uint64
+	// interval between fsync calls, every record is synced if zero
+	interval time.Duration
+	dirty    bool
+	// keep is the number of previous checkpoints, the log is retained since
+	keep        int
+	checkpoints []uint64
+	done        chan struct{}
+	wg          sync.WaitGroup
+	mu          sync.Mutex
+}
+
+// OpenWAL opens log in the directory, existing segments are kept for Replay,
+// new records are written into the new segment
+func OpenWAL(dir string, interval time.Duration) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	segments, err := walSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WAL{dir: dir, interval: interval, done: make(chan struct{})}
+	if len(segments) > 0 {
+		w.segment = segments[len(segments)-1]
+	}
+	if err = w.openSegment(w.segment + 1); err != nil {
+		return nil, err
+	}
+
+	if interval > 0 {
+		w.wg.Add(1)
+		go w.syncPeriodically()
+	}
+	return w, nil
+}
+
+// walSegments returns sorted numbers of segments in the directory
+func walSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []uint64
+	for _, entry := range entries {
+		var segment uint64
+		if _, err_ := fmt.Sscanf(entry.Name(), walSegmentPattern, &segment); err_ == nil && !entry.IsDir() {
+			segments = append(segments, segment)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i] < segments[j]
+	})
+	return segments, nil
+}
+
+func (w *WAL) segmentPath(segment uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf(walSegmentPattern, segment))
+}
+
+// openSegment should be called with the lock held
+func (w *WAL) openSegment(segment uint64) error {
+	f, err := os.OpenFile(w.segmentPath(segment), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.segment = segment
+	w.dirty = false
+	return nil
+}
+
+func (w *WAL) syncPeriodically() {
+	defer w.wg.Done()
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := w.Sync(); err != nil {
+				log.Printf("Could not sync write-ahead log: %s", err.Error())
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Append writes the record, it is synced to disk immediately or by interval
+func (w *WAL) Append(l []schema.Metrics) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err = w.file.Write(data); err != nil {
+		return err
+	}
+	if w.interval > 0 {
+		w.dirty = true
+		return nil
+	}
+	return w.file.Sync()
+}
+
+// Sync flushes records written since the last sync
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.dirty {
+		return nil
+	}
+	w.dirty = false
+	return w.file.Sync()
+}
+
+// Replay applies records from segments, which existed before the log was opened, in order
+func (w *WAL) Replay(apply func([]schema.Metrics) error) error {
+	w.mu.Lock()
+	current := w.segment
+	w.mu.Unlock()
+
+	segments, err := walSegments(w.dir)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segment >= current {
+			break
+		}
+		if err = w.replaySegment(segment, apply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WAL) replaySegment(segment uint64, apply func([]schema.Metrics) error) error {
+	f, err := os.Open(w.segmentPath(segment))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err_ := reader.ReadBytes('\n')
+		if errors.Is(err_, io.EOF) {
+			if len(line) != 0 {
+				// the last record was not written completely, so the update was not acknowledged
+				log.Printf("Skipping incomplete record at the end of write-ahead log segment %d", segment)
+			}
+			return nil
+		}
+		if err_ != nil {
+			return err_
+		}
+
+		var l []schema.Metrics
+		if err_ = json.Unmarshal(line, &l); err_ != nil {
+			return fmt.Errorf("corrupted write-ahead log segment %d: %w", segment, err_)
+		}
+		if err_ = apply(l); err_ != nil {
+			return err_
+		}
+	}
+}
+
+// Rotate starts the new segment, returned number should be passed to Compact,
+// once everything written before the rotation is persisted elsewhere
+func (w *WAL) Rotate() (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.file.Sync(); err != nil {
+		return 0, err
+	}
+	// the previous segment is kept open until the new one is ready
+	prev := w.file
+	if err := w.openSegment(w.segment + 1); err != nil {
+		return 0, err
+	}
+	return w.segment, prev.Close()
+}
+
+// Compact removes segments older than the given one
+func (w *WAL) Compact(before uint64) error {
+	segments, err := walSegments(w.dir)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segment >= before {
+			break
+		}
+		if err = os.Remove(w.segmentPath(segment)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// WithKeep makes the log retain records since the checkpoint of keep dumps ago,
+// so the previous snapshots kept by dumper could be brought up to date as well
+func (w *WAL) WithKeep(keep int) *WAL {
+	w.keep = keep
+	return w
+}
+
+// Checkpointed compacts the log, once the state at the checkpoint (see Rotate) is dumped,
+// segments needed by retained snapshots are kept
+func (w *WAL) Checkpointed(segment uint64) error {
+	w.mu.Lock()
+	w.checkpoints = append(w.checkpoints, segment)
+	if len(w.checkpoints) <= w.keep {
+		w.mu.Unlock()
+		return nil
+	}
+	w.checkpoints = w.checkpoints[len(w.checkpoints)-w.keep-1:]
+	oldest := w.checkpoints[0]
+	w.mu.Unlock()
+	return w.Compact(oldest)
+}
+
+// Discard removes all the records, which were written before the log was opened
+func (w *WAL) Discard() error {
+	w.mu.Lock()
+	current := w.segment
+	w.mu.Unlock()
+	return w.Compact(current)
+}
+
+func (w *WAL) Close() error {
+	close(w.done)
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
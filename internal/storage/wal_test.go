@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"logogger/internal/schema"
+)
+
+// replayed restores storage from the log in the directory, as the server does on startup
+func replayed(t *testing.T, dir string) (*MemStorage, *WAL) {
+	wal, err := OpenWAL(dir, 0)
+	require.NoError(t, err)
+	storage := NewMemStorage()
+	require.NoError(t, wal.Replay(func(l []schema.Metrics) error {
+		return storage.BulkPut(context.Background(), l)
+	}))
+	return storage.WithWAL(wal), wal
+}
+
+func TestMemStorage_WAL(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	storage, _ := replayed(t, dir)
+	require.NoError(t, storage.Put(ctx, schema.NewCounter("PollCount", 1)))
+	require.NoError(t, storage.Increment(ctx, schema.NewCounter("PollCount", 0), 2))
+	require.NoError(t, storage.BulkPut(ctx, []schema.Metrics{schema.NewGauge("Alloc", 1)}))
+	require.NoError(t, storage.BulkUpdate(ctx,
+		[]schema.Metrics{schema.NewCounter("PollCount", 3), schema.NewCounter("PollCount", 4)},
+		[]schema.Metrics{schema.NewGauge("Alloc", 2)}, nil, nil))
+	expected, err := storage.List(ctx)
+	require.NoError(t, err)
+	// storage is not closed to simulate a crash
+
+	restored, _ := replayed(t, dir)
+	actual, err := restored.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	// the log is not affected by failed updates
+	assert.Error(t, restored.Increment(ctx, schema.NewGauge("Alloc", 0), 1))
+	require.NoError(t, restored.Close())
+	restored, _ = replayed(t, dir)
+	actual, err = restored.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestMemStorage_Checkpoint(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	storage, _ := replayed(t, dir)
+	require.NoError(t, storage.Put(ctx, schema.NewCounter("PollCount", 1)))
+	compact, err := storage.Checkpoint()
+	require.NoError(t, err)
+	snapshot, err := storage.List(ctx)
+	require.NoError(t, err)
+	require.NoError(t, storage.Put(ctx, schema.NewGauge("Alloc", 1)))
+	require.NoError(t, compact())
+	require.NoError(t, storage.Close())
+
+	// only updates made after the checkpoint are left in the log
+	restored := NewMemStorage()
+	require.NoError(t, restored.BulkPut(ctx, snapshot))
+	wal, err := OpenWAL(dir, 0)
+	require.NoError(t, err)
+	var records [][]schema.Metrics
+	require.NoError(t, wal.Replay(func(l []schema.Metrics) error {
+		records = append(records, l)
+		return restored.BulkPut(ctx, l)
+	}))
+	assert.Equal(t, [][]schema.Metrics{{schema.NewGauge("Alloc", 1)}}, records)
+	value, err := restored.Extract(ctx, schema.NewCounterRequest("PollCount"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *value.Delta)
+
+	require.NoError(t, wal.Discard())
+	segments, err := walSegments(dir)
+	require.NoError(t, err)
+	assert.Len(t, segments, 1, "only the current segment should be left")
+}
+
+func TestWAL_IncompleteRecord(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := OpenWAL(dir, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, wal.Append([]schema.Metrics{schema.NewCounter("PollCount", 1)}))
+	require.NoError(t, wal.Close())
+
+	// crash in the middle of the write
+	f, err := os.OpenFile(filepath.Join(dir, "wal-00000000000000000001.log"), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`[{"id":"PollCount","type":"coun`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	storage, wal := replayed(t, dir)
+	defer wal.Close()
+	value, err := storage.Extract(context.Background(), schema.NewCounterRequest("PollCount"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *value.Delta)
+}
+
+func TestMemStorage_CheckpointKeep(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	storage, wal := replayed(t, dir)
+	wal.WithKeep(1)
+	var snapshots [][]schema.Metrics
+	dump := func() {
+		compact, err := storage.Checkpoint()
+		require.NoError(t, err)
+		snapshot, err := storage.List(ctx)
+		require.NoError(t, err)
+		snapshots = append(snapshots, snapshot)
+		require.NoError(t, compact())
+	}
+
+	require.NoError(t, storage.Put(ctx, schema.NewCounter("PollCount", 1)))
+	dump()
+	// the counter is not updated after the latest dump, so it is logged only before it
+	require.NoError(t, storage.Put(ctx, schema.NewCounter("Frees", 5)))
+	dump()
+	require.NoError(t, storage.Increment(ctx, schema.NewCounterRequest("PollCount"), 3))
+	require.NoError(t, storage.Close())
+
+	// the latest snapshot is corrupted, so the previous one is restored
+	// and the log should bring it up to date
+	restored := NewMemStorage()
+	require.NoError(t, restored.BulkPut(ctx, snapshots[0]))
+	wal, err := OpenWAL(dir, 0)
+	require.NoError(t, err)
+	require.NoError(t, wal.Replay(func(l []schema.Metrics) error {
+		return restored.BulkPut(ctx, l)
+	}))
+	actual, err := restored.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []schema.Metrics{schema.NewCounter("Frees", 5), schema.NewCounter("PollCount", 4)}, actual)
+
+	// segments older than the checkpoint of the previous dump are compacted
+	storage = restored.WithWAL(wal.WithKeep(1))
+	dump()
+	dump()
+	segments, err := walSegments(dir)
+	require.NoError(t, err)
+	assert.Len(t, segments, 2)
+	require.NoError(t, storage.Close())
+}